@@ -8,13 +8,22 @@ import (
 	"github.com/hamba/cmd/v3/observe"
 	"github.com/hamba/logger/v2"
 	lctx "github.com/hamba/logger/v2/ctx"
+	errors2 "github.com/hamba/pkg/v2/errors"
 	"github.com/hamba/statter/v2"
+	"github.com/hamba/statter/v2/tags"
 	"gitlab.com/sickit/token-operator/pkg/source"
 	"gitlab.com/sickit/token-operator/pkg/token"
 	"gitlab.com/sickit/token-operator/pkg/vault"
 	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	ErrStatusStoreRequired = errors2.Error("overlap rotation requires a status store")
+	ErrRecoveryDisabled    = errors2.Error("token recovery is disabled")
+	ErrExpiryUntracked     = errors2.Error("token has no expiration, a status store is required to track its rotation")
+	ErrInactiveUntracked   = errors2.Error("revoking inactive tokens requires a status store")
+)
+
 // interface for tokenSource
 type TokenSource interface {
 	GetToken(source *token.Source) (*token.Token, error)
@@ -23,28 +32,61 @@ type TokenSource interface {
 	DeleteToken(source *token.Source) error
 }
 
+// TokenRevoker is implemented by sources that emulate rotation by creating a new token.
+// The replaced token is revoked once the new token is stored in the vault.
+type TokenRevoker interface {
+	RevokeToken(source *token.Source, tok *token.Token) error
+}
+
+// ExpiryReporter is implemented by sources with tokens that don't report their expiry, e.g. secrets that never expire
+// and have no creation time. The expiry of such tokens is tracked in the status store, which is required for them.
+type ExpiryReporter interface {
+	ReportsExpiry(source *token.Source) bool
+}
+
+// Preflighter is implemented by sources that check their own token covers the configured tokens.
+type Preflighter interface {
+	Preflight(configs []token.Config) error
+}
+
 // interface for tokenVault
 type TokenVault interface {
 	WithDryRun(dryRun bool)
 	GetItem(vault *token.Vault) (*vault.Item, error)
-	CreateItem(vault *token.Vault, value string) (*vault.Item, error)
-	UpdateItem(vault *token.Vault, value string) error
+	CreateItem(vault *token.Vault, tok *token.Token) (*vault.Item, error)
+	UpdateItem(vault *token.Vault, tok *token.Token) error
 	DeleteItem(vault *token.Vault) error
 }
 
+// StatusStore persists the status of tokens between runs.
+type StatusStore interface {
+	GetStatus(name string) (*token.Status, error)
+	SetStatus(name string, status *token.Status) error
+}
+
 // Application represents the application.
 type Application struct {
 	tokenSource TokenSource
 	tokenVault  TokenVault
+	statusStore StatusStore
 
 	log    *logger.Logger
 	stats  *statter.Statter
 	tracer trace.Tracer
 }
 
+type ApplicationOption func(*Application)
+
+// WithStatusStore persists token status, required for the overlap rotation strategy.
+func WithStatusStore(store StatusStore) ApplicationOption {
+	return func(a *Application) {
+		a.statusStore = store
+	}
+}
+
 // NewApplication creates an instance of Application.
-func NewApplication(source TokenSource, vault TokenVault, obsvr *observe.Observer) *Application {
-	return &Application{
+func NewApplication(source TokenSource, vault TokenVault, obsvr *observe.Observer, opts ...ApplicationOption) *Application {
+	app := &Application{
 		tokenSource: source,
 		tokenVault:  vault,
 
@@ -52,44 +94,186 @@ func NewApplication(source TokenSource, vault TokenVault, obsvr *observe.Observe
 		stats:  obsvr.Stats,
 		tracer: obsvr.Tracer("app"),
 	}
+
+	for _, opt := range opts {
+		opt(app)
+	}
+
+	return app
+}
+
+// Outcome is the result of reconciling a token.
+type Outcome string
+
+const (
+	OutcomeUnchanged Outcome = "unchanged"
+	OutcomeRotated   Outcome = "rotated"
+	OutcomeCreated   Outcome = "created"
+	// OutcomeRecovered means an expired or revoked token was replaced, consumers may have failed in the meantime.
+	OutcomeRecovered Outcome = "recovered"
+	OutcomeDeleted   Outcome = "deleted"
+	OutcomeSkipped   Outcome = "skipped"
+	OutcomeFailed    Outcome = "failed"
+	// OutcomeInactive means the token was not used within the inactivity period, it was not rotated.
+	OutcomeInactive Outcome = "inactive"
+)
+
+// Exit codes of a failed reconciliation, following sysexits.h.
+const (
+	ExitFailure     = 1
+	ExitTempFailure = 75
+	ExitNoPerm      = 77
+	ExitConfig      = 78
+)
+
+// ExitCode returns the exit code for an error, based on the token.Error it wraps.
+// Retriable errors exit with ExitTempFailure, so a scheduler may retry the run.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case token.IsRetriable(err):
+		return ExitTempFailure
+	}
+
+	switch token.KindOf(err) {
+	case token.ErrorKindUnauthorized, token.ErrorKindForbidden:
+		return ExitNoPerm
+	case token.ErrorKindInvalid:
+		return ExitConfig
+	}
+	return ExitFailure
 }
 
 // Reconcile token based on its state.
-func (a *Application) Reconcile(cfg token.Config) error {
+func (a *Application) Reconcile(cfg token.Config) (Outcome, error) {
+	outcome, err := a.reconcile(cfg)
+	if a.stats == nil {
+		return outcome, err
+	}
+
+	t := []statter.Tag{tags.Str("outcome", string(outcome)), tags.Str("type", cfg.Source.Type)}
+	if err != nil {
+		t = append(t, tags.Str("kind", string(token.KindOf(err))))
+		var operr *token.Error
+		if errors.As(err, &operr) {
+			t = append(t, tags.Str("backend", operr.Backend), tags.Str("op", operr.Op))
+		}
+	}
+	a.stats.Counter("tokens.reconciled", t...).Inc(1)
+
+	return outcome, err
+}
+
+func (a *Application) reconcile(cfg token.Config) (Outcome, error) {
 	switch cfg.State {
 	case token.TokenStateInactive:
 		a.log.Info("token state is inactive, skipping", lctx.Str("name", cfg.Name))
-		return nil
+		return OutcomeSkipped, nil
 	case token.TokenStateDeleted:
-		return a.Delete(cfg)
+		if err := a.Delete(cfg); err != nil {
+			return OutcomeFailed, err
+		}
+		return OutcomeDeleted, nil
 	case token.TokenStateActive:
 		return a.Update(cfg)
 	}
 
-	return fmt.Errorf("invalid token state: %s", cfg.State)
+	return OutcomeFailed, fmt.Errorf("invalid token state: %s", cfg.State)
 }
 
 // Update rotates the given token, if needed, and updates it in the configured vault.
-func (a *Application) Update(cfg token.Config) error {
+func (a *Application) Update(cfg token.Config) (Outcome, error) {
 	vaultItemExists := true
 	tokenExists := true
 
+	if err := a.revokePending(cfg); err != nil {
+		return OutcomeFailed, err
+	}
+
+	// tokens without reported expiry would be rotated on every run
+	if reporter, ok := a.tokenSource.(ExpiryReporter); ok && !reporter.ReportsExpiry(&cfg.Source) && a.statusStore == nil {
+		return OutcomeFailed, ErrExpiryUntracked
+	}
+	// without status, a revoked inactive token would be recreated on the next run
+	if cfg.Rotation.RevokeInactive && a.statusStore == nil {
+		return OutcomeFailed, ErrInactiveUntracked
+	}
+
+	// follow the token resolved in the previous run, its ID changes on rotation
+	pinned := cfg.Source.ID
+	var status *token.Status
+	if a.statusStore != nil {
+		var err error
+		status, err = a.statusStore.GetStatus(cfg.Name)
+		if err != nil {
+			return OutcomeFailed, fmt.Errorf("failed to get status: %w", err)
+		}
+		cfg.Source.LastID = status.SourceID
+
+		// a pinned token changes its ID on rotation, and a token replaced by an overlapping rotation stays active
+		// for its grace period, follow the token recorded for the pin instead
+		replaced := status.PinnedID == pinned || pendingRevocation(status, pinned)
+		if pinned != "" && status.SourceID != "" && status.SourceID != pinned && replaced {
+			a.log.Warn("pinned token was replaced, update source.id", lctx.Str("name", cfg.Name), lctx.Str("pinned", cfg.Source.ID), lctx.Str("id", status.SourceID))
+			cfg.Source.ID = status.SourceID
+		}
+	}
+
 	itm, err := a.tokenVault.GetItem(&cfg.Vault)
 	if err != nil {
 		if !errors.Is(err, vault.ErrItemNotFound) {
-			return fmt.Errorf("failed to get vault item: %w", err)
+			return OutcomeFailed, fmt.Errorf("failed to get vault item: %w", err)
 		}
 		vaultItemExists = false
 	}
 
+	outcome := OutcomeRotated
 	tok, err := a.tokenSource.GetToken(&cfg.Source)
 	if err != nil {
-		if !errors.Is(err, source.ErrTokenNotFound) {
-			return fmt.Errorf("failed to get token: %w", err)
+		// a token revoked as inactive is neither recovered nor recreated, unless it was pinned to another token since
+		missing := errors.Is(err, source.ErrTokenNotFound) || errors.Is(err, source.ErrTokenExpired) || errors.Is(err, source.ErrTokenRevoked)
+		if missing && status != nil && status.RevokedInactiveAt != "" && (cfg.Source.ID == "" || cfg.Source.ID == status.SourceID) {
+			a.log.Info("skipping token revoked as inactive",
+				lctx.Str("name", cfg.Name),
+				lctx.Str("revokedAt", status.RevokedInactiveAt),
+			)
+			return OutcomeInactive, nil
+		}
+
+		switch {
+		case errors.Is(err, source.ErrTokenNotFound):
+			outcome = OutcomeCreated
+		case errors.Is(err, source.ErrTokenExpired), errors.Is(err, source.ErrTokenRevoked):
+			if cfg.Recovery == token.RecoveryPolicyFail {
+				a.log.Error("token expired or was revoked, recovery disabled", lctx.Str("name", cfg.Name), lctx.Err(err))
+				return OutcomeFailed, fmt.Errorf("%w: %w", ErrRecoveryDisabled, err)
+			}
+			a.log.Warn("token expired or was revoked, creating a replacement", lctx.Str("name", cfg.Name), lctx.Err(err))
+			outcome = OutcomeRecovered
+		default:
+			return OutcomeFailed, fmt.Errorf("failed to get token: %w", err)
 		}
 		tokenExists = false
 	}
 
+	// tokens without expiration are rotated once the validity since their creation has passed
+	if tokenExists && tok.Expiration.IsZero() && !tok.Created.IsZero() {
+		tok.Expiration = tok.Created.Add(cfg.Rotation.Lifetime())
+	}
+	// tokens without expiration and creation time expire as recorded on their last rotation
+	if tokenExists && tok.Expiration.IsZero() && status != nil && status.SourceID == tok.ID {
+		if expires, err := time.Parse(time.RFC3339, status.ExpiresAt); err == nil {
+			tok.Expiration = expires
+		}
+	}
+
+	if tokenExists && cfg.Rotation.InactiveAfter > 0 {
+		if lastActivity := tok.LastActivity(); !lastActivity.IsZero() && time.Since(lastActivity) > cfg.Rotation.InactiveAfter {
+			return OutcomeInactive, a.handleInactive(cfg, pinned, tok)
+		}
+	}
+
 	switch {
 	case tokenExists && vaultItemExists:
 		if tok.Expiration.After(time.Now().Add(cfg.Rotation.RotateBefore)) && itm != nil && itm.Value != "" {
@@ -99,8 +283,9 @@ func (a *Application) Update(cfg token.Config) error {
 				lctx.Duration("rotateBefore", cfg.Rotation.RotateBefore),
 				lctx.Duration("expireDuration", time.Until(tok.Expiration)),
 				lctx.Str("expireDate", tok.Expiration.String()),
+				lctx.Str("lastUsed", formatTime(tok.LastUsed)),
 			)
-			return nil
+			return OutcomeUnchanged, a.recordStatus(cfg, pinned, tok)
 		}
 
 		a.log.Info("rotating token",
@@ -109,15 +294,19 @@ func (a *Application) Update(cfg token.Config) error {
 			lctx.Duration("expireDuration", time.Until(tok.Expiration)),
 			lctx.Str("expireDate", tok.Expiration.String()),
 		)
-		tok, err = a.tokenSource.RotateToken(&cfg)
+		tok, err = a.rotate(cfg, tok)
 		if err != nil {
-			return fmt.Errorf("failed to rotate token: %w", err)
+			return OutcomeFailed, fmt.Errorf("failed to rotate token: %w", err)
 		}
 
 		a.log.Info("updating vault item", lctx.Str("path", cfg.Vault.Path), lctx.Str("item", cfg.Vault.Item))
-		err = a.tokenVault.UpdateItem(&cfg.Vault, tok.Value)
+		err = a.tokenVault.UpdateItem(&cfg.Vault, tok)
 		if err != nil {
-			return fmt.Errorf("failed to update vault item: %w", err)
+			return OutcomeFailed, fmt.Errorf("failed to update vault item: %w", err)
+		}
+
+		if err = a.revokeReplaced(cfg, tok); err != nil {
+			return OutcomeFailed, err
 		}
 
 	case tokenExists && !vaultItemExists:
@@ -127,52 +316,64 @@ func (a *Application) Update(cfg token.Config) error {
 			lctx.Duration("expireDuration", time.Until(tok.Expiration)),
 			lctx.Str("expireDate", tok.Expiration.String()),
 		)
-		tok, err = a.tokenSource.RotateToken(&cfg)
+		tok, err = a.rotate(cfg, tok)
 		if err != nil {
-			return fmt.Errorf("failed to rotate token: %w", err)
+			return OutcomeFailed, fmt.Errorf("failed to rotate token: %w", err)
 		}
 
 		a.log.Info("creating vault item", lctx.Str("path", cfg.Vault.Path), lctx.Str("item", cfg.Vault.Item))
-		_, err = a.tokenVault.CreateItem(&cfg.Vault, tok.Value)
+		_, err = a.tokenVault.CreateItem(&cfg.Vault, tok)
 		if err != nil {
-			return fmt.Errorf("failed to create vault item: %w", err)
+			return OutcomeFailed, fmt.Errorf("failed to create vault item: %w", err)
+		}
+
+		if err = a.revokeReplaced(cfg, tok); err != nil {
+			return OutcomeFailed, err
 		}
 
 	case !tokenExists && vaultItemExists:
 		a.log.Info("creating new token", lctx.Str("name", cfg.Name))
 		tok, err = a.tokenSource.CreateToken(&cfg)
 		if err != nil {
-			return fmt.Errorf("failed to create token: %w", err)
+			return OutcomeFailed, fmt.Errorf("failed to create token: %w", err)
 		}
 
 		a.log.Info("updating vault item", lctx.Str("path", cfg.Vault.Path), lctx.Str("item", cfg.Vault.Item))
-		err = a.tokenVault.UpdateItem(&cfg.Vault, tok.Value)
+		err = a.tokenVault.UpdateItem(&cfg.Vault, tok)
 		if err != nil {
-			return fmt.Errorf("failed to update vault item: %w", err)
+			return OutcomeFailed, fmt.Errorf("failed to update vault item: %w", err)
+		}
+
+		if err = a.revokeReplaced(cfg, tok); err != nil {
+			return OutcomeFailed, err
 		}
 
 	case !tokenExists && !vaultItemExists:
 		a.log.Info("creating new token", lctx.Str("name", cfg.Name))
 		tok, err = a.tokenSource.CreateToken(&cfg)
 		if err != nil {
-			return fmt.Errorf("failed to create token: %w", err)
+			return OutcomeFailed, fmt.Errorf("failed to create token: %w", err)
 		}
 
 		a.log.Info("creating vault item", lctx.Str("path", cfg.Vault.Path), lctx.Str("item", cfg.Vault.Item))
-		_, err = a.tokenVault.CreateItem(&cfg.Vault, tok.Value)
+		_, err = a.tokenVault.CreateItem(&cfg.Vault, tok)
 		if err != nil {
-			return fmt.Errorf("failed to create vault item: %w", err)
+			return OutcomeFailed, fmt.Errorf("failed to create vault item: %w", err)
+		}
+
+		if err = a.revokeReplaced(cfg, tok); err != nil {
+			return OutcomeFailed, err
 		}
 	}
 
-	return nil
+	return outcome, a.recordStatus(cfg, pinned, tok)
 }
 
 // Delete removes a token from source and vault.
 func (a *Application) Delete(cfg token.Config) error {
 	a.log.Info("deleting token in source", lctx.Str("cfg", cfg.Name))
 	if err := a.tokenSource.DeleteToken(&cfg.Source); err != nil {
-		if !errors.Is(err, source.ErrTokenNotFound) {
+		if !errors.Is(err, source.ErrTokenNotFound) && !errors.Is(err, source.ErrTokenExpired) && !errors.Is(err, source.ErrTokenRevoked) {
 			return fmt.Errorf("failed to delete token: %w", err)
 		}
 		a.log.Debug("token already deleted", lctx.Str("cfg", cfg.Name))
@@ -189,6 +390,196 @@ func (a *Application) Delete(cfg token.Config) error {
 	return nil
 }
 
+// rotate rotates a token with the configured strategy. With the overlap strategy a new token is created,
+// the previous token is revoked after the grace period, see revokeReplaced.
+func (a *Application) rotate(cfg token.Config, previous *token.Token) (*token.Token, error) {
+	if cfg.Rotation.Strategy != token.RotationStrategyOverlap {
+		return a.tokenSource.RotateToken(&cfg)
+	}
+
+	if a.statusStore == nil {
+		return nil, ErrStatusStoreRequired
+	}
+
+	tok, err := a.tokenSource.CreateToken(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	tok.Replaces = previous
+
+	return tok, nil
+}
+
+// revokeReplaced revokes the token replaced by an emulated rotation, after the new token was stored.
+// With the overlap strategy, the revocation is scheduled for a later run once the grace period has passed.
+func (a *Application) revokeReplaced(cfg token.Config, tok *token.Token) error {
+	if tok.Replaces == nil {
+		return nil
+	}
+
+	if cfg.Rotation.Strategy == token.RotationStrategyOverlap {
+		return a.scheduleRevocation(cfg, tok.Replaces)
+	}
+
+	revoker, ok := a.tokenSource.(TokenRevoker)
+	if !ok {
+		a.log.Warn("token source can't revoke replaced token", lctx.Str("name", cfg.Name), lctx.Str("id", tok.Replaces.ID))
+		return nil
+	}
+
+	a.log.Info("revoking replaced token", lctx.Str("name", cfg.Name), lctx.Str("id", tok.Replaces.ID))
+	if err := revoker.RevokeToken(&cfg.Source, tok.Replaces); err != nil {
+		return fmt.Errorf("failed to revoke replaced token: %w", err)
+	}
+
+	return nil
+}
+
+// handleInactive stops rotating a token that was not used within the inactivity period.
+// With RevokeInactive, the token is revoked and recorded, so it is not recreated on the next run.
+func (a *Application) handleInactive(cfg token.Config, pinned string, tok *token.Token) error {
+	a.log.Warn("token is inactive, not rotating",
+		lctx.Str("name", cfg.Name),
+		lctx.Str("lastUsed", formatTime(tok.LastUsed)),
+		lctx.Strs("lastUsedIPs", tok.LastUsedIPs),
+		lctx.Duration("inactiveAfter", cfg.Rotation.InactiveAfter),
+	)
+
+	if err := a.recordStatus(cfg, pinned, tok); err != nil {
+		return err
+	}
+	if !cfg.Rotation.RevokeInactive {
+		return nil
+	}
+
+	a.log.Info("revoking inactive token", lctx.Str("name", cfg.Name), lctx.Str("id", tok.ID))
+	if err := a.tokenSource.DeleteToken(&cfg.Source); err != nil {
+		return fmt.Errorf("failed to revoke inactive token: %w", err)
+	}
+
+	status, err := a.statusStore.GetStatus(cfg.Name)
+	if err != nil {
+		return fmt.Errorf("failed to get status: %w", err)
+	}
+	status.RevokedInactiveAt = time.Now().Format(time.RFC3339)
+	if err = a.statusStore.SetStatus(cfg.Name, status); err != nil {
+		return fmt.Errorf("failed to set status: %w", err)
+	}
+
+	return nil
+}
+
+// recordStatus writes the resolved token ID and expiration, and the configured pin it was resolved from, to the status.
+// A resolved token is active, a previous revocation as inactive no longer applies.
+func (a *Application) recordStatus(cfg token.Config, pinned string, tok *token.Token) error {
+	// dry-run tokens have no ID
+	if a.statusStore == nil || tok.ID == "" {
+		return nil
+	}
+
+	status, err := a.statusStore.GetStatus(cfg.Name)
+	if err != nil {
+		return fmt.Errorf("failed to get status: %w", err)
+	}
+
+	expiresAt := formatTime(tok.Expiration)
+	lastUsedAt := formatTime(tok.LastUsed)
+	if status.SourceID == tok.ID && status.ExpiresAt == expiresAt && status.LastUsedAt == lastUsedAt && status.PinnedID == pinned && status.RevokedInactiveAt == "" {
+		return nil
+	}
+
+	status.SourceID = tok.ID
+	status.ExpiresAt = expiresAt
+	status.LastUsedAt = lastUsedAt
+	status.PinnedID = pinned
+	status.RevokedInactiveAt = ""
+	if err = a.statusStore.SetStatus(cfg.Name, status); err != nil {
+		return fmt.Errorf("failed to set status: %w", err)
+	}
+
+	return nil
+}
+
+// scheduleRevocation stores the previous token of an overlapping rotation as pending revocation.
+func (a *Application) scheduleRevocation(cfg token.Config, previous *token.Token) error {
+	status, err := a.statusStore.GetStatus(cfg.Name)
+	if err != nil {
+		return fmt.Errorf("failed to get status: %w", err)
+	}
+
+	revokeAfter := time.Now().Add(cfg.Rotation.GracePeriod)
+	status.PendingRevocations = append(status.PendingRevocations, token.Revocation{
+		ID:          previous.ID,
+		Owner:       previous.Owner,
+		RevokeAfter: revokeAfter,
+	})
+
+	a.log.Info("scheduled revocation of replaced token", lctx.Str("name", cfg.Name), lctx.Str("id", previous.ID), lctx.Str("revokeAfter", revokeAfter.String()))
+	if err = a.statusStore.SetStatus(cfg.Name, status); err != nil {
+		return fmt.Errorf("failed to set status: %w", err)
+	}
+
+	return nil
+}
+
+// revokePending revokes previous tokens of overlapping rotations whose grace period has passed.
+func (a *Application) revokePending(cfg token.Config) error {
+	if a.statusStore == nil {
+		return nil
+	}
+
+	status, err := a.statusStore.GetStatus(cfg.Name)
+	if err != nil {
+		return fmt.Errorf("failed to get status: %w", err)
+	}
+	if len(status.PendingRevocations) == 0 {
+		return nil
+	}
+
+	revoker, ok := a.tokenSource.(TokenRevoker)
+	if !ok {
+		a.log.Warn("token source can't revoke replaced tokens", lctx.Str("name", cfg.Name))
+		return nil
+	}
+
+	pending := []token.Revocation{}
+	for _, rev := range status.PendingRevocations {
+		if time.Now().Before(rev.RevokeAfter) {
+			a.log.Debug("grace period of replaced token not passed", lctx.Str("name", cfg.Name), lctx.Str("id", rev.ID), lctx.Str("revokeAfter", rev.RevokeAfter.String()))
+			pending = append(pending, rev)
+			continue
+		}
+
+		a.log.Info("revoking replaced token after grace period", lctx.Str("name", cfg.Name), lctx.Str("id", rev.ID))
+		err = revoker.RevokeToken(&cfg.Source, &token.Token{ID: rev.ID, Owner: rev.Owner, Name: cfg.Source.Name, Type: cfg.Source.Type})
+		if err != nil && !errors.Is(err, source.ErrNotFound) {
+			// keep the revocation pending for the next run
+			a.log.Error("failed to revoke replaced token", lctx.Str("name", cfg.Name), lctx.Str("id", rev.ID), lctx.Err(err))
+			pending = append(pending, rev)
+		}
+	}
+
+	if len(pending) == len(status.PendingRevocations) {
+		return nil
+	}
+	status.PendingRevocations = pending
+	if err = a.statusStore.SetStatus(cfg.Name, status); err != nil {
+		return fmt.Errorf("failed to set status: %w", err)
+	}
+
+	return nil
+}
+
+// pendingRevocation returns true if the token is a replaced token awaiting revocation.
+func pendingRevocation(status *token.Status, id string) bool {
+	for _, rev := range status.PendingRevocations {
+		if rev.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
 func maskToken(token string) string {
 	const gitlab_prefix = "glpat-"
 	if token[0:len(gitlab_prefix)] == gitlab_prefix {
@@ -197,3 +588,11 @@ func maskToken(token string) string {
 
 	return token[0:1] + "..." + token[len(token)-1:]
 }
+
+// formatTime formats t as RFC 3339, or returns an empty string for the zero time.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
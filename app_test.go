@@ -1,6 +1,8 @@
 package token_operator
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"testing"
 	"time"
@@ -110,13 +112,433 @@ func TestApplication_Reconcile(t *testing.T) {
 				stats:       tt.fields.stats,
 				tracer:      tt.fields.tracer,
 			}
-			if err := a.Reconcile(tt.args.cfg); (err != nil) != tt.wantErr {
+			if _, err := a.Reconcile(tt.args.cfg); (err != nil) != tt.wantErr {
 				t.Errorf("Update() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
+func TestApplication_UpdateRevokesReplacedToken(t *testing.T) {
+	cfg := simpleConfigPersonal()
+	previous := expiredTokenFromConfig(cfg)
+	previous.ID = "1"
+	src := &MockRevokingTokenSource{MockTokenSource: NewMockTokenSource(previous)}
+	vlt := NewMockTokenVault(vaultItemFromConfig(cfg))
+
+	a := &Application{
+		tokenSource: src,
+		tokenVault:  vlt,
+		log:         logger.New(os.Stdout, logger.LogfmtFormat(), logger.Debug),
+	}
+	if _, err := a.Update(cfg); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	if len(src.revoked) != 1 || src.revoked[0] != "1" {
+		t.Errorf("revoked = %v, want [1]", src.revoked)
+	}
+	if vlt.item.Value != "secret-rotated" {
+		t.Errorf("vault value = %v, want secret-rotated", vlt.item.Value)
+	}
+	// deploy tokens get a new username with every token
+	if vlt.item.Username != "gitlab+deploy-token-2" {
+		t.Errorf("vault username = %v, want gitlab+deploy-token-2", vlt.item.Username)
+	}
+}
+
+func TestApplication_UpdateDerivesExpirationFromCreation(t *testing.T) {
+	cfg := simpleConfigPersonal()
+	log := logger.New(os.Stdout, logger.LogfmtFormat(), logger.Debug)
+
+	// created recently, still valid
+	recent := validTokenFromConfig(cfg)
+	recent.Expiration = time.Time{}
+	recent.Created = time.Now().Add(-time.Hour)
+	vlt := NewMockTokenVault(vaultItemFromConfig(cfg))
+	a := &Application{tokenSource: NewMockTokenSource(recent), tokenVault: vlt, log: log}
+	if _, err := a.Update(cfg); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if vlt.item.Value == "secret-rotated" {
+		t.Errorf("vault value = %v, want token not to be rotated", vlt.item.Value)
+	}
+
+	// created before the validity, due for rotation
+	old := validTokenFromConfig(cfg)
+	old.Expiration = time.Time{}
+	old.Created = time.Now().Add(-cfg.Rotation.Validity)
+	vlt = NewMockTokenVault(vaultItemFromConfig(cfg))
+	a = &Application{tokenSource: NewMockTokenSource(old), tokenVault: vlt, log: log}
+	if _, err := a.Update(cfg); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if vlt.item.Value != "secret-rotated" {
+		t.Errorf("vault value = %v, want secret-rotated", vlt.item.Value)
+	}
+}
+
+func TestApplication_UpdateOverlapSchedulesRevocation(t *testing.T) {
+	cfg := simpleConfigPersonal()
+	cfg.Rotation.Strategy = token.RotationStrategyOverlap
+	cfg.Rotation.GracePeriod = time.Hour
+	previous := expiredTokenFromConfig(cfg)
+	previous.ID = "1"
+	src := &MockRevokingTokenSource{MockTokenSource: NewMockTokenSource(previous)}
+	vlt := NewMockTokenVault(vaultItemFromConfig(cfg))
+	store := NewMockStatusStore()
+
+	a := &Application{
+		tokenSource: src,
+		tokenVault:  vlt,
+		statusStore: store,
+		log:         logger.New(os.Stdout, logger.LogfmtFormat(), logger.Debug),
+	}
+	if _, err := a.Update(cfg); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	if vlt.item.Value != "secret" {
+		t.Errorf("vault value = %v, want secret of the new token", vlt.item.Value)
+	}
+	if len(src.revoked) != 0 {
+		t.Errorf("revoked = %v, want no revocation during grace period", src.revoked)
+	}
+	pending := store.statuses[cfg.Name].PendingRevocations
+	if len(pending) != 1 || pending[0].ID != "1" {
+		t.Fatalf("pending revocations = %v, want [1]", pending)
+	}
+
+	// grace period not passed yet
+	if _, err := a.Update(cfg); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if len(src.revoked) != 0 {
+		t.Errorf("revoked = %v, want no revocation during grace period", src.revoked)
+	}
+
+	store.statuses[cfg.Name].PendingRevocations[0].RevokeAfter = time.Now().Add(-time.Minute)
+	if _, err := a.Update(cfg); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if len(src.revoked) != 1 || src.revoked[0] != "1" {
+		t.Errorf("revoked = %v, want [1]", src.revoked)
+	}
+	if len(store.statuses[cfg.Name].PendingRevocations) != 0 {
+		t.Errorf("pending revocations = %v, want none", store.statuses[cfg.Name].PendingRevocations)
+	}
+}
+
+func TestApplication_UpdateOverlapRequiresStatusStore(t *testing.T) {
+	cfg := simpleConfigPersonal()
+	cfg.Rotation.Strategy = token.RotationStrategyOverlap
+	cfg.Rotation.GracePeriod = time.Hour
+
+	a := &Application{
+		tokenSource: NewMockTokenSource(expiredTokenFromConfig(cfg)),
+		tokenVault:  NewMockTokenVault(vaultItemFromConfig(cfg)),
+		log:         logger.New(os.Stdout, logger.LogfmtFormat(), logger.Debug),
+	}
+	if _, err := a.Update(cfg); !errors.Is(err, ErrStatusStoreRequired) {
+		t.Errorf("Update() error = %v, want %v", err, ErrStatusStoreRequired)
+	}
+}
+
+func TestApplication_UpdateRecordsStatus(t *testing.T) {
+	cfg := simpleConfigPersonal()
+	tok := validTokenFromConfig(cfg)
+	tok.ID = "42"
+	src := &MockLastIDTokenSource{MockTokenSource: NewMockTokenSource(tok)}
+	store := NewMockStatusStore()
+	store.statuses[cfg.Name] = &token.Status{SourceID: "41"}
+
+	a := &Application{
+		tokenSource: src,
+		tokenVault:  NewMockTokenVault(vaultItemFromConfig(cfg)),
+		statusStore: store,
+		log:         logger.New(os.Stdout, logger.LogfmtFormat(), logger.Debug),
+	}
+	if _, err := a.Update(cfg); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	if src.lastID != "41" {
+		t.Errorf("last ID = %v, want 41 from status", src.lastID)
+	}
+	if got := store.statuses[cfg.Name].SourceID; got != "42" {
+		t.Errorf("status token ID = %v, want 42", got)
+	}
+	if got := store.statuses[cfg.Name].ExpiresAt; got != tok.Expiration.Format(time.RFC3339) {
+		t.Errorf("status expires at = %v, want %v", got, tok.Expiration.Format(time.RFC3339))
+	}
+}
+
+func TestApplication_UpdateFollowsReplacedPin(t *testing.T) {
+	tests := []struct {
+		name   string
+		status token.Status
+		want   string
+	}{
+		{name: "pinned token replaced", status: token.Status{SourceID: "42", PendingRevocations: []token.Revocation{{ID: "41", RevokeAfter: time.Now().Add(time.Hour)}}}, want: "42"},
+		{name: "pinned token rotated", status: token.Status{SourceID: "42", PinnedID: "41"}, want: "42"},
+		{name: "pinned token not replaced", status: token.Status{SourceID: "42"}, want: "41"},
+		{name: "other token pinned before", status: token.Status{SourceID: "42", PinnedID: "40"}, want: "41"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := simpleConfigPersonal()
+			cfg.Source.ID = "41"
+			tok := validTokenFromConfig(cfg)
+			tok.ID = "42"
+			src := &MockLastIDTokenSource{MockTokenSource: NewMockTokenSource(tok)}
+			store := NewMockStatusStore()
+			store.statuses[cfg.Name] = &tt.status
+
+			a := &Application{
+				tokenSource: src,
+				tokenVault:  NewMockTokenVault(vaultItemFromConfig(cfg)),
+				statusStore: store,
+				log:         logger.New(os.Stdout, logger.LogfmtFormat(), logger.Debug),
+			}
+			if _, err := a.Update(cfg); err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+
+			if src.id != tt.want {
+				t.Errorf("pinned ID = %v, want %v", src.id, tt.want)
+			}
+			if got := store.statuses[cfg.Name].PinnedID; got != "41" {
+				t.Errorf("status pinned ID = %v, want 41", got)
+			}
+		})
+	}
+}
+
+func TestApplication_UpdateUsesRecordedExpiration(t *testing.T) {
+	cfg := simpleConfigPersonal()
+	cfg.Source.Type = source.TypeOAuthApplication
+	log := logger.New(os.Stdout, logger.LogfmtFormat(), logger.Debug)
+
+	// oauth application secrets have neither expiration nor creation time
+	tok := validTokenFromConfig(cfg)
+	tok.ID = "7"
+	tok.Expiration = time.Time{}
+
+	src := &MockUntrackedTokenSource{MockTokenSource: NewMockTokenSource(tok)}
+	a := &Application{tokenSource: src, tokenVault: NewMockTokenVault(vaultItemFromConfig(cfg)), log: log}
+	if _, err := a.Update(cfg); !errors.Is(err, ErrExpiryUntracked) {
+		t.Fatalf("Update() error = %v, want %v", err, ErrExpiryUntracked)
+	}
+
+	store := NewMockStatusStore()
+	store.statuses[cfg.Name] = &token.Status{SourceID: "7", ExpiresAt: time.Now().Add(cfg.Rotation.Validity).Format(time.RFC3339)}
+	vlt := NewMockTokenVault(vaultItemFromConfig(cfg))
+	a = &Application{tokenSource: src, tokenVault: vlt, statusStore: store, log: log}
+	outcome, err := a.Update(cfg)
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if outcome != OutcomeUnchanged {
+		t.Errorf("outcome = %v, want %v", outcome, OutcomeUnchanged)
+	}
+}
+
+func TestApplication_UpdateRequiresStatusForUntrackedExpiry(t *testing.T) {
+	// personal tokens of sources like gitea don't expire either
+	cfg := simpleConfigPersonal()
+	tok := validTokenFromConfig(cfg)
+	tok.Expiration = time.Time{}
+	src := &MockUntrackedTokenSource{MockTokenSource: NewMockTokenSource(tok)}
+
+	a := &Application{
+		tokenSource: src,
+		tokenVault:  NewMockTokenVault(vaultItemFromConfig(cfg)),
+		log:         logger.New(os.Stdout, logger.LogfmtFormat(), logger.Debug),
+	}
+	if _, err := a.Update(cfg); !errors.Is(err, ErrExpiryUntracked) {
+		t.Fatalf("Update() error = %v, want %v", err, ErrExpiryUntracked)
+	}
+	if src.token.Value != tok.Value {
+		t.Errorf("token value = %v, want the token not to be rotated", src.token.Value)
+	}
+
+	// sources reporting expiry need no status store
+	a.tokenSource = NewMockTokenSource(validTokenFromConfig(cfg))
+	if _, err := a.Update(cfg); err != nil {
+		t.Errorf("Update() error = %v", err)
+	}
+}
+
+func TestApplication_UpdateRecoversExpiredToken(t *testing.T) {
+	log := logger.New(os.Stdout, logger.LogfmtFormat(), logger.Debug)
+
+	tests := []struct {
+		name     string
+		recovery token.RecoveryPolicy
+		err      error
+		want     Outcome
+		wantErr  error
+	}{
+		{name: "expired", err: source.ErrTokenExpired, want: OutcomeRecovered},
+		{name: "revoked", recovery: token.RecoveryPolicyRecreate, err: source.ErrTokenRevoked, want: OutcomeRecovered},
+		{name: "recovery disabled", recovery: token.RecoveryPolicyFail, err: source.ErrTokenExpired, want: OutcomeFailed, wantErr: ErrRecoveryDisabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := simpleConfigPersonal()
+			cfg.Recovery = tt.recovery
+			vlt := NewMockTokenVault(vaultItemFromConfig(cfg))
+			a := &Application{
+				tokenSource: &MockInactiveTokenSource{MockTokenSource: NewMockTokenSource(nil), err: tt.err},
+				tokenVault:  vlt,
+				log:         log,
+			}
+
+			got, err := a.Update(cfg)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Update() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Update() outcome = %v, want %v", got, tt.want)
+			}
+			if tt.wantErr == nil && vlt.item.Value != "secret" {
+				t.Errorf("vault value = %v, want secret of the replacement", vlt.item.Value)
+			}
+		})
+	}
+}
+
+func TestApplication_UpdateSkipsInactiveToken(t *testing.T) {
+	cfg := simpleConfigPersonal()
+	cfg.Rotation.InactiveAfter = 90 * 24 * time.Hour
+	log := logger.New(os.Stdout, logger.LogfmtFormat(), logger.Debug)
+
+	// used recently, rotated as usual
+	used := expiredTokenFromConfig(cfg)
+	used.LastUsed = time.Now().Add(-time.Hour)
+	vlt := NewMockTokenVault(vaultItemFromConfig(cfg))
+	a := &Application{tokenSource: NewMockTokenSource(used), tokenVault: vlt, log: log}
+	outcome, err := a.Update(cfg)
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if outcome != OutcomeRotated {
+		t.Errorf("outcome = %v, want %v", outcome, OutcomeRotated)
+	}
+
+	// never used since its creation before the inactivity period
+	unused := expiredTokenFromConfig(cfg)
+	unused.Created = time.Now().Add(-100 * 24 * time.Hour)
+	vlt = NewMockTokenVault(vaultItemFromConfig(cfg))
+	a = &Application{tokenSource: NewMockTokenSource(unused), tokenVault: vlt, log: log}
+	outcome, err = a.Update(cfg)
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if outcome != OutcomeInactive {
+		t.Errorf("outcome = %v, want %v", outcome, OutcomeInactive)
+	}
+	if vlt.item.Value == "secret-rotated" {
+		t.Errorf("vault value = %v, want inactive token not to be rotated", vlt.item.Value)
+	}
+}
+
+func TestApplication_UpdateRevokesInactiveToken(t *testing.T) {
+	cfg := simpleConfigPersonal()
+	cfg.Rotation.InactiveAfter = 90 * 24 * time.Hour
+	cfg.Rotation.RevokeInactive = true
+	log := logger.New(os.Stdout, logger.LogfmtFormat(), logger.Debug)
+
+	tok := validTokenFromConfig(cfg)
+	tok.ID = "42"
+	tok.LastUsed = time.Now().Add(-100 * 24 * time.Hour)
+	src := NewMockTokenSource(tok)
+	vlt := NewMockTokenVault(vaultItemFromConfig(cfg))
+
+	a := &Application{tokenSource: src, tokenVault: vlt, log: log}
+	if _, err := a.Update(cfg); !errors.Is(err, ErrInactiveUntracked) {
+		t.Fatalf("Update() error = %v, want %v", err, ErrInactiveUntracked)
+	}
+
+	store := NewMockStatusStore()
+	a = &Application{tokenSource: src, tokenVault: vlt, statusStore: store, log: log}
+	outcome, err := a.Update(cfg)
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if outcome != OutcomeInactive {
+		t.Errorf("outcome = %v, want %v", outcome, OutcomeInactive)
+	}
+	if src.token != nil {
+		t.Errorf("token = %v, want inactive token revoked", src.token)
+	}
+	status := store.statuses[cfg.Name]
+	if status.RevokedInactiveAt == "" {
+		t.Error("status revoked inactive at is empty, want revocation recorded")
+	}
+	if status.LastUsedAt != tok.LastUsed.Format(time.RFC3339) {
+		t.Errorf("status last used at = %v, want %v", status.LastUsedAt, tok.LastUsed.Format(time.RFC3339))
+	}
+
+	// the revoked token is not recreated
+	outcome, err = a.Update(cfg)
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if outcome != OutcomeInactive {
+		t.Errorf("outcome = %v, want %v", outcome, OutcomeInactive)
+	}
+	if src.token != nil {
+		t.Errorf("token = %v, want revoked token not to be recreated", src.token)
+	}
+}
+
+func TestApplication_UpdateRevokedInactiveWithRecoveryFail(t *testing.T) {
+	cfg := simpleConfigPersonal()
+	cfg.Recovery = token.RecoveryPolicyFail
+	log := logger.New(os.Stdout, logger.LogfmtFormat(), logger.Debug)
+
+	store := NewMockStatusStore()
+	store.statuses[cfg.Name] = &token.Status{SourceID: "42", RevokedInactiveAt: time.Now().Format(time.RFC3339)}
+	src := &MockInactiveTokenSource{MockTokenSource: NewMockTokenSource(nil), err: source.ErrTokenRevoked}
+	a := &Application{tokenSource: src, tokenVault: NewMockTokenVault(vaultItemFromConfig(cfg)), statusStore: store, log: log}
+
+	// the token revoked as inactive is skipped before the recovery policy applies
+	outcome, err := a.Update(cfg)
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if outcome != OutcomeInactive {
+		t.Errorf("outcome = %v, want %v", outcome, OutcomeInactive)
+	}
+
+	// pinning another token ends the revocation as inactive
+	tok := validTokenFromConfig(cfg)
+	tok.ID = "43"
+	cfg.Source.ID = "43"
+	a.tokenSource = NewMockTokenSource(tok)
+	outcome, err = a.Update(cfg)
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if outcome != OutcomeUnchanged {
+		t.Errorf("outcome = %v, want %v", outcome, OutcomeUnchanged)
+	}
+	status := store.statuses[cfg.Name]
+	if status.SourceID != "43" {
+		t.Errorf("status source id = %v, want 43", status.SourceID)
+	}
+	if status.RevokedInactiveAt != "" {
+		t.Errorf("status revoked inactive at = %v, want cleared", status.RevokedInactiveAt)
+	}
+}
+
 func Test_maskToken(t *testing.T) {
 	type args struct {
 		token string
@@ -252,6 +674,30 @@ func (ts *MockTokenSource) DeleteToken(src *token.Source) error {
 	return nil
 }
 
+// MockRevokingTokenSource emulates rotation by creating a new token and revoking the previous one.
+type MockRevokingTokenSource struct {
+	*MockTokenSource
+	revoked []string
+}
+
+func (ts *MockRevokingTokenSource) RotateToken(cfg *token.Config) (*token.Token, error) {
+	previous := ts.token
+	ts.token = &token.Token{
+		ID:         "2",
+		Name:       cfg.Source.Name,
+		Value:      "secret-rotated",
+		Username:   "gitlab+deploy-token-2",
+		Expiration: time.Now().Add(cfg.Rotation.Validity),
+		Replaces:   previous,
+	}
+	return ts.token, nil
+}
+
+func (ts *MockRevokingTokenSource) RevokeToken(src *token.Source, tok *token.Token) error {
+	ts.revoked = append(ts.revoked, tok.ID)
+	return nil
+}
+
 type MockTokenVault struct {
 	item *vault.Item
 }
@@ -271,18 +717,20 @@ func (tv *MockTokenVault) GetItem(vlt *token.Vault) (*vault.Item, error) {
 	return tv.item, nil
 }
 
-func (tv *MockTokenVault) CreateItem(vlt *token.Vault, value string) (*vault.Item, error) {
+func (tv *MockTokenVault) CreateItem(vlt *token.Vault, tok *token.Token) (*vault.Item, error) {
 	tv.item = &vault.Item{
-		Name:  "mock",
-		Path:  vlt.Path,
-		Field: vlt.Field,
-		Value: value,
+		Name:     "mock",
+		Path:     vlt.Path,
+		Field:    vlt.Field,
+		Value:    tok.Value,
+		Username: tok.Username,
 	}
 	return tv.item, nil
 }
 
-func (tv *MockTokenVault) UpdateItem(vlt *token.Vault, value string) error {
-	tv.item.Value = value
+func (tv *MockTokenVault) UpdateItem(vlt *token.Vault, tok *token.Token) error {
+	tv.item.Value = tok.Value
+	tv.item.Username = tok.Username
 	return nil
 }
 
@@ -290,3 +738,83 @@ func (tv *MockTokenVault) DeleteItem(vlt *token.Vault) error {
 	tv.item = nil
 	return nil
 }
+
+// MockLastIDTokenSource records the pinned ID and the ID of the previous run passed to GetToken.
+type MockLastIDTokenSource struct {
+	*MockTokenSource
+	id     string
+	lastID string
+}
+
+func (ts *MockLastIDTokenSource) GetToken(src *token.Source) (*token.Token, error) {
+	ts.id = src.ID
+	ts.lastID = src.LastID
+	return ts.MockTokenSource.GetToken(src)
+}
+
+// MockUntrackedTokenSource has tokens that don't report their expiry.
+type MockUntrackedTokenSource struct {
+	*MockTokenSource
+}
+
+func (ts *MockUntrackedTokenSource) ReportsExpiry(*token.Source) bool {
+	return false
+}
+
+// MockInactiveTokenSource reports an expired or revoked token until a replacement is created.
+type MockInactiveTokenSource struct {
+	*MockTokenSource
+	err error
+}
+
+func (ts *MockInactiveTokenSource) GetToken(src *token.Source) (*token.Token, error) {
+	if ts.token == nil {
+		return nil, ts.err
+	}
+	return ts.token, nil
+}
+
+type MockStatusStore struct {
+	statuses map[string]*token.Status
+}
+
+func NewMockStatusStore() *MockStatusStore {
+	return &MockStatusStore{statuses: map[string]*token.Status{}}
+}
+
+func (s *MockStatusStore) GetStatus(name string) (*token.Status, error) {
+	status, ok := s.statuses[name]
+	if !ok {
+		return &token.Status{}, nil
+	}
+	cp := *status
+	cp.PendingRevocations = append([]token.Revocation(nil), status.PendingRevocations...)
+	return &cp, nil
+}
+
+func (s *MockStatusStore) SetStatus(name string, status *token.Status) error {
+	s.statuses[name] = status
+	return nil
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "success", err: nil, want: 0},
+		{name: "plain error", err: errors.New("boom"), want: ExitFailure},
+		{name: "retriable", err: &token.Error{Kind: token.ErrorKindRateLimited, Retriable: true, Err: source.ErrRateLimited}, want: ExitTempFailure},
+		{name: "forbidden", err: token.Annotate(source.ErrForbidden, source.BackendGitLab, "get", "test", token.ErrorKindForbidden), want: ExitNoPerm},
+		{name: "invalid", err: fmt.Errorf("reconcile error: %w", &token.Error{Kind: token.ErrorKindInvalid, Err: source.ErrAmbiguousToken}), want: ExitConfig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
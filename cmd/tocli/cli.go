@@ -4,16 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
-	"strings"
-	"time"
 
-	"github.com/go-playground/validator/v10"
-	"github.com/goccy/go-yaml"
 	"github.com/hamba/cmd/v3/observe"
+	"github.com/hamba/logger/v2"
 	lctx "github.com/hamba/logger/v2/ctx"
 	errors2 "github.com/hamba/pkg/v2/errors"
 	"github.com/urfave/cli/v3"
+	"gitlab.com/sickit/token-operator"
+	"gitlab.com/sickit/token-operator/pkg/source"
 	"gitlab.com/sickit/token-operator/pkg/token"
 	"gitlab.com/sickit/token-operator/pkg/toop"
 )
@@ -34,23 +32,35 @@ func runCli(ctx context.Context, cmd *cli.Command) error {
 	}
 	defer obsvr.Close()
 
-	confFile, err := os.ReadFile(cmd.String(flagConfig))
+	config, version, err := loadConfig(ctx, cmd, obsvr)
 	if err != nil {
-		return fmt.Errorf("failed to read config: %w", err)
+		return err
+	}
+	obsvr.Log.Debug("read config", lctx.Str("config", fmt.Sprintf("%+v", *config)))
+	if version != toop.APIVersionLatest {
+		obsvr.Log.Warn("config uses an older apiVersion, update it with 'tocli config migrate'",
+			lctx.Str("apiVersion", version),
+			lctx.Str("latest", toop.APIVersionLatest),
+		)
 	}
 
-	config := toop.Config{}
-	validate := validator.New()
-	dec := yaml.NewDecoder(
-		strings.NewReader(string(confFile)),
-		yaml.Validator(validate),
-	)
-	err = dec.Decode(&config)
+	// set unused flags from config, except credentials
+	if err = resolveOptions(cmd, config); err != nil {
+		return err
+	}
+
+	src, err := newSource(ctx, cmd, obsvr, config.Source)
 	if err != nil {
-		return fmt.Errorf("failed to parse config: %w", err)
+		return fmt.Errorf("failed to create source: %w", err)
 	}
-	obsvr.Log.Debug("read config", lctx.Str("config", fmt.Sprintf("%+v", config)))
 
+	// tokens of other GitLab instances are routed to the source of their connection
+	src = withConnections(ctx, cmd, obsvr, config, src)
+
+	// expand generators, resolving projects and groups through the source of their connection
+	if err = config.Generate(sourceResolvers(src)); err != nil {
+		return fmt.Errorf("failed to generate tokens: %w", err)
+	}
 	if err = config.Validate(); err != nil {
 		return fmt.Errorf("failed to validate config: %w", err)
 	}
@@ -62,56 +72,82 @@ func runCli(ctx context.Context, cmd *cli.Command) error {
 		}
 
 		for _, cfg := range config.Tokens {
-			if cfg.Source.Type != "personal" {
+			if !source.IsCommunityType(cfg.Source.Type) {
 				return fmt.Errorf("config requires enterprise license: %s", cfg.Source.Type)
 			}
 		}
 	}
 
-	// set unused flags from config, except credentials
-	switch {
-	case !cmd.IsSet(flagSourceURL) && config.Source.Url != "":
-		err = cmd.Set(flagSourceURL, config.Source.Url)
-	case !cmd.IsSet(flagVaultType) && config.Vault.Type != "":
-		err = cmd.Set(flagVaultType, config.Vault.Type)
-	case !cmd.IsSet(flagVaultURL) && config.Vault.Url != "":
-		err = cmd.Set(flagVaultURL, config.Vault.Url)
-	case !cmd.IsSet(flagDryRun) && config.DryRun:
-		err = cmd.Set(flagDryRun, "true")
-	case !cmd.IsSet(flagLicense) && config.License != "":
-		err = cmd.Set(flagLicense, config.License)
-	}
-	if err != nil {
-		return fmt.Errorf("failed to set flag from config: %w", err)
-	}
-
-	app, err := newApplication(ctx, cmd, obsvr)
+	app, err := newApplication(ctx, cmd, src, obsvr)
 	if err != nil {
 		return fmt.Errorf("failed to create application: %w", err)
 	}
 
-	for _, cfg := range config.Tokens {
-		if cfg.Rotation == nil {
-			cfg.Rotation = &token.Rotation{
-				RotateBefore: config.DefaultRotation.RotateBefore,
-				Validity:     config.DefaultRotation.Validity,
-			}
-			obsvr.Log.Debug("using default rotation for token", lctx.Str("name", cfg.Name), lctx.Duration("rotateBefore", cfg.Rotation.RotateBefore), lctx.Duration("validity", cfg.Rotation.Validity))
-		}
+	tokens := resolveTokens(cmd, config)
 
-		if cmd.Bool(flagForceRotate) {
-			// to force rotation, we set rotateBefore to over 1 year (the maximum validity for GitLab tokens).
-			cfg.Rotation.RotateBefore = 366 * 24 * time.Hour
-			obsvr.Log.Debug("forcing rotation", lctx.Str("name", cfg.Name), lctx.Duration("rotateBefore", cfg.Rotation.RotateBefore))
+	// check the source token before any token is changed
+	if preflighter, ok := src.(token_operator.Preflighter); ok {
+		if err = preflighter.Preflight(tokens); err != nil {
+			return fmt.Errorf("preflight failed: %w", err)
 		}
+	}
+
+	outcomes := map[token_operator.Outcome]int{}
+	for _, cfg := range tokens {
+		obsvr.Log.Debug("using rotation for token",
+			lctx.Str("name", cfg.Name),
+			lctx.Duration("rotateBefore", cfg.Rotation.RotateBefore),
+			lctx.Duration("validity", cfg.Rotation.Lifetime()),
+			lctx.Bool("forceRotate", cmd.Bool(flagForceRotate)),
+		)
 
 		obsvr.Log.Info("reconciling token", lctx.Str("name", cfg.Name), lctx.Str("type", cfg.Source.Type))
-		if err = app.Reconcile(cfg); err != nil {
+		outcome, err := app.Reconcile(cfg)
+		outcomes[outcome]++
+		if err != nil {
+			obsvr.Log.Error("failed to reconcile token", append(errorFields(err), lctx.Str("name", cfg.Name))...)
+			logSummary(obsvr, outcomes)
 			return fmt.Errorf("reconcile error: %w", err)
 		}
+
+		if outcome == token_operator.OutcomeRecovered {
+			obsvr.Log.Warn("recovered expired or revoked token, check its consumers", lctx.Str("name", cfg.Name))
+		}
 	}
 
+	logSummary(obsvr, outcomes)
+
 	obsvr.Log.Debug("token rotation complete, 🙏thank you for using token-operator!")
 
 	return nil
 }
+
+func logSummary(obsvr *observe.Observer, outcomes map[token_operator.Outcome]int) {
+	obsvr.Log.Info("reconciled tokens",
+		lctx.Int("unchanged", outcomes[token_operator.OutcomeUnchanged]),
+		lctx.Int("rotated", outcomes[token_operator.OutcomeRotated]),
+		lctx.Int("created", outcomes[token_operator.OutcomeCreated]),
+		lctx.Int("recovered", outcomes[token_operator.OutcomeRecovered]),
+		lctx.Int("deleted", outcomes[token_operator.OutcomeDeleted]),
+		lctx.Int("inactive", outcomes[token_operator.OutcomeInactive]),
+		lctx.Int("skipped", outcomes[token_operator.OutcomeSkipped]),
+		lctx.Int("failed", outcomes[token_operator.OutcomeFailed]),
+	)
+}
+
+// errorFields returns the log fields of the token.Error wrapped by err.
+func errorFields(err error) []logger.Field {
+	var operr *token.Error
+	if !errors.As(err, &operr) {
+		return []logger.Field{lctx.Err(err)}
+	}
+
+	return []logger.Field{
+		lctx.Str("backend", operr.Backend),
+		lctx.Str("op", operr.Op),
+		lctx.Str("kind", string(operr.Kind)),
+		lctx.Int("status", operr.Status),
+		lctx.Bool("retriable", operr.Retriable),
+		lctx.Err(err),
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/hamba/cmd/v3/observe"
+	lctx "github.com/hamba/logger/v2/ctx"
+	"github.com/urfave/cli/v3"
+	"gitlab.com/sickit/token-operator"
+	"gitlab.com/sickit/token-operator/pkg/source"
+	"gitlab.com/sickit/token-operator/pkg/token"
+	"gitlab.com/sickit/token-operator/pkg/toop"
+)
+
+const redacted = "<redacted>"
+
+// configOption maps a global flag to its value from the configuration file.
+type configOption struct {
+	flag  string
+	value string
+}
+
+// configOptions returns all global options that can be set in the configuration file.
+// Credentials are intentionally not part of the configuration file.
+func configOptions(config *toop.Config) []configOption {
+	opts := []configOption{
+		{flag: flagSourceURL, value: config.Source.Url},
+		{flag: flagVaultType, value: config.Vault.Type},
+		{flag: flagVaultURL, value: config.Vault.Url},
+		{flag: flagLicense, value: config.License},
+		{flag: flagStatusFile, value: config.StatusFile},
+	}
+
+	// a bool in the config can't be distinguished from an unset one, so only "true" overrides the default.
+	if config.DryRun {
+		opts = append(opts, configOption{flag: flagDryRun, value: strconv.FormatBool(config.DryRun)})
+	}
+	if config.ForceRotate {
+		opts = append(opts, configOption{flag: flagForceRotate, value: strconv.FormatBool(config.ForceRotate)})
+	}
+
+	return opts
+}
+
+// resolveOptions applies the configuration file to all global options, with the precedence
+// defaults < config file < environment < flags.
+func resolveOptions(cmd *cli.Command, config *toop.Config) error {
+	for _, opt := range configOptions(config) {
+		// IsSet is true if the flag was set on the command line or through its environment variable.
+		if cmd.IsSet(opt.flag) || opt.value == "" {
+			continue
+		}
+
+		if err := cmd.Set(opt.flag, opt.value); err != nil {
+			return fmt.Errorf("failed to set flag %s from config: %w", opt.flag, err)
+		}
+	}
+
+	return nil
+}
+
+// sourceResolvers resolves generator matrices through the source of their connection, see withConnections.
+// Generators with projects or groups require a source that can resolve them, e.g. a gitea source can't.
+func sourceResolvers(src token_operator.TokenSource) toop.Resolvers {
+	return func(connection string) (toop.Resolver, error) {
+		csrc := src
+		if conns, ok := src.(*connections); ok {
+			var err error
+			if csrc, err = conns.source(connection); err != nil {
+				return nil, err
+			}
+		} else if connection != "" {
+			return nil, fmt.Errorf("%w: %s", toop.ErrUnknownConnection, connection)
+		}
+
+		resolver, ok := csrc.(toop.Resolver)
+		if !ok {
+			return nil, toop.ErrMissingResolver
+		}
+		return resolver, nil
+	}
+}
+
+// resolveTokens returns the token configurations with default rotation and forced rotation applied.
+func resolveTokens(cmd *cli.Command, config *toop.Config) []token.Config {
+	tokens := make([]token.Config, 0, len(config.Tokens))
+	for _, cfg := range config.Tokens {
+		if cfg.Rotation == nil {
+			cfg.Rotation = &token.Rotation{
+				RotateBefore:   config.DefaultRotation.RotateBefore,
+				Validity:       config.DefaultRotation.Validity,
+				Strategy:       config.DefaultRotation.Strategy,
+				GracePeriod:    config.DefaultRotation.GracePeriod,
+				ValidityDays:   config.DefaultRotation.ValidityDays,
+				BusinessDays:   config.DefaultRotation.BusinessDays,
+				Holidays:       config.DefaultRotation.Holidays,
+				InactiveAfter:  config.DefaultRotation.InactiveAfter,
+				RevokeInactive: config.DefaultRotation.RevokeInactive,
+			}
+		} else {
+			rotation := *cfg.Rotation
+			cfg.Rotation = &rotation
+		}
+
+		if cmd.Bool(flagForceRotate) {
+			// to force rotation, we set rotateBefore to over 1 year (the maximum validity for GitLab tokens).
+			cfg.Rotation.RotateBefore = 366 * 24 * time.Hour
+		}
+
+		tokens = append(tokens, cfg)
+	}
+
+	return tokens
+}
+
+// loadConfig reads, parses and validates the configuration file.
+// It also returns the apiVersion of the file, as older versions are migrated while decoding.
+func loadConfig(ctx context.Context, cmd *cli.Command, obsvr *observe.Observer) (*toop.Config, string, error) {
+	confFile, err := readConfig(ctx, cmd, obsvr)
+	if err != nil {
+		return nil, "", err
+	}
+
+	version, err := toop.Version(confFile)
+	if err != nil {
+		return nil, "", err
+	}
+
+	config, err := toop.Decode(confFile)
+	if err != nil {
+		return nil, "", err
+	}
+	// the vault type decides the required vault attributes of tokens, the flag takes precedence over the config
+	if cmd.IsSet(flagVaultType) {
+		config.Vault.Type = cmd.String(flagVaultType)
+	}
+
+	if err = config.Validate(); err != nil {
+		return nil, "", fmt.Errorf("failed to validate config: %w", err)
+	}
+
+	return config, version, nil
+}
+
+// readConfig reads the configuration from a file or from a GitLab repository.
+// A configuration in a repository is read with the source token, the source URL can't be set in it.
+func readConfig(ctx context.Context, cmd *cli.Command, obsvr *observe.Observer) ([]byte, error) {
+	location := cmd.String(flagConfig)
+	if !toop.IsRepositoryRef(location) {
+		confFile, err := os.ReadFile(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		return confFile, nil
+	}
+
+	ref, err := toop.ParseRepositoryRef(location)
+	if err != nil {
+		return nil, err
+	}
+
+	// the configuration is not known yet, so the default rate limit and timezone apply
+	src, err := newSource(ctx, cmd, obsvr, toop.Source{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create source: %w", err)
+	}
+	repo, ok := src.(repositoryReader)
+	if !ok {
+		return nil, fmt.Errorf("source can't read config from repository: %s", ref)
+	}
+
+	file, err := repo.GetFile(ref.Project, ref.Path, ref.Revision())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err = ref.Verify(file.CommitID); err != nil {
+		return nil, err
+	}
+
+	obsvr.Log.Info("read config from repository",
+		lctx.Str("project", file.Project),
+		lctx.Str("path", file.Path),
+		lctx.Str("ref", file.Ref),
+		lctx.Str("commit", file.CommitID),
+		lctx.Str("lastCommit", file.LastCommitID),
+		lctx.Str("blob", file.BlobID),
+	)
+
+	return file.Content, nil
+}
+
+// repositoryReader reads files from a repository of the source.
+type repositoryReader interface {
+	GetFile(project, filePath, ref string) (*source.File, error)
+}
+
+// effectiveConfig is the configuration after all layers were applied, including redacted credentials.
+type effectiveConfig struct {
+	toop.Config `yaml:",inline"`
+
+	Credentials struct {
+		SourceToken string `yaml:"source_token"`
+		VaultToken  string `yaml:"vault_token"`
+	} `yaml:"credentials"`
+}
+
+func runConfigShow(ctx context.Context, cmd *cli.Command) error {
+	obsvr, err := observe.New(ctx, cmd, "tocli", &observe.Options{StatsRuntime: false})
+	if err != nil {
+		return fmt.Errorf("failed to create observer: %w", err)
+	}
+	defer obsvr.Close()
+
+	config, _, err := loadConfig(ctx, cmd, obsvr)
+	if err != nil {
+		return err
+	}
+
+	if err = resolveOptions(cmd, config); err != nil {
+		return err
+	}
+
+	// generators with projects or groups are only expanded if the source is available
+	var resolvers toop.Resolvers
+	if cmd.String(flagSourceToken) != "" {
+		src, err := newSource(ctx, cmd, obsvr, config.Source)
+		if err != nil {
+			return fmt.Errorf("failed to create source: %w", err)
+		}
+		resolvers = sourceResolvers(withConnections(ctx, cmd, obsvr, config, src))
+	}
+	if err = config.Generate(resolvers); err != nil {
+		return fmt.Errorf("failed to generate tokens: %w", err)
+	}
+
+	eff := effectiveConfig{Config: *config}
+	eff.DryRun = cmd.Bool(flagDryRun)
+	eff.ForceRotate = cmd.Bool(flagForceRotate)
+	eff.License = redact(cmd.String(flagLicense))
+	eff.Source.Url = cmd.String(flagSourceURL)
+	eff.Vault.Type = cmd.String(flagVaultType)
+	eff.Vault.Url = cmd.String(flagVaultURL)
+	eff.Tokens = resolveTokens(cmd, config)
+	eff.Credentials.SourceToken = redact(cmd.String(flagSourceToken))
+	eff.Credentials.VaultToken = redact(cmd.String(flagVaultToken))
+
+	out, err := yaml.Marshal(eff)
+	if err != nil {
+		return fmt.Errorf("failed to render config: %w", err)
+	}
+
+	_, err = cmd.Root().Writer.Write(out)
+	return err
+}
+
+func runConfigMigrate(_ context.Context, cmd *cli.Command) error {
+	path := cmd.String(flagConfig)
+	if toop.IsRepositoryRef(path) {
+		return fmt.Errorf("only local config files can be migrated: %s", path)
+	}
+
+	confFile, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	migrated, err := toop.Migrate(confFile)
+	if err != nil {
+		return err
+	}
+
+	// make sure the migrated config is still valid before writing it
+	if _, err = toop.Decode(migrated); err != nil {
+		return fmt.Errorf("failed to verify migrated config: %w", err)
+	}
+
+	if cmd.Bool(flagDryRun) {
+		_, err = cmd.Root().Writer.Write(migrated)
+		return err
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat config: %w", err)
+	}
+	if err = os.WriteFile(path, migrated, stat.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	return nil
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
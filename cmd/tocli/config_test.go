@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+	"gitlab.com/sickit/token-operator"
+	"gitlab.com/sickit/token-operator/pkg/token"
+	"gitlab.com/sickit/token-operator/pkg/toop"
+)
+
+func newTestCommand(action cli.ActionFunc) *cli.Command {
+	return &cli.Command{
+		Name: "test",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: flagSourceURL, Value: "https://gitlab.com/api/v4", Sources: cli.EnvVars("TEST_SOURCE_URL")},
+			&cli.StringFlag{Name: flagVaultType, Value: "1password", Sources: cli.EnvVars("TEST_VAULT_TYPE")},
+			&cli.StringFlag{Name: flagVaultURL},
+			&cli.StringFlag{Name: flagLicense},
+			&cli.BoolFlag{Name: flagDryRun},
+			&cli.BoolFlag{Name: flagForceRotate},
+		},
+		Action: action,
+	}
+}
+
+func TestResolveOptions(t *testing.T) {
+	config := &toop.Config{
+		DryRun:      true,
+		ForceRotate: true,
+		License:     "config-license",
+		Source:      toop.Source{Url: "https://config.example.com/api/v4"},
+		Vault:       toop.Vault{Url: "https://vault.example.com", Type: "config-vault"},
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want map[string]string
+	}{
+		{
+			name: "config overrides defaults for every option",
+			args: []string{"test"},
+			want: map[string]string{
+				flagSourceURL:   "https://config.example.com/api/v4",
+				flagVaultType:   "config-vault",
+				flagVaultURL:    "https://vault.example.com",
+				flagLicense:     "config-license",
+				flagDryRun:      "true",
+				flagForceRotate: "true",
+			},
+		},
+		{
+			name: "environment overrides config",
+			args: []string{"test"},
+			env:  map[string]string{"TEST_SOURCE_URL": "https://env.example.com/api/v4"},
+			want: map[string]string{
+				flagSourceURL: "https://env.example.com/api/v4",
+				flagVaultType: "config-vault",
+			},
+		},
+		{
+			name: "flags override environment and config",
+			args: []string{"test", "--" + flagVaultType, "flag-vault"},
+			env:  map[string]string{"TEST_VAULT_TYPE": "env-vault"},
+			want: map[string]string{
+				flagSourceURL: "https://config.example.com/api/v4",
+				flagVaultType: "flag-vault",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			got := map[string]string{}
+			cmd := newTestCommand(func(_ context.Context, cmd *cli.Command) error {
+				if err := resolveOptions(cmd, config); err != nil {
+					return err
+				}
+				for flag := range tt.want {
+					got[flag] = fmt.Sprint(cmd.Value(flag))
+				}
+				return nil
+			})
+
+			require.NoError(t, cmd.Run(context.Background(), tt.args))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestResolveTokens(t *testing.T) {
+	config := &toop.Config{
+		ForceRotate: true,
+		DefaultRotation: &token.Rotation{
+			RotateBefore: 24 * time.Hour,
+			Validity:     48 * time.Hour,
+		},
+		Tokens: []token.Config{
+			{Name: "default-rotation"},
+			{Name: "own-rotation", Rotation: &token.Rotation{RotateBefore: time.Hour, Validity: 2 * time.Hour}},
+		},
+	}
+
+	var tokens []token.Config
+	cmd := newTestCommand(func(_ context.Context, cmd *cli.Command) error {
+		if err := resolveOptions(cmd, config); err != nil {
+			return err
+		}
+		tokens = resolveTokens(cmd, config)
+		return nil
+	})
+
+	require.NoError(t, cmd.Run(context.Background(), []string{"test"}))
+	require.Len(t, tokens, 2)
+	assert.Equal(t, 366*24*time.Hour, tokens[0].Rotation.RotateBefore)
+	assert.Equal(t, 48*time.Hour, tokens[0].Rotation.Validity)
+	assert.Equal(t, 366*24*time.Hour, tokens[1].Rotation.RotateBefore)
+	assert.Equal(t, 2*time.Hour, tokens[1].Rotation.Validity)
+	// the configuration itself is left untouched
+	assert.Equal(t, time.Hour, config.Tokens[1].Rotation.RotateBefore)
+}
+
+// mockResolver resolves patterns to themselves, prefixed with the name of its source.
+type mockResolver struct {
+	mockSource
+}
+
+func (m *mockResolver) ResolveProjects(pattern string) ([]string, error) {
+	return []string{m.name + ":" + pattern}, nil
+}
+
+func (m *mockResolver) ResolveGroups(pattern string) ([]string, error) {
+	return []string{m.name + ":" + pattern}, nil
+}
+
+func TestSourceResolvers(t *testing.T) {
+	resolvers := sourceResolvers(&mockResolver{mockSource{name: "default"}})
+	resolver, err := resolvers("")
+	require.NoError(t, err)
+	got, _ := resolver.ResolveProjects("group/*")
+	assert.Equal(t, []string{"default:group/*"}, got)
+	_, err = resolvers("other")
+	assert.ErrorIs(t, err, toop.ErrUnknownConnection)
+
+	_, err = sourceResolvers(&mockSource{})("")
+	assert.ErrorIs(t, err, toop.ErrMissingResolver)
+
+	conns := newConnections(&mockSource{name: "default"}, map[string]toop.Source{"other": {}, "gitea": {}}, func(name string, _ toop.Source) (token_operator.TokenSource, error) {
+		if name == "gitea" {
+			return &mockSource{name: name}, nil
+		}
+		return &mockResolver{mockSource{name: name}}, nil
+	})
+	resolvers = sourceResolvers(conns)
+
+	resolver, err = resolvers("other")
+	require.NoError(t, err)
+	got, _ = resolver.ResolveGroups("group/*")
+	assert.Equal(t, []string{"other:group/*"}, got, "generators are resolved through the source of their connection")
+	_, err = resolvers("")
+	assert.ErrorIs(t, err, toop.ErrMissingResolver)
+	_, err = resolvers("gitea")
+	assert.ErrorIs(t, err, toop.ErrMissingResolver)
+	_, err = resolvers("unknown")
+	assert.ErrorIs(t, err, toop.ErrUnknownConnection)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/hamba/cmd/v3/observe"
+	"github.com/urfave/cli/v3"
+	"gitlab.com/sickit/token-operator"
+	"gitlab.com/sickit/token-operator/pkg/token"
+	"gitlab.com/sickit/token-operator/pkg/toop"
+)
+
+// connections routes tokens to the source of their connection, tokens without connection use the default source.
+// The sources of connections are created on first use.
+type connections struct {
+	def     token_operator.TokenSource
+	configs map[string]toop.Source
+	sources map[string]token_operator.TokenSource
+	create  func(name string, cfg toop.Source) (token_operator.TokenSource, error)
+}
+
+// withConnections returns the default source, wrapped to route tokens to their connection if any are configured.
+func withConnections(ctx context.Context, cmd *cli.Command, obsvr *observe.Observer, config *toop.Config, src token_operator.TokenSource) token_operator.TokenSource {
+	if len(config.Connections) == 0 {
+		return src
+	}
+
+	return newConnections(src, config.Connections, func(name string, cfg toop.Source) (token_operator.TokenSource, error) {
+		return newConnectionSource(ctx, cmd, obsvr, name, cfg)
+	})
+}
+
+func newConnections(def token_operator.TokenSource, configs map[string]toop.Source, create func(name string, cfg toop.Source) (token_operator.TokenSource, error)) *connections {
+	return &connections{
+		def:     def,
+		configs: configs,
+		sources: map[string]token_operator.TokenSource{},
+		create:  create,
+	}
+}
+
+// source returns the source of a connection, creating it on first use.
+func (c *connections) source(name string) (token_operator.TokenSource, error) {
+	if name == "" {
+		return c.def, nil
+	}
+	if src, ok := c.sources[name]; ok {
+		return src, nil
+	}
+
+	cfg, ok := c.configs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", toop.ErrUnknownConnection, name)
+	}
+	src, err := c.create(name, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create source of connection %s: %w", name, err)
+	}
+	c.sources[name] = src
+
+	return src, nil
+}
+
+func (c *connections) GetToken(source *token.Source) (*token.Token, error) {
+	src, err := c.source(source.Connection)
+	if err != nil {
+		return nil, err
+	}
+	return src.GetToken(source)
+}
+
+func (c *connections) CreateToken(config *token.Config) (*token.Token, error) {
+	src, err := c.source(config.Source.Connection)
+	if err != nil {
+		return nil, err
+	}
+	return src.CreateToken(config)
+}
+
+func (c *connections) RotateToken(config *token.Config) (*token.Token, error) {
+	src, err := c.source(config.Source.Connection)
+	if err != nil {
+		return nil, err
+	}
+	return src.RotateToken(config)
+}
+
+func (c *connections) DeleteToken(source *token.Source) error {
+	src, err := c.source(source.Connection)
+	if err != nil {
+		return err
+	}
+	return src.DeleteToken(source)
+}
+
+func (c *connections) RevokeToken(source *token.Source, tok *token.Token) error {
+	src, err := c.source(source.Connection)
+	if err != nil {
+		return err
+	}
+	revoker, ok := src.(token_operator.TokenRevoker)
+	if !ok {
+		return fmt.Errorf("source of connection %s can't revoke tokens", source.Connection)
+	}
+	return revoker.RevokeToken(source, tok)
+}
+
+// ReportsExpiry asks the source of the connection, sources without the capability report expiry.
+func (c *connections) ReportsExpiry(source *token.Source) bool {
+	src, err := c.source(source.Connection)
+	if err != nil {
+		// the error surfaces on the first request to the source
+		return true
+	}
+	reporter, ok := src.(token_operator.ExpiryReporter)
+	return !ok || reporter.ReportsExpiry(source)
+}
+
+// Preflight checks the source of every connection used by the configured tokens, the default source first.
+func (c *connections) Preflight(configs []token.Config) error {
+	byConnection := map[string][]token.Config{}
+	for _, cfg := range configs {
+		byConnection[cfg.Source.Connection] = append(byConnection[cfg.Source.Connection], cfg)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(byConnection)) {
+		src, err := c.source(name)
+		if err != nil {
+			return err
+		}
+		preflighter, ok := src.(token_operator.Preflighter)
+		if !ok {
+			continue
+		}
+		if err = preflighter.Preflight(byConnection[name]); err != nil {
+			if name == "" {
+				return err
+			}
+			return fmt.Errorf("connection %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// connectionTokens returns the tokens of a connection, the empty name is the default source.
+func connectionTokens(tokens []token.Config, name string) []token.Config {
+	res := []token.Config{}
+	for _, cfg := range tokens {
+		if cfg.Source.Connection == name {
+			res = append(res, cfg)
+		}
+	}
+	return res
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/sickit/token-operator"
+	"gitlab.com/sickit/token-operator/pkg/token"
+	"gitlab.com/sickit/token-operator/pkg/toop"
+)
+
+type mockSource struct {
+	name      string
+	preflight []token.Config
+}
+
+func (m *mockSource) GetToken(source *token.Source) (*token.Token, error) {
+	return &token.Token{Name: source.Name, Owner: m.name}, nil
+}
+
+func (m *mockSource) CreateToken(config *token.Config) (*token.Token, error) {
+	return m.GetToken(&config.Source)
+}
+
+func (m *mockSource) RotateToken(config *token.Config) (*token.Token, error) {
+	return m.GetToken(&config.Source)
+}
+
+func (m *mockSource) DeleteToken(_ *token.Source) error {
+	return nil
+}
+
+func (m *mockSource) Preflight(configs []token.Config) error {
+	m.preflight = configs
+	return nil
+}
+
+func TestConnections_RoutesTokens(t *testing.T) {
+	def := &mockSource{name: "default"}
+	created := map[string]*mockSource{}
+	conns := newConnections(def, map[string]toop.Source{
+		"a": {Url: "https://a.example.com/api/v4", TokenEnv: "A_TOKEN"},
+		"b": {Url: "https://b.example.com/api/v4", TokenEnv: "B_TOKEN"},
+	}, func(name string, _ toop.Source) (token_operator.TokenSource, error) {
+		created[name] = &mockSource{name: name}
+		return created[name], nil
+	})
+
+	tok, err := conns.GetToken(&token.Source{Name: "t1"})
+	require.NoError(t, err)
+	assert.Equal(t, "default", tok.Owner)
+
+	tok, err = conns.GetToken(&token.Source{Name: "t2", Connection: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, "a", tok.Owner)
+
+	_, err = conns.RotateToken(&token.Config{Source: token.Source{Name: "t3", Connection: "a"}})
+	require.NoError(t, err)
+
+	// sources are created once on first use
+	assert.Len(t, created, 1)
+	assert.Contains(t, created, "a")
+}
+
+func TestConnections_UnknownConnection(t *testing.T) {
+	conns := newConnections(&mockSource{}, map[string]toop.Source{}, func(string, toop.Source) (token_operator.TokenSource, error) {
+		return nil, errors.New("unexpected")
+	})
+
+	_, err := conns.GetToken(&token.Source{Name: "t1", Connection: "missing"})
+
+	assert.ErrorIs(t, err, toop.ErrUnknownConnection)
+}
+
+func TestConnections_PreflightPerConnection(t *testing.T) {
+	def := &mockSource{name: "default"}
+	a := &mockSource{name: "a"}
+	conns := newConnections(def, map[string]toop.Source{"a": {}, "b": {}}, func(string, toop.Source) (token_operator.TokenSource, error) {
+		return a, nil
+	})
+	tokens := []token.Config{
+		{Name: "t1"},
+		{Name: "t2", Source: token.Source{Connection: "a"}},
+		{Name: "t3"},
+	}
+
+	err := conns.Preflight(tokens)
+
+	require.NoError(t, err)
+	assert.Equal(t, []token.Config{tokens[0], tokens[2]}, def.preflight)
+	assert.Equal(t, []token.Config{tokens[1]}, a.preflight)
+}
+
+type untrackedSource struct {
+	mockSource
+}
+
+func (m *untrackedSource) ReportsExpiry(*token.Source) bool {
+	return false
+}
+
+func TestConnections_ReportsExpiry(t *testing.T) {
+	conns := newConnections(&mockSource{name: "default"}, map[string]toop.Source{"gitea": {}}, func(name string, _ toop.Source) (token_operator.TokenSource, error) {
+		return &untrackedSource{mockSource: mockSource{name: name}}, nil
+	})
+
+	assert.True(t, conns.ReportsExpiry(&token.Source{Name: "t1"}), "sources without the capability report expiry")
+	assert.False(t, conns.ReportsExpiry(&token.Source{Name: "t2", Connection: "gitea"}))
+}
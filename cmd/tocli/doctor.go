@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hamba/cmd/v3/observe"
+	errors2 "github.com/hamba/pkg/v2/errors"
+	"github.com/urfave/cli/v3"
+	"gitlab.com/sickit/token-operator"
+	"gitlab.com/sickit/token-operator/pkg/source"
+	"gitlab.com/sickit/token-operator/pkg/token"
+	"gitlab.com/sickit/token-operator/pkg/toop"
+	"gitlab.com/sickit/token-operator/pkg/vault"
+)
+
+const (
+	ErrChecksFailed = errors2.Error("doctor checks failed")
+)
+
+// connectivityTimeout limits the request to the source URL.
+const connectivityTimeout = 10 * time.Second
+
+// credentialer introspects the token of the source.
+type credentialer interface {
+	Credential() (*source.Credential, error)
+}
+
+// itemChecker checks a vault item resolves and is writable.
+type itemChecker interface {
+	CheckItem(vault *token.Vault) (*vault.ItemCheck, error)
+}
+
+// check is the result of a single doctor check.
+type check struct {
+	name   string
+	detail string
+	err    error
+}
+
+// report collects the results of the doctor checks.
+type report []check
+
+func (r *report) add(name, detail string, err error) {
+	*r = append(*r, check{name: name, detail: detail, err: err})
+}
+
+func (r report) failed() int {
+	n := 0
+	for _, c := range r {
+		if c.err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (r report) write(w io.Writer) error {
+	width := 0
+	for _, c := range r {
+		width = max(width, len(c.name))
+	}
+
+	for _, c := range r {
+		status, detail := "PASS", c.detail
+		if c.err != nil {
+			status, detail = "FAIL", c.err.Error()
+		}
+		if _, err := fmt.Fprintf(w, "%s  %-*s  %s\n", status, width, c.name, detail); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runDoctor(ctx context.Context, cmd *cli.Command) error {
+	obsvr, err := observe.New(ctx, cmd, "tocli", &observe.Options{StatsRuntime: false})
+	if err != nil {
+		return fmt.Errorf("failed to create observer: %w", err)
+	}
+	defer obsvr.Close()
+
+	r := report{}
+	defer func() { _ = r.write(cmd.Root().Writer) }()
+
+	config, _, err := loadConfig(ctx, cmd, obsvr)
+	if err != nil {
+		r.add("config", "", err)
+		return ErrChecksFailed
+	}
+	if err = resolveOptions(cmd, config); err != nil {
+		r.add("config", "", err)
+		return ErrChecksFailed
+	}
+
+	url := cmd.String(flagSourceURL)
+	detail, err := checkConnectivity(ctx, &http.Client{Timeout: connectivityTimeout}, url)
+	r.add("source connectivity", detail, err)
+
+	src, err := newSource(ctx, cmd, obsvr, config.Source)
+	if err != nil {
+		r.add("source token", "", err)
+	}
+
+	// generators are only expanded if the source is available, a source error is reported above
+	var resolvers toop.Resolvers
+	if src != nil {
+		resolvers = sourceResolvers(withConnections(ctx, cmd, obsvr, config, src))
+	}
+	err = config.Generate(resolvers)
+	if err == nil {
+		err = config.Validate()
+	}
+	r.add("config", fmt.Sprintf("%d tokens", len(config.Tokens)), err)
+	tokens := resolveTokens(cmd, config)
+
+	if src != nil {
+		detail, err = checkSourceToken(src, connectionTokens(tokens, ""))
+		r.add("source token", detail, err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(config.Connections)) {
+		conn := config.Connections[name]
+		detail, err = checkConnectivity(ctx, &http.Client{Timeout: connectivityTimeout}, conn.Url)
+		r.add("connection "+name, detail, err)
+
+		csrc, err := newConnectionSource(ctx, cmd, obsvr, name, conn)
+		if err == nil {
+			detail, err = checkSourceToken(csrc, connectionTokens(tokens, name))
+		}
+		r.add("connection "+name+" token", detail, err)
+	}
+
+	vlt, err := newVault(ctx, cmd, obsvr)
+	r.add("vault", cmd.String(flagVaultType), err)
+	if err != nil {
+		return ErrChecksFailed
+	}
+
+	checker, ok := vlt.(itemChecker)
+	if !ok {
+		r.add("vault items", "", fmt.Errorf("vault can't check items: %s", cmd.String(flagVaultType)))
+		return ErrChecksFailed
+	}
+	for _, cfg := range tokens {
+		detail, err = checkItem(checker, &cfg.Vault, cmd.Bool(flagDryRun))
+		r.add("token "+cfg.Name, detail, err)
+	}
+
+	if r.failed() > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrChecksFailed, r.failed(), len(r))
+	}
+	return nil
+}
+
+// checkConnectivity checks the source URL is reachable, any HTTP response passes.
+func checkConnectivity(ctx context.Context, client *http.Client, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid source url: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.TLS == nil {
+		return fmt.Sprintf("%s, no TLS", url), nil
+	}
+
+	detail := fmt.Sprintf("%s, %s", url, tls.VersionName(resp.TLS.Version))
+	if len(resp.TLS.PeerCertificates) > 0 {
+		detail += ", certificate expires " + resp.TLS.PeerCertificates[0].NotAfter.Format(time.DateOnly)
+	}
+	return detail, nil
+}
+
+// checkSourceToken introspects the source token and runs the preflight for the configured tokens.
+func checkSourceToken(src token_operator.TokenSource, tokens []token.Config) (string, error) {
+	detail := ""
+	if c, ok := src.(credentialer); ok {
+		cred, err := c.Credential()
+		switch {
+		case errors.Is(err, source.ErrNotFound):
+			detail = "can't be introspected"
+		case err != nil:
+			return "", err
+		default:
+			expires := "never expires"
+			if !cred.ExpiresAt.IsZero() {
+				expires = "expires " + cred.ExpiresAt.Format("2006-01-02 15:04 MST")
+			}
+			detail = fmt.Sprintf("%s, scopes %s, %s", cred.Name, strings.Join(cred.Scopes, ","), expires)
+		}
+	}
+
+	if p, ok := src.(token_operator.Preflighter); ok {
+		if err := p.Preflight(tokens); err != nil {
+			return "", err
+		}
+	}
+	return detail, nil
+}
+
+// checkItem checks the vault and item of a token resolve and the item is writable.
+func checkItem(checker itemChecker, vlt *token.Vault, dryRun bool) (string, error) {
+	res, err := checker.CheckItem(vlt)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case res.ItemID == "":
+		return fmt.Sprintf("vault %s, item missing, created on first run", res.VaultID), nil
+	case dryRun:
+		return fmt.Sprintf("vault %s, item %s, write not checked in dry-run", res.VaultID, res.ItemID), nil
+	case !res.Writable:
+		return fmt.Sprintf("vault %s, item %s, write not checked", res.VaultID, res.ItemID), nil
+	}
+	return fmt.Sprintf("vault %s, item %s, writable", res.VaultID, res.ItemID), nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/sickit/token-operator/pkg/token"
+	"gitlab.com/sickit/token-operator/pkg/vault"
+)
+
+func TestCheckConnectivity(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	detail, err := checkConnectivity(context.Background(), srv.Client(), srv.URL)
+
+	require.NoError(t, err)
+	assert.Contains(t, detail, "TLS 1.3")
+	assert.Contains(t, detail, "certificate expires")
+}
+
+func TestCheckConnectivity_UntrustedCertificate(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	_, err := checkConnectivity(context.Background(), &http.Client{}, srv.URL)
+
+	assert.Error(t, err)
+}
+
+type mockItemChecker struct {
+	check *vault.ItemCheck
+	err   error
+}
+
+func (m mockItemChecker) CheckItem(_ *token.Vault) (*vault.ItemCheck, error) {
+	return m.check, m.err
+}
+
+func TestCheckItem(t *testing.T) {
+	tests := []struct {
+		name    string
+		checker mockItemChecker
+		dryRun  bool
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "writable",
+			checker: mockItemChecker{check: &vault.ItemCheck{VaultID: "v1", ItemID: "i1", Writable: true}},
+			want:    "vault v1, item i1, writable",
+		},
+		{
+			name:    "dry-run",
+			checker: mockItemChecker{check: &vault.ItemCheck{VaultID: "v1", ItemID: "i1"}},
+			dryRun:  true,
+			want:    "vault v1, item i1, write not checked in dry-run",
+		},
+		{
+			name:    "missing item",
+			checker: mockItemChecker{check: &vault.ItemCheck{VaultID: "v1"}},
+			want:    "vault v1, item missing, created on first run",
+		},
+		{
+			name:    "missing vault",
+			checker: mockItemChecker{err: vault.ErrVaultNotFound},
+			wantErr: vault.ErrVaultNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkItem(tt.checker, &token.Vault{}, tt.dryRun)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReport_Write(t *testing.T) {
+	r := report{}
+	r.add("config", "2 tokens", nil)
+	r.add("source token", "", errors.New("unauthorized"))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, r.write(buf))
+
+	assert.Equal(t, 1, r.failed())
+	assert.Equal(t, "PASS  config        2 tokens\nFAIL  source token  unauthorized\n", buf.String())
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/urfave/cli/v3"
 	"gitlab.com/sickit/token-operator"
 	"gitlab.com/sickit/token-operator/pkg/source"
+	"gitlab.com/sickit/token-operator/pkg/status"
+	"gitlab.com/sickit/token-operator/pkg/toop"
 	"gitlab.com/sickit/token-operator/pkg/vault"
 )
 
@@ -27,25 +29,61 @@ func newTerm() term.Term {
 	}
 }
 
-func newApplication(ctx context.Context, cmd *cli.Command, obsvr *observe.Observer) (*token_operator.Application, error) {
-	src, err := newSource(ctx, cmd, obsvr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create source: %w", err)
-	}
-
+func newApplication(ctx context.Context, cmd *cli.Command, src token_operator.TokenSource, obsvr *observe.Observer) (*token_operator.Application, error) {
 	vlt, err := newVault(ctx, cmd, obsvr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create vault: %w", err)
 	}
-	return token_operator.NewApplication(src, vlt, obsvr), nil
+
+	opts := []token_operator.ApplicationOption{}
+	if path := cmd.String(flagStatusFile); path != "" {
+		store, err := status.NewFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create status store: %w", err)
+		}
+		store.WithDryRun(cmd.Bool(flagDryRun))
+		opts = append(opts, token_operator.WithStatusStore(store))
+	}
+
+	return token_operator.NewApplication(src, vlt, obsvr, opts...), nil
 }
 
-func newSource(ctx context.Context, cmd *cli.Command, obsvr *observe.Observer) (token_operator.TokenSource, error) {
+func newSource(ctx context.Context, cmd *cli.Command, obsvr *observe.Observer, cfg toop.Source) (token_operator.TokenSource, error) {
 	if cmd.String(flagSourceToken) == "" {
 		return nil, fmt.Errorf("no token for source specified")
 	}
 
-	glsrc, err := source.NewGitLabSource(ctx, cmd.String(flagSourceURL), cmd.String(flagSourceToken), obsvr, source.WithDryRun(cmd.Bool(flagDryRun)))
+	if cfg.IsGitea() {
+		return newGiteaSource(ctx, cmd, obsvr, cmd.String(flagSourceURL), cmd.String(flagSourceToken), cfg)
+	}
+	return newGitLabSource(ctx, cmd, obsvr, cmd.String(flagSourceURL), cmd.String(flagSourceToken), cfg)
+}
+
+// newConnectionSource creates the source of a named connection, its token is read from the environment.
+func newConnectionSource(ctx context.Context, cmd *cli.Command, obsvr *observe.Observer, name string, cfg toop.Source) (token_operator.TokenSource, error) {
+	tok := os.Getenv(cfg.TokenEnv)
+	if tok == "" {
+		return nil, fmt.Errorf("no token for connection %s specified in %s", name, cfg.TokenEnv)
+	}
+
+	if cfg.IsGitea() {
+		return newGiteaSource(ctx, cmd, obsvr, cfg.Url, tok, cfg)
+	}
+	return newGitLabSource(ctx, cmd, obsvr, cfg.Url, tok, cfg)
+}
+
+func newGitLabSource(ctx context.Context, cmd *cli.Command, obsvr *observe.Observer, url, tok string, cfg toop.Source) (token_operator.TokenSource, error) {
+	loc, err := cfg.Location()
+	if err != nil {
+		return nil, err
+	}
+
+	glsrc, err := source.NewGitLabSource(ctx, url, tok, obsvr,
+		source.WithDryRun(cmd.Bool(flagDryRun)),
+		source.WithRateLimit(cfg.RateLimit),
+		source.WithLocation(loc),
+		source.WithExpiryWarning(cfg.ExpiryWarning),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create source: %w", err)
 	}
@@ -53,6 +91,19 @@ func newSource(ctx context.Context, cmd *cli.Command, obsvr *observe.Observer) (
 	return glsrc, nil
 }
 
+// newGiteaSource creates a source for Gitea or Forgejo, the token is the password of the configured username.
+func newGiteaSource(ctx context.Context, cmd *cli.Command, obsvr *observe.Observer, url, password string, cfg toop.Source) (token_operator.TokenSource, error) {
+	gtsrc, err := source.NewGiteaSource(ctx, url, cfg.Username, password, obsvr,
+		source.WithGiteaDryRun(cmd.Bool(flagDryRun)),
+		source.WithGiteaRateLimit(cfg.RateLimit),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create source: %w", err)
+	}
+
+	return gtsrc, nil
+}
+
 func newVault(ctx context.Context, cmd *cli.Command, obsvr *observe.Observer) (token_operator.TokenVault, error) {
 	if cmd.String(flagVaultToken) == "" {
 		return nil, fmt.Errorf("no token for vault specified")
@@ -66,6 +117,16 @@ func newVault(ctx context.Context, cmd *cli.Command, obsvr *observe.Observer) (t
 		if err != nil {
 			return nil, fmt.Errorf("failed to create vault: %w", err)
 		}
+	case vault.TypeGitLab:
+		// CI/CD variables are usually stored on the source instance
+		url := cmd.String(flagVaultURL)
+		if url == "" {
+			url = cmd.String(flagSourceURL)
+		}
+		opvlt, err = vault.NewGitLabVault(ctx, url, cmd.String(flagVaultToken), obsvr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create vault: %w", err)
+		}
 	default:
 		return nil, fmt.Errorf("unknown vault type: %s", cmd.String(flagVaultType))
 	}
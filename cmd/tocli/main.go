@@ -12,6 +12,7 @@ import (
 	"github.com/hamba/cmd/v3"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/urfave/cli/v3"
+	"gitlab.com/sickit/token-operator"
 )
 
 const (
@@ -22,6 +23,7 @@ const (
 	flagLicence     = "licence"
 	flagSourceToken = "source.token"
 	flagSourceURL   = "source.url"
+	flagStatusFile  = "status-file"
 	flagVaultToken  = "vault.token"
 	flagVaultType   = "vault.type"
 	flagVaultURL    = "vault.url"
@@ -33,7 +35,7 @@ var flags = cmd.Flags{
 	&cli.StringFlag{
 		Name:    flagConfig,
 		Value:   "./tocli.yaml",
-		Usage:   "The path to the configuration file",
+		Usage:   "The path to the configuration file, or a GitLab repository file as gitlab://<project>/-/<path>[?ref=<ref>][&sha=<commit>]",
 		Sources: cli.EnvVars(strcase.ToSNAKE(flagConfig)),
 	},
 	&cli.StringFlag{
@@ -43,30 +45,28 @@ var flags = cmd.Flags{
 		Sources: cli.EnvVars(strcase.ToSNAKE(flagSourceURL)),
 	},
 	&cli.StringFlag{
-		Name:     flagSourceToken,
-		Value:    "",
-		Required: true,
-		Usage:    "The Source token to use",
-		Sources:  cli.EnvVars(strcase.ToSNAKE(flagSourceToken)),
+		Name:    flagSourceToken,
+		Value:   "",
+		Usage:   "The Source token to use, required to reconcile tokens",
+		Sources: cli.EnvVars(strcase.ToSNAKE(flagSourceToken)),
 	},
 	&cli.StringFlag{
 		Name:    flagVaultType,
 		Value:   "1password",
-		Usage:   "Which Vault backend to use",
+		Usage:   "Which Vault backend to use, one of 1password or gitlab",
 		Sources: cli.EnvVars(strcase.ToSNAKE(flagVaultType)),
 	},
 	&cli.StringFlag{
 		Name:    flagVaultURL,
 		Value:   "",
-		Usage:   "The Vault API URL to use, required for HashiCorp Vault",
+		Usage:   "The Vault API URL to use, required for HashiCorp Vault, defaults to the Source API URL for GitLab",
 		Sources: cli.EnvVars(strcase.ToSNAKE(flagVaultURL)),
 	},
 	&cli.StringFlag{
-		Name:     flagVaultToken,
-		Value:    "",
-		Required: true,
-		Usage:    "The Vault token to use",
-		Sources:  cli.EnvVars(strcase.ToSNAKE(flagVaultToken)),
+		Name:    flagVaultToken,
+		Value:   "",
+		Usage:   "The Vault token to use, required to reconcile tokens",
+		Sources: cli.EnvVars(strcase.ToSNAKE(flagVaultToken)),
 	},
 	&cli.StringFlag{
 		Name:    flagLicense,
@@ -75,6 +75,12 @@ var flags = cmd.Flags{
 		Usage:   "The enterprise license to use",
 		Sources: cli.EnvVars(strcase.ToSNAKE(flagLicense), strcase.ToSNAKE(flagLicence)),
 	},
+	&cli.StringFlag{
+		Name:    flagStatusFile,
+		Value:   "",
+		Usage:   "The path to the status file, required to track pending revocations of the overlap rotation strategy",
+		Sources: cli.EnvVars(strcase.ToSNAKE(flagStatusFile)),
+	},
 	&cli.BoolFlag{
 		Name:    flagDryRun,
 		Value:   false,
@@ -107,6 +113,19 @@ EXAMPLES:
 		--vault.type hashicorp --vault.url https://vault.example.com --vault.token ... \
 		--config gitlab-example-tokens.yaml --dry-run
 
+	# Read the configuration from a GitLab repository, pinned to a commit
+	tocli --source.token glpat-.... --vault.token ops-ey... \
+		--config "gitlab://group/tokens/-/tocli.yaml?ref=main&sha=0123abcd"
+
+	# Check connectivity, credentials and vault items before the first run
+	tocli --config personal-tokens.yaml doctor
+
+	# Show the effective configuration after applying config file, environment and flags
+	tocli --config personal-tokens.yaml --dry-run config show
+
+	# Upgrade a configuration file to the latest apiVersion, keeping its comments
+	tocli --config personal-tokens.yaml config migrate
+
 	# Example configuration
 	https://gitlab.com/sickit/token-operator/-/blob/main/pkg/toop/full-config.yaml
 
@@ -129,6 +148,29 @@ SUPPORT: mailto:toop@sickit.eu
 		Action:  runCli,
 		Flags:   flags,
 		Suggest: true,
+		Commands: []*cli.Command{
+			{
+				Name:   "doctor",
+				Usage:  "Check connectivity, credentials and the vault items of all tokens, use --dry-run to skip write checks",
+				Action: runDoctor,
+			},
+			{
+				Name:  "config",
+				Usage: "Inspect the configuration",
+				Commands: []*cli.Command{
+					{
+						Name:   "show",
+						Usage:  "Print the effective configuration with secrets redacted",
+						Action: runConfigShow,
+					},
+					{
+						Name:   "migrate",
+						Usage:  "Rewrite the configuration file to the latest apiVersion, use --dry-run to print it instead",
+						Action: runConfigMigrate,
+					},
+				},
+			},
+		},
 	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
@@ -136,7 +178,7 @@ SUPPORT: mailto:toop@sickit.eu
 
 	if err := app.Run(ctx, os.Args); err != nil {
 		ui.Error(err.Error())
-		return 1
+		return token_operator.ExitCode(err)
 	}
 	return 0
 }
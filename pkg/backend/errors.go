@@ -0,0 +1,65 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	errors2 "github.com/hamba/pkg/v2/errors"
+	"github.com/sethvargo/go-retry"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+const (
+	ErrUnauthorized = errors2.Error("unauthorized")
+	ErrForbidden    = errors2.Error("forbidden")
+	ErrNotFound     = errors2.Error("not found")
+	ErrRateLimited  = errors2.Error("rate limited")
+	ErrServerError  = errors2.Error("server error")
+)
+
+// Classify classifies the result of a request to a backend: transport errors, rate limited (429) and server errors (5xx)
+// are retriable, other client errors (4xx) and a cancelled context are permanent. It returns nil for a successful request.
+func Classify(backend string, resp *http.Response, err error) error {
+	if resp == nil {
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+			return err
+		}
+		return retry.RetryableError(&token.Error{Backend: backend, Kind: token.ErrorKindUnavailable, Retriable: true, Err: err})
+	}
+
+	code := resp.StatusCode
+	kind := token.ErrorKindUnknown
+	switch {
+	case code == http.StatusTooManyRequests:
+		err, kind = fmt.Errorf("%w: %s", ErrRateLimited, resp.Status), token.ErrorKindRateLimited
+	case code >= http.StatusInternalServerError:
+		err, kind = fmt.Errorf("%w: %s", ErrServerError, resp.Status), token.ErrorKindUnavailable
+	case code == http.StatusUnauthorized:
+		err, kind = ErrUnauthorized, token.ErrorKindUnauthorized
+	case code == http.StatusForbidden:
+		err, kind = ErrForbidden, token.ErrorKindForbidden
+	case code == http.StatusNotFound:
+		err, kind = ErrNotFound, token.ErrorKindNotFound
+	case err == nil:
+		return nil
+	case code == http.StatusBadRequest, code == http.StatusUnprocessableEntity:
+		kind = token.ErrorKindInvalid
+	}
+
+	operr := &token.Error{
+		Backend:   backend,
+		Status:    code,
+		Kind:      kind,
+		Retriable: code == http.StatusTooManyRequests || code >= http.StatusInternalServerError,
+		Err:       err,
+	}
+	if operr.Retriable {
+		return retry.RetryableError(operr)
+	}
+	return operr
+}
@@ -0,0 +1,155 @@
+// Package backend holds the HTTP plumbing shared by the sources and vaults of GitLab and Gitea instances:
+// rate limiting and the classification of responses into typed errors.
+package backend
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	DefaultRequestsPerSecond = 10
+	DefaultBurst             = 20
+	DefaultMaxWait           = 5 * time.Minute
+)
+
+// RateLimit configures the client-side rate limit of an instance.
+type RateLimit struct {
+	// RequestsPerSecond is the sustained request rate.
+	RequestsPerSecond float64 `yaml:"requests_per_second,omitempty"`
+	// Burst is the number of requests allowed at once.
+	Burst int `yaml:"burst,omitempty"`
+	// MaxWait caps a single wait requested by the Retry-After or RateLimit-Reset headers.
+	MaxWait time.Duration `yaml:"max_wait,omitempty"`
+}
+
+func (r RateLimit) withDefaults() RateLimit {
+	if r.RequestsPerSecond <= 0 {
+		r.RequestsPerSecond = DefaultRequestsPerSecond
+	}
+	if r.Burst <= 0 {
+		r.Burst = DefaultBurst
+	}
+	if r.MaxWait <= 0 {
+		r.MaxWait = DefaultMaxWait
+	}
+	return r
+}
+
+// Limiter throttles all requests to one instance. Besides the client-side rate,
+// it pauses all requests once the instance reports an exhausted rate limit.
+type Limiter struct {
+	rate    *rate.Limiter
+	maxWait time.Duration
+
+	mu    sync.Mutex
+	until time.Time
+}
+
+var limiters = struct {
+	sync.Mutex
+	byURL map[string]*Limiter
+}{byURL: map[string]*Limiter{}}
+
+// SharedLimiter returns the limiter of a URL, shared by all sources and vaults using the same URL.
+// A configured limit replaces the limit of an existing limiter, an empty limit keeps it.
+func SharedLimiter(url string, limit RateLimit) *Limiter {
+	limiters.Lock()
+	defer limiters.Unlock()
+
+	l, ok := limiters.byURL[url]
+	if ok && limit == (RateLimit{}) {
+		return l
+	}
+
+	limit = limit.withDefaults()
+	if !ok {
+		l = &Limiter{rate: rate.NewLimiter(rate.Limit(limit.RequestsPerSecond), limit.Burst)}
+		limiters.byURL[url] = l
+	}
+	l.rate.SetLimit(rate.Limit(limit.RequestsPerSecond))
+	l.rate.SetBurst(limit.Burst)
+	l.mu.Lock()
+	l.maxWait = limit.MaxWait
+	l.mu.Unlock()
+
+	return l
+}
+
+// Wait blocks until a request is allowed, it implements the gitlab.RateLimiter interface.
+func (l *Limiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	wait := time.Until(l.until)
+	l.mu.Unlock()
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return l.rate.Wait(ctx)
+}
+
+// Observe pauses requests according to the rate limit headers of a response.
+// It returns the pause, zero if the rate limit is not exhausted.
+func (l *Limiter) Observe(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+
+	wait := time.Duration(0)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		wait = retryAfter(resp.Header, time.Now())
+	case resp.Header.Get("RateLimit-Remaining") == "0":
+		wait = rateLimitReset(resp.Header, time.Now())
+	}
+	if wait <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	wait = min(wait, l.maxWait)
+	if until := time.Now().Add(wait); until.After(l.until) {
+		l.until = until
+	}
+
+	return wait
+}
+
+// retryAfter returns the wait of a rate limited response, from Retry-After in seconds or as HTTP date,
+// otherwise from RateLimit-Reset.
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	if v := header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return time.Duration(secs) * time.Second
+		}
+		if date, err := http.ParseTime(v); err == nil {
+			return date.Sub(now)
+		}
+	}
+
+	return rateLimitReset(header, now)
+}
+
+// rateLimitReset returns the time until the rate limit resets, RateLimit-Reset is a Unix timestamp.
+func rateLimitReset(header http.Header, now time.Time) time.Duration {
+	reset, err := strconv.ParseInt(header.Get("RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return time.Unix(reset, 0).Sub(now)
+}
@@ -0,0 +1,59 @@
+package backend
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{
+			name:   "seconds",
+			header: http.Header{"Retry-After": {"30"}},
+			want:   30 * time.Second,
+		},
+		{
+			name:   "http date",
+			header: http.Header{"Retry-After": {now.Add(time.Minute).Format(http.TimeFormat)}},
+			want:   time.Minute,
+		},
+		{
+			name:   "rate limit reset",
+			header: http.Header{"Ratelimit-Reset": {strconv.FormatInt(now.Add(10*time.Second).Unix(), 10)}},
+			want:   10 * time.Second,
+		},
+		{
+			name:   "no headers",
+			header: http.Header{},
+			want:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, retryAfter(tt.header, now))
+		})
+	}
+}
+
+func TestLimiter_Observe(t *testing.T) {
+	l := SharedLimiter("https://observe.example.com/api/v4", RateLimit{MaxWait: time.Minute})
+
+	ok := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Ratelimit-Remaining": {"10"}}}
+	assert.Zero(t, l.Observe(ok))
+
+	limited := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"3600"}}}
+	assert.Equal(t, time.Minute, l.Observe(limited), "wait is capped by max wait")
+
+	assert.Same(t, l, SharedLimiter("https://observe.example.com/api/v4", RateLimit{}))
+}
@@ -0,0 +1,72 @@
+package source
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	listPersonal      = "personal"
+	listImpersonation = "impersonation"
+	listDeploy        = "deploy"
+	listDeployKey     = "deploy_key"
+	listTrigger       = "trigger"
+	listRunner        = "runner"
+	listApplication   = "application"
+
+	listServiceAccount      = "service_account"
+	listServiceAccountToken = "service_account_token"
+)
+
+// listCache caches listings for the lifetime of the source, which is a single run.
+// Listings are keyed by kind and owner, a mutation invalidates the listings of its owner.
+// Like the user IDs, it is not safe for concurrent use.
+type listCache struct {
+	listings map[string]any
+}
+
+func newListCache() *listCache {
+	return &listCache{listings: map[string]any{}}
+}
+
+// listKey returns the key of a listing, variant tells listings of the same owner apart, e.g. by state.
+func listKey(kind string, owner any, variant string) string {
+	return fmt.Sprintf("%s/%v/%s", kind, owner, variant)
+}
+
+// cachedList returns the cached listing of key, the listing is only cached if it succeeds.
+// Without cache, every call lists.
+func cachedList[T any](c *listCache, key string, list func() ([]T, error)) ([]T, error) {
+	if c == nil {
+		return list()
+	}
+	if listing, ok := c.listings[key]; ok {
+		return listing.([]T), nil
+	}
+
+	listing, err := list()
+	if err != nil {
+		return nil, err
+	}
+	c.listings[key] = listing
+
+	return listing, nil
+}
+
+// invalidate drops all listings of an owner, or of all owners of the kind if owner is nil.
+func (c *listCache) invalidate(kind string, owner any) {
+	if c == nil {
+		return
+	}
+
+	prefix := kind + "/"
+	if owner != nil {
+		prefix = fmt.Sprintf("%s/%v/", kind, owner)
+	}
+
+	for key := range c.listings {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.listings, key)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package source
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+func TestCachedList(t *testing.T) {
+	c := newListCache()
+	calls := 0
+	list := func() ([]string, error) {
+		calls++
+		return []string{"a", "b"}, nil
+	}
+
+	got, err := cachedList(c, listKey(listPersonal, 1, "active"), list)
+	require.NoError(t, err)
+	got, err = cachedList(c, listKey(listPersonal, 1, "active"), list)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a", "b"}, got)
+	assert.Equal(t, 1, calls)
+}
+
+func TestCachedList_ErrorNotCached(t *testing.T) {
+	c := newListCache()
+	calls := 0
+	list := func() ([]string, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("test")
+		}
+		return []string{"a"}, nil
+	}
+
+	_, err := cachedList(c, listKey(listTrigger, "group/project", ""), list)
+	require.Error(t, err)
+	got, err := cachedList(c, listKey(listTrigger, "group/project", ""), list)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a"}, got)
+	assert.Equal(t, 2, calls)
+}
+
+func TestListCache_Invalidate(t *testing.T) {
+	c := newListCache()
+	list := func() ([]string, error) { return []string{"a"}, nil }
+	for _, key := range []string{
+		listKey(listPersonal, 1, "active"),
+		listKey(listPersonal, 1, "inactive"),
+		listKey(listPersonal, 10, "active"),
+		listKey(listDeploy, 1, "false"),
+	} {
+		_, err := cachedList(c, key, list)
+		require.NoError(t, err)
+	}
+
+	c.invalidate(listPersonal, 1)
+
+	assert.NotContains(t, c.listings, listKey(listPersonal, 1, "active"))
+	assert.NotContains(t, c.listings, listKey(listPersonal, 1, "inactive"))
+	assert.Contains(t, c.listings, listKey(listPersonal, 10, "active"))
+	assert.Contains(t, c.listings, listKey(listDeploy, 1, "false"))
+
+	c.invalidate(listPersonal, nil)
+
+	assert.Len(t, c.listings, 1)
+}
+
+func TestListCache_Nil(t *testing.T) {
+	var c *listCache
+	calls := 0
+	list := func() ([]string, error) {
+		calls++
+		return nil, nil
+	}
+
+	_, _ = cachedList(c, "key", list)
+	_, _ = cachedList(c, "key", list)
+	c.invalidate(listPersonal, nil)
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestGitLab_ListsOncePerOwner(t *testing.T) {
+	toks := map[string][]map[string]any{
+		"group/a": {{"id": 5, "name": "registry"}, {"id": 6, "name": "ci"}},
+		"group/b": {{"id": 7, "name": "registry"}},
+	}
+	lists := map[string]int{}
+	mux := http.NewServeMux()
+	serveUser(mux, false)
+	mux.HandleFunc("GET /projects/{id}/deploy_tokens", func(w http.ResponseWriter, r *http.Request) {
+		owner := r.PathValue("id")
+		lists[owner]++
+		writeJSON(w, http.StatusOK, toks[owner])
+	})
+	mux.HandleFunc("POST /projects/{id}/deploy_tokens", func(w http.ResponseWriter, r *http.Request) {
+		owner := r.PathValue("id")
+		body := map[string]any{}
+		decodeJSON(t, r, &body)
+		tok := map[string]any{"id": 10, "name": body["name"], "token": "gldt-secret", "expires_at": body["expires_at"]}
+		toks[owner] = append(toks[owner], tok)
+		writeJSON(w, http.StatusCreated, tok)
+	})
+	mux.HandleFunc("DELETE /projects/{id}/deploy_tokens/{token}", func(w http.ResponseWriter, r *http.Request) {
+		owner := r.PathValue("id")
+		for _, tok := range toks[owner] {
+			if strconv.Itoa(tok["id"].(int)) == r.PathValue("token") {
+				tok["revoked"] = true
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	g, err := newTestGitLab(t, mux)
+	require.NoError(t, err)
+	registryA := deployConfig("", "group/a")
+	ciA := deployConfig("", "group/a")
+	ciA.Source.Name = "ci"
+	registryB := deployConfig("", "group/b")
+
+	// the reconcile of a run looks up every token
+	for _, source := range []*token.Source{&registryA.Source, &ciA.Source, &registryB.Source} {
+		_, err = g.GetToken(source)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, map[string]int{"group/a": 1, "group/b": 1}, lists, "the tokens of an owner are listed once")
+
+	tok, err := g.RotateToken(registryA)
+	require.NoError(t, err)
+	require.NotNil(t, tok.Replaces)
+	assert.Equal(t, 1, lists["group/a"], "the rotation uses the listing of the run")
+
+	registryA.Source.ID = tok.ID
+	found, err := g.GetToken(&registryA.Source)
+	require.NoError(t, err)
+	assert.Equal(t, "10", found.ID, "the created token is found")
+	assert.Equal(t, 2, lists["group/a"], "the listing is invalidated after a creation")
+
+	require.NoError(t, g.RevokeToken(&registryA.Source, tok.Replaces))
+	registryA.Source.ID = tok.Replaces.ID
+	_, err = g.GetToken(&registryA.Source)
+	assert.ErrorIs(t, err, ErrTokenNotFound, "the revoked token is no longer found")
+	_, err = g.GetToken(&ciA.Source)
+	require.NoError(t, err)
+	assert.Equal(t, 3, lists["group/a"], "the listing is invalidated after a revocation")
+
+	_, err = g.GetToken(&registryB.Source)
+	require.NoError(t, err)
+	assert.Equal(t, 1, lists["group/b"], "the listings of other owners are kept")
+}
@@ -1,14 +1,72 @@
 package source
 
-import "github.com/hamba/pkg/v2/errors"
+import (
+	"errors"
+
+	errors2 "github.com/hamba/pkg/v2/errors"
+	"gitlab.com/sickit/token-operator/pkg/backend"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
 
 const (
-	ErrUnauthorized          = errors.Error("unauthorized")
-	ErrForbidden             = errors.Error("forbidden")
-	ErrNotFound              = errors.Error("not found")
-	ErrTokenNotFound         = errors.Error("token not found")
-	ErrTokenCreationFailed   = errors.Error("token could not be created")
-	ErrTokenRotationFailed   = errors.Error("token could not be rotated")
-	ErrTokenRevocationFailed = errors.Error("token could not be revoked")
-	ErrLicenseRequired       = errors.Error("enterprise license is required")
+	ErrUnauthorized          = backend.ErrUnauthorized
+	ErrForbidden             = backend.ErrForbidden
+	ErrNotFound              = backend.ErrNotFound
+	ErrRateLimited           = backend.ErrRateLimited
+	ErrServerError           = backend.ErrServerError
+	ErrTokenNotFound         = errors2.Error("token not found")
+	ErrTokenExpired          = errors2.Error("token expired")
+	ErrTokenRevoked          = errors2.Error("token revoked")
+	ErrTokenCreationFailed   = errors2.Error("token could not be created")
+	ErrTokenRotationFailed   = errors2.Error("token could not be rotated")
+	ErrTokenRevocationFailed = errors2.Error("token could not be revoked")
+	ErrLicenseRequired       = errors2.Error("enterprise license is required")
+	ErrAdminRequired         = errors2.Error("admin rights are required")
+	ErrUserNotFound          = errors2.Error("user not found")
+	ErrInvalidPattern        = errors2.Error("glob pattern must start with a group path")
+	ErrAmbiguousToken        = errors2.Error("multiple tokens match the name")
+	ErrCreationUnsupported   = errors2.Error("token can't be created for source type")
+	ErrInvalidOwnerType      = errors2.Error("invalid owner type, expected project or group")
+	ErrInsufficientScope     = errors2.Error("source token lacks a required scope")
+	ErrTypeUnsupported       = errors2.Error("token type is not supported by the source")
+	ErrDeletionUnsupported   = errors2.Error("token can't be deleted for source type")
 )
+
+// errorKinds classifies the errors of this package.
+var errorKinds = []struct {
+	err  error
+	kind token.ErrorKind
+}{
+	{ErrUnauthorized, token.ErrorKindUnauthorized},
+	{ErrForbidden, token.ErrorKindForbidden},
+	{ErrLicenseRequired, token.ErrorKindForbidden},
+	{ErrAdminRequired, token.ErrorKindForbidden},
+	{ErrInsufficientScope, token.ErrorKindForbidden},
+	{ErrNotFound, token.ErrorKindNotFound},
+	{ErrTokenNotFound, token.ErrorKindNotFound},
+	{ErrUserNotFound, token.ErrorKindNotFound},
+	{ErrTokenExpired, token.ErrorKindExpired},
+	{ErrTokenRevoked, token.ErrorKindRevoked},
+	{ErrRateLimited, token.ErrorKindRateLimited},
+	{ErrServerError, token.ErrorKindUnavailable},
+	{ErrInvalidPattern, token.ErrorKindInvalid},
+	{ErrAmbiguousToken, token.ErrorKindInvalid},
+	{ErrCreationUnsupported, token.ErrorKindInvalid},
+	{ErrInvalidOwnerType, token.ErrorKindInvalid},
+	{ErrTypeUnsupported, token.ErrorKindInvalid},
+	{ErrDeletionUnsupported, token.ErrorKindInvalid},
+}
+
+func errorKind(err error) token.ErrorKind {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.kind
+		}
+	}
+	return token.ErrorKindUnknown
+}
+
+// annotate wraps err into a token.Error of the operation on the named token.
+func annotate(op, name string, err error) error {
+	return token.Annotate(err, BackendGitLab, op, name, errorKind(err))
+}
@@ -0,0 +1,67 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hamba/logger/v2"
+	"github.com/sethvargo/go-retry"
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+func TestGitLab_isRetriable(t *testing.T) {
+	g := &GitLab{log: logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)}
+	response := func(code int) *gitlab.Response {
+		return &gitlab.Response{Response: &http.Response{StatusCode: code, Status: http.StatusText(code), Header: http.Header{}}}
+	}
+	apiErr := errors.New("api error")
+
+	tests := []struct {
+		name      string
+		resp      *gitlab.Response
+		err       error
+		retriable bool
+		want      error
+		kind      token.ErrorKind
+	}{
+		{name: "success", resp: response(http.StatusOK)},
+		{name: "transport error", resp: nil, err: apiErr, retriable: true, want: apiErr, kind: token.ErrorKindUnavailable},
+		{name: "rate limited", resp: response(http.StatusTooManyRequests), err: apiErr, retriable: true, want: ErrRateLimited, kind: token.ErrorKindRateLimited},
+		{name: "server error", resp: response(http.StatusBadGateway), err: apiErr, retriable: true, want: ErrServerError, kind: token.ErrorKindUnavailable},
+		{name: "not found", resp: response(http.StatusNotFound), err: apiErr, want: ErrNotFound, kind: token.ErrorKindNotFound},
+		{name: "bad request", resp: response(http.StatusBadRequest), err: apiErr, want: apiErr, kind: token.ErrorKindInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			got := retry.Do(context.Background(), retry.WithMaxRetries(1, retry.NewConstant(time.Nanosecond)), func(ctx context.Context) error {
+				attempts++
+				return g.isRetriable(tt.resp, tt.err)
+			})
+			if tt.err == nil {
+				assert.NoError(t, got)
+				return
+			}
+
+			assert.Equal(t, tt.retriable, attempts == 2)
+			assert.ErrorIs(t, got, tt.want)
+
+			var operr *token.Error
+			if assert.ErrorAs(t, got, &operr) {
+				assert.Equal(t, BackendGitLab, operr.Backend)
+				assert.Equal(t, tt.kind, operr.Kind)
+				assert.Equal(t, tt.retriable, operr.Retriable)
+				if tt.resp != nil {
+					assert.Equal(t, tt.resp.StatusCode, operr.Status)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,462 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hamba/cmd/v3/observe"
+	"github.com/hamba/logger/v2"
+	lctx "github.com/hamba/logger/v2/ctx"
+	"github.com/sethvargo/go-retry"
+	"gitlab.com/sickit/token-operator/pkg/backend"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+// BackendGitea is the backend of errors returned by the Gitea source, it also serves Forgejo.
+const BackendGitea = "gitea"
+
+// giteaPageSize is the requested page size of listings, Gitea caps it at its MAX_RESPONSE_ITEMS (50 by default).
+const giteaPageSize = 50
+
+type GiteaOption func(*Gitea)
+
+func WithGiteaDryRun(dryRun bool) GiteaOption {
+	return func(g *Gitea) {
+		g.dryRun = dryRun
+	}
+}
+
+// WithGiteaRateLimit configures the client-side rate limit, shared by all sources of the same URL.
+func WithGiteaRateLimit(limit backend.RateLimit) GiteaOption {
+	return func(g *Gitea) {
+		g.rateLimit = limit
+	}
+}
+
+// Gitea implements the application TokenSource for access tokens of Gitea and Forgejo users.
+// Gitea only manages tokens with basic authentication, so the source authenticates with username and password.
+// Access tokens never expire and can't be rotated, rotation creates a new token and deletes the previous one.
+type Gitea struct {
+	client   *http.Client
+	url      string
+	username string
+	password string
+	dryRun   bool
+	backoff  retry.Backoff
+
+	rateLimit backend.RateLimit
+	limiter   *backend.Limiter
+	// cache holds the token listings of the run
+	cache *listCache
+
+	log *logger.Logger
+	ctx context.Context
+}
+
+// giteaToken is an access token of the Gitea API, Sha1 is the token value, only returned on creation.
+type giteaToken struct {
+	ID             int64    `json:"id"`
+	Name           string   `json:"name"`
+	Sha1           string   `json:"sha1"`
+	TokenLastEight string   `json:"token_last_eight"`
+	Scopes         []string `json:"scopes"`
+}
+
+// NewGiteaSource creates a source for a Gitea or Forgejo instance, url is the API URL, e.g. https://gitea.example.com/api/v1.
+func NewGiteaSource(ctx context.Context, url, username, password string, obsvr *observe.Observer, opts ...GiteaOption) (*Gitea, error) {
+	if username == "" {
+		return nil, fmt.Errorf("gitea source requires a username")
+	}
+
+	b := retry.NewExponential(50 * time.Millisecond)
+	b = retry.WithMaxRetries(10, b)
+	b = retry.WithMaxDuration(30*time.Second, b)
+
+	g := &Gitea{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		url:      strings.TrimSuffix(url, "/"),
+		username: username,
+		password: password,
+		backoff:  b,
+		cache:    newListCache(),
+		log:      obsvr.Log,
+		ctx:      ctx,
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+	g.limiter = backend.SharedLimiter(url, g.rateLimit)
+
+	return g, nil
+}
+
+func (g *Gitea) GetToken(source *token.Source) (_ *token.Token, err error) {
+	defer func() { err = annotateGitea("get", source.Name, err) }()
+
+	if source.Type != TypePersonal {
+		return nil, fmt.Errorf("%w: %s", ErrTypeUnsupported, source.Type)
+	}
+
+	tok, err := g.findToken(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find access token: %w", err)
+	}
+
+	return g.token(tok, g.owner(source)), nil
+}
+
+func (g *Gitea) CreateToken(config *token.Config) (_ *token.Token, err error) {
+	defer func() { err = annotateGitea("create", config.Source.Name, err) }()
+
+	if config.Source.Type != TypePersonal {
+		return nil, fmt.Errorf("%w: %s", ErrTypeUnsupported, config.Source.Type)
+	}
+
+	return g.createToken(config)
+}
+
+// RotateToken emulates rotation, as access tokens can't be rotated: a new token is created and the previous one
+// is deleted once the new one is stored. Token names are unique per user, so the new token gets a free name.
+func (g *Gitea) RotateToken(config *token.Config) (_ *token.Token, err error) {
+	defer func() { err = annotateGitea("rotate", config.Source.Name, err) }()
+
+	if config.Source.Type != TypePersonal {
+		return nil, fmt.Errorf("%w: %s", ErrTypeUnsupported, config.Source.Type)
+	}
+
+	gltoken, err := g.findToken(&config.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate token: %w", err)
+	}
+	previous := g.token(gltoken, g.owner(&config.Source))
+
+	tok, err := g.createToken(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate token: %w", err)
+	}
+	tok.Replaces = previous
+
+	return tok, nil
+}
+
+func (g *Gitea) DeleteToken(source *token.Source) (err error) {
+	defer func() { err = annotateGitea("delete", source.Name, err) }()
+
+	if source.Type != TypePersonal {
+		return fmt.Errorf("%w: %s", ErrTypeUnsupported, source.Type)
+	}
+
+	tok, err := g.findToken(source)
+	if err != nil {
+		return fmt.Errorf("failed to find access token: %w", err)
+	}
+
+	return g.deleteToken(source, g.owner(source), tok.ID)
+}
+
+// RevokeToken deletes a token by its ID, used for the previous token of an emulated or overlapping rotation.
+func (g *Gitea) RevokeToken(source *token.Source, tok *token.Token) (err error) {
+	defer func() { err = annotateGitea("revoke", source.Name, err) }()
+
+	id, err := strconv.ParseInt(tok.ID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid access token ID %s: %w", tok.ID, err)
+	}
+
+	return g.deleteToken(source, tok.Owner, id)
+}
+
+// ReportsExpiry returns false, access tokens never expire and listings have no creation time.
+func (g *Gitea) ReportsExpiry(*token.Source) bool {
+	return false
+}
+
+// Preflight checks the credentials of the source, tokens of other users require an admin.
+func (g *Gitea) Preflight(configs []token.Config) error {
+	user := struct {
+		Login   string `json:"login"`
+		IsAdmin bool   `json:"is_admin"`
+	}{}
+	if _, err := g.do(http.MethodGet, "/user", nil, &user); err != nil {
+		return annotateGitea("preflight", "", fmt.Errorf("failed to get source user: %w", err))
+	}
+	g.log.Debug("source user", lctx.Str("login", user.Login), lctx.Bool("admin", user.IsAdmin))
+
+	errs := []error{}
+	for _, cfg := range configs {
+		if cfg.State == token.TokenStateInactive || user.IsAdmin {
+			continue
+		}
+		if owner := g.owner(&cfg.Source); owner != user.Login {
+			errs = append(errs, fmt.Errorf("%w: token %s of user %s", ErrAdminRequired, cfg.Name, owner))
+		}
+	}
+
+	return annotateGitea("preflight", user.Login, errors.Join(errs...))
+}
+
+// owner returns the user of a token, the user of the source if no owner is set.
+// Tokens of other users can only be managed as admin.
+func (g *Gitea) owner(source *token.Source) string {
+	if source.Owner == "" {
+		return g.username
+	}
+	return source.Owner
+}
+
+// rotatedSuffix is the suffix of rotated token names, a marker and the Unix timestamp of the rotation.
+// Token names are unique per user, so the new token of a rotation can't reuse the name of the previous one.
+const rotatedSuffix = "-rotated-"
+
+// rotatedName matches the suffix of rotated token names, timestamps have 10 digits until 2286.
+var rotatedName = regexp.MustCompile(`^` + regexp.QuoteMeta(rotatedSuffix) + `\d{10}$`)
+
+// matchesName returns true if a token has the configured name, or the name of a rotation of it.
+func matchesName(tokenName, name string) bool {
+	if tokenName == name {
+		return true
+	}
+	suffix, ok := strings.CutPrefix(tokenName, name)
+	return ok && rotatedName.MatchString(suffix)
+}
+
+func (g *Gitea) findToken(source *token.Source) (*giteaToken, error) {
+	toks, err := g.tokens(g.owner(source))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list access tokens: %w", err)
+	}
+
+	candidates := []*giteaToken{}
+	for _, tok := range toks {
+		if matchesName(tok.Name, source.Name) {
+			candidates = append(candidates, tok)
+		}
+	}
+
+	tok, err := selectToken(source, candidates, func(tok *giteaToken) (int64, string) {
+		return tok.ID, tok.Name
+	})
+	if err != nil {
+		return nil, err
+	}
+	g.log.Debug("matching access token", lctx.Str("name", tok.Name), lctx.Int64("id", tok.ID), lctx.Str("owner", g.owner(source)))
+
+	return tok, nil
+}
+
+// tokens lists all access tokens of a user, once per run.
+func (g *Gitea) tokens(owner string) ([]*giteaToken, error) {
+	return cachedList(g.cache, listKey(listPersonal, owner, ""), func() ([]*giteaToken, error) {
+		all := []*giteaToken{}
+		seen := map[int64]bool{}
+		for page := 1; ; page++ {
+			toks := []*giteaToken{}
+			query := url.Values{"page": {strconv.Itoa(page)}, "limit": {strconv.Itoa(giteaPageSize)}}
+			header, err := g.do(http.MethodGet, g.tokensPath(owner)+"?"+query.Encode(), nil, &toks)
+			if err != nil {
+				return nil, err
+			}
+			// a server ignoring the page would repeat its tokens
+			added := 0
+			for _, tok := range toks {
+				if !seen[tok.ID] {
+					seen[tok.ID] = true
+					all = append(all, tok)
+					added++
+				}
+			}
+
+			// instances may cap the page size below the requested limit, the total count tells when the listing ends
+			total, err := strconv.Atoi(header.Get("X-Total-Count"))
+			if err != nil {
+				total = -1
+			}
+			switch {
+			case added == 0, total >= 0 && len(all) >= total, total < 0 && len(toks) < giteaPageSize:
+				return all, nil
+			}
+		}
+	})
+}
+
+func (g *Gitea) createToken(config *token.Config) (*token.Token, error) {
+	owner := g.owner(&config.Source)
+	toks, err := g.tokens(owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list access tokens: %w", err)
+	}
+	name := freeName(config.Source.Name, toks, time.Now())
+	// access tokens never expire, their expiry is tracked in the status file
+	expires := time.Now().Add(config.Rotation.Lifetime())
+
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not creating access token for user", lctx.Str("name", name), lctx.Str("owner", owner))
+		return &token.Token{
+			Name:       name,
+			Scopes:     config.Source.Scopes,
+			Type:       TypePersonal,
+			Owner:      owner,
+			Value:      "dry-run",
+			Expiration: expires,
+		}, nil
+	}
+
+	body := map[string]any{"name": name, "scopes": config.Source.Scopes}
+	tok := &giteaToken{}
+	if _, err = g.do(http.MethodPost, g.tokensPath(owner), body, tok); err != nil {
+		return nil, fmt.Errorf("failed to create access token: %w", err)
+	}
+	if tok.Sha1 == "" {
+		g.log.Error("failed to create access token, no value returned", lctx.Str("name", name))
+		return nil, ErrTokenCreationFailed
+	}
+	g.log.Debug("created access token", lctx.Str("name", tok.Name), lctx.Int64("id", tok.ID), lctx.Str("owner", owner))
+	g.cache.invalidate(listPersonal, owner)
+
+	created := g.token(tok, owner)
+	created.Expiration = expires
+
+	return created, nil
+}
+
+// freeName returns name if no token uses it, otherwise name with the rotated suffix.
+func freeName(name string, toks []*giteaToken, now time.Time) string {
+	for _, tok := range toks {
+		if tok.Name == name {
+			return fmt.Sprintf("%s%s%d", name, rotatedSuffix, now.Unix())
+		}
+	}
+	return name
+}
+
+func (g *Gitea) deleteToken(source *token.Source, owner string, id int64) error {
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not deleting access token", lctx.Str("name", source.Name), lctx.Int64("id", id), lctx.Str("owner", owner))
+		return nil
+	}
+
+	if _, err := g.do(http.MethodDelete, g.tokensPath(owner)+"/"+strconv.FormatInt(id, 10), nil, nil); err != nil {
+		return fmt.Errorf("failed to delete access token: %w", err)
+	}
+	g.cache.invalidate(listPersonal, owner)
+	g.log.Debug("deleted access token", lctx.Str("name", source.Name), lctx.Int64("id", id), lctx.Str("owner", owner))
+
+	return nil
+}
+
+func (g *Gitea) tokensPath(owner string) string {
+	return "/users/" + url.PathEscape(owner) + "/tokens"
+}
+
+// token converts an access token, access tokens never expire and listings have no creation time.
+func (g *Gitea) token(tok *giteaToken, owner string) *token.Token {
+	return &token.Token{
+		ID:     strconv.FormatInt(tok.ID, 10),
+		Name:   tok.Name,
+		Scopes: tok.Scopes,
+		Type:   TypePersonal,
+		Owner:  owner,
+		Value:  tok.Sha1,
+	}
+}
+
+// do sends a request to the API, encoding body and decoding the response into out if set.
+// It returns the headers of the response.
+func (g *Gitea) do(method, path string, body, out any) (http.Header, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	var header http.Header
+	err := retry.Do(g.ctx, g.backoff, func(ctx context.Context) error {
+		if err := g.limiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, g.url+path, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(g.username, g.password)
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := g.client.Do(req)
+		if err != nil {
+			return g.isRetriable(nil, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return g.isRetriable(nil, err)
+		}
+		if resp.StatusCode >= http.StatusBadRequest {
+			return g.isRetriable(resp, fmt.Errorf("%s: %s", resp.Status, apiMessage(data)))
+		}
+		if retryErr := g.isRetriable(resp, nil); retryErr != nil {
+			return retryErr
+		}
+
+		header = resp.Header
+		if out != nil {
+			if err = json.Unmarshal(data, out); err != nil {
+				return fmt.Errorf("failed to decode response: %w", err)
+			}
+		}
+		return nil
+	})
+	return header, err
+}
+
+// apiMessage returns the message of an API error response, or the response itself.
+func apiMessage(data []byte) string {
+	msg := struct {
+		Message string `json:"message"`
+	}{}
+	if err := json.Unmarshal(data, &msg); err == nil && msg.Message != "" {
+		return msg.Message
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// isRetriable classifies the result of a request, see backend.Classify. Rate limit headers pause all requests to the instance.
+func (g *Gitea) isRetriable(resp *http.Response, err error) error {
+	if resp == nil {
+		if err != nil {
+			g.log.Debug("retry on err", lctx.Err(err))
+		}
+		return backend.Classify(BackendGitea, nil, err)
+	}
+
+	if wait := g.limiter.Observe(resp); wait > 0 {
+		g.log.Info("rate limit reached, pausing requests", lctx.Duration("wait", wait), lctx.Str("status", resp.Status))
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		g.log.Debug("retry on server error", lctx.Str("status", resp.Status))
+	}
+
+	return backend.Classify(BackendGitea, resp, err)
+}
+
+// annotateGitea wraps err into a token.Error of the operation on the named token.
+func annotateGitea(op, name string, err error) error {
+	return token.Annotate(err, BackendGitea, op, name, errorKind(err))
+}
@@ -0,0 +1,333 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hamba/cmd/v3/observe"
+	"github.com/hamba/logger/v2"
+	"github.com/sethvargo/go-retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+// fakeGitea is a stand-in for the access token API of Gitea, it serves the tokens of any user.
+type fakeGitea struct {
+	mu     sync.Mutex
+	tokens map[string][]*giteaToken
+	nextID int64
+	admin  bool
+	// maxLimit caps the page size if set, ignorePage serves the first page for every page
+	maxLimit   int
+	ignorePage bool
+	// fail is the status of the next responses, if set
+	fail []int
+}
+
+func newFakeGitea(t *testing.T) (*fakeGitea, *httptest.Server) {
+	t.Helper()
+
+	f := &fakeGitea{tokens: map[string][]*giteaToken{}, nextID: 1}
+	srv := httptest.NewServer(http.StripPrefix("/api/v1", f))
+	t.Cleanup(srv.Close)
+
+	return f, srv
+}
+
+func (f *fakeGitea) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "user does not exist"})
+		return
+	}
+	if len(f.fail) > 0 {
+		code := f.fail[0]
+		f.fail = f.fail[1:]
+		writeJSON(w, code, map[string]string{"message": http.StatusText(code)})
+		return
+	}
+
+	if r.URL.Path == "/user" {
+		writeJSON(w, http.StatusOK, map[string]any{"login": "admin", "is_admin": f.admin})
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 3 || parts[0] != "users" || parts[2] != "tokens" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "not found"})
+		return
+	}
+	owner := parts[1]
+
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 3:
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if f.maxLimit > 0 {
+			limit = min(limit, f.maxLimit)
+		}
+		if f.ignorePage {
+			page = 1
+		}
+		toks := f.tokens[owner]
+		start := min((page-1)*limit, len(toks))
+		end := min(start+limit, len(toks))
+		list := make([]giteaToken, 0, end-start)
+		for _, tok := range toks[start:end] {
+			list = append(list, giteaToken{ID: tok.ID, Name: tok.Name, Scopes: tok.Scopes, TokenLastEight: tok.Sha1[len(tok.Sha1)-8:]})
+		}
+		if !f.ignorePage {
+			w.Header().Set("X-Total-Count", strconv.Itoa(len(toks)))
+		}
+		writeJSON(w, http.StatusOK, list)
+	case r.Method == http.MethodPost && len(parts) == 3:
+		req := struct {
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": err.Error()})
+			return
+		}
+		for _, tok := range f.tokens[owner] {
+			if tok.Name == req.Name {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"message": "access token name has been used already"})
+				return
+			}
+		}
+		tok := &giteaToken{ID: f.nextID, Name: req.Name, Scopes: req.Scopes, Sha1: "sha1-value-" + strconv.FormatInt(f.nextID, 10)}
+		f.nextID++
+		f.tokens[owner] = append(f.tokens[owner], tok)
+		writeJSON(w, http.StatusCreated, tok)
+	case r.Method == http.MethodDelete && len(parts) == 4:
+		toks := f.tokens[owner]
+		for i, tok := range toks {
+			if strconv.FormatInt(tok.ID, 10) == parts[3] {
+				f.tokens[owner] = append(toks[:i], toks[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "access token does not exist"})
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"message": "method not allowed"})
+	}
+}
+
+func (f *fakeGitea) names(owner string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	names := []string{}
+	for _, tok := range f.tokens[owner] {
+		names = append(names, tok.Name)
+	}
+	return names
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newTestGitea(t *testing.T, url, password string, opts ...GiteaOption) *Gitea {
+	t.Helper()
+
+	obsvr := &observe.Observer{Log: logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)}
+	g, err := NewGiteaSource(context.Background(), url+"/api/v1", "admin", password, obsvr, opts...)
+	require.NoError(t, err)
+	g.backoff = retry.WithMaxRetries(3, retry.NewConstant(time.Millisecond))
+
+	return g
+}
+
+func TestGitea_Lifecycle(t *testing.T) {
+	f, srv := newFakeGitea(t)
+	g := newTestGitea(t, srv.URL, "secret")
+	config := &token.Config{Name: "renovate", Rotation: &token.Rotation{Validity: 30 * 24 * time.Hour}, Source: token.Source{Name: "renovate", Type: TypePersonal, Scopes: []string{"write:repository"}}}
+
+	_, err := g.GetToken(&config.Source)
+	require.ErrorIs(t, err, ErrTokenNotFound)
+
+	created, err := g.CreateToken(config)
+	require.NoError(t, err)
+	assert.Equal(t, "renovate", created.Name)
+	assert.Equal(t, "admin", created.Owner)
+	assert.Equal(t, "sha1-value-1", created.Value)
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), created.Expiration, time.Minute)
+
+	found, err := g.GetToken(&config.Source)
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, found.ID)
+	assert.Empty(t, found.Value, "listings don't return the value")
+
+	rotated, err := g.RotateToken(config)
+	require.NoError(t, err)
+	require.NotNil(t, rotated.Replaces)
+	assert.Equal(t, created.ID, rotated.Replaces.ID)
+	assert.NotEqual(t, created.ID, rotated.ID)
+	assert.Regexp(t, `^renovate-rotated-\d{10}$`, rotated.Name, "token names are unique, the new token gets a suffix")
+	assert.Len(t, f.names("admin"), 2, "the previous token lives until it is revoked")
+
+	require.NoError(t, g.RevokeToken(&config.Source, rotated.Replaces))
+	assert.Equal(t, []string{rotated.Name}, f.names("admin"))
+
+	found, err = g.GetToken(&config.Source)
+	require.NoError(t, err)
+	assert.Equal(t, rotated.ID, found.ID, "rotated tokens are found by the configured name")
+
+	require.NoError(t, g.DeleteToken(&config.Source))
+	assert.Empty(t, f.names("admin"))
+}
+
+func TestGitea_FindToken(t *testing.T) {
+	f, srv := newFakeGitea(t)
+	f.tokens["bot"] = []*giteaToken{
+		{ID: 3, Name: "renovate", Sha1: "sha1-value-3"},
+		{ID: 9, Name: "renovate-rotated-1700000000", Sha1: "sha1-value-9"},
+		{ID: 12, Name: "renovate-ci", Sha1: "sha1-value-12"},
+		{ID: 14, Name: "renovate-2024", Sha1: "sha1-value-14"},
+		{ID: 15, Name: "deploy", Sha1: "sha1-value-15"},
+		{ID: 16, Name: "deploy-rotated-17", Sha1: "sha1-value-16"},
+	}
+	g := newTestGitea(t, srv.URL, "secret")
+
+	tests := []struct {
+		name    string
+		source  token.Source
+		wantID  string
+		wantErr error
+	}{
+		{name: "ambiguous after interrupted rotation", source: token.Source{Name: "renovate", Type: TypePersonal, Owner: "bot"}, wantErr: ErrAmbiguousToken},
+		{name: "pinned", source: token.Source{Name: "renovate", Type: TypePersonal, Owner: "bot", ID: "3"}, wantID: "3"},
+		{name: "previous run", source: token.Source{Name: "renovate", Type: TypePersonal, Owner: "bot", LastID: "9"}, wantID: "9"},
+		{name: "stale pin", source: token.Source{Name: "renovate", Type: TypePersonal, Owner: "bot", ID: "5"}, wantErr: ErrTokenNotFound},
+		{name: "unmanaged suffix is not a rotation", source: token.Source{Name: "renovate", Type: TypePersonal, Owner: "bot", LastID: "14"}, wantErr: ErrAmbiguousToken},
+		{name: "other name", source: token.Source{Name: "renovate-ci", Type: TypePersonal, Owner: "bot"}, wantID: "12"},
+		{name: "user token with similar name", source: token.Source{Name: "renovate-2024", Type: TypePersonal, Owner: "bot"}, wantID: "14"},
+		{name: "short timestamp is not a rotation", source: token.Source{Name: "deploy", Type: TypePersonal, Owner: "bot"}, wantID: "15"},
+		{name: "stale pin with single match", source: token.Source{Name: "deploy", Type: TypePersonal, Owner: "bot", ID: "5"}, wantErr: ErrTokenNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok, err := g.GetToken(&tt.source)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantID, tok.ID)
+		})
+	}
+}
+
+func TestGitea_Tokens(t *testing.T) {
+	toks := make([]*giteaToken, 0, 70)
+	for i := range 70 {
+		toks = append(toks, &giteaToken{ID: int64(i + 1), Name: "token-" + strconv.Itoa(i), Sha1: "sha1-value-" + strconv.Itoa(i)})
+	}
+
+	tests := []struct {
+		name       string
+		maxLimit   int
+		ignorePage bool
+		want       int
+	}{
+		{name: "pages", want: 70},
+		{name: "capped page size", maxLimit: 30, want: 70},
+		{name: "page ignored", ignorePage: true, want: giteaPageSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, srv := newFakeGitea(t)
+			f.tokens["admin"] = toks
+			f.maxLimit = tt.maxLimit
+			f.ignorePage = tt.ignorePage
+			g := newTestGitea(t, srv.URL, "secret")
+
+			got, err := g.tokens("admin")
+
+			require.NoError(t, err)
+			assert.Len(t, got, tt.want)
+		})
+	}
+}
+
+func TestGitea_DeleteRefusesAmbiguousToken(t *testing.T) {
+	f, srv := newFakeGitea(t)
+	f.tokens["admin"] = []*giteaToken{
+		{ID: 1, Name: "renovate", Sha1: "sha1-value-1"},
+		{ID: 2, Name: "renovate-rotated-1700000000", Sha1: "sha1-value-2"},
+	}
+	g := newTestGitea(t, srv.URL, "secret")
+
+	err := g.DeleteToken(&token.Source{Name: "renovate", Type: TypePersonal})
+
+	assert.ErrorIs(t, err, ErrAmbiguousToken)
+	assert.Len(t, f.names("admin"), 2)
+}
+
+func TestGitea_Preflight(t *testing.T) {
+	f, srv := newFakeGitea(t)
+	g := newTestGitea(t, srv.URL, "secret")
+	own := token.Config{Name: "renovate", State: token.TokenStateActive, Source: token.Source{Name: "renovate", Type: TypePersonal}}
+	other := token.Config{Name: "bot", State: token.TokenStateActive, Source: token.Source{Name: "bot", Type: TypePersonal, Owner: "bot"}}
+
+	require.NoError(t, g.Preflight([]token.Config{own}))
+	assert.ErrorIs(t, g.Preflight([]token.Config{own, other}), ErrAdminRequired)
+
+	f.admin = true
+	require.NoError(t, g.Preflight([]token.Config{own, other}))
+
+	assert.ErrorIs(t, newTestGitea(t, srv.URL, "wrong").Preflight([]token.Config{own}), ErrUnauthorized)
+}
+
+func TestGitea_DryRun(t *testing.T) {
+	f, srv := newFakeGitea(t)
+	f.tokens["admin"] = []*giteaToken{{ID: 1, Name: "renovate", Sha1: "sha1-value-1"}}
+	g := newTestGitea(t, srv.URL, "secret", WithGiteaDryRun(true))
+	config := &token.Config{Name: "renovate", Rotation: &token.Rotation{Validity: 30 * 24 * time.Hour}, Source: token.Source{Name: "renovate", Type: TypePersonal}}
+
+	tok, err := g.RotateToken(config)
+	require.NoError(t, err)
+	assert.Equal(t, "dry-run", tok.Value)
+
+	require.NoError(t, g.DeleteToken(&config.Source))
+	assert.Equal(t, []string{"renovate"}, f.names("admin"))
+}
+
+func TestGitea_Errors(t *testing.T) {
+	f, srv := newFakeGitea(t)
+	config := &token.Config{Name: "renovate", Rotation: &token.Rotation{Validity: 30 * 24 * time.Hour}, Source: token.Source{Name: "renovate", Type: TypePersonal}}
+
+	_, err := newTestGitea(t, srv.URL, "wrong").CreateToken(config)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	var operr *token.Error
+	if assert.ErrorAs(t, err, &operr) {
+		assert.Equal(t, BackendGitea, operr.Backend)
+		assert.Equal(t, token.ErrorKindUnauthorized, operr.Kind)
+	}
+
+	g := newTestGitea(t, srv.URL, "secret")
+	_, err = g.CreateToken(&token.Config{Name: "runner", Rotation: config.Rotation, Source: token.Source{Name: "runner", Type: TypeRunner}})
+	assert.ErrorIs(t, err, ErrTypeUnsupported)
+
+	f.fail = []int{http.StatusBadGateway}
+	_, err = g.CreateToken(config)
+	require.NoError(t, err, "server errors are retried")
+}
@@ -2,22 +2,56 @@ package source
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hamba/logger/v2"
 	lctx "github.com/hamba/logger/v2/ctx"
 	"github.com/sethvargo/go-retry"
 	"gitlab.com/gitlab-org/api/client-go"
+	"gitlab.com/sickit/token-operator/pkg/backend"
 	"gitlab.com/sickit/token-operator/pkg/token"
 )
 
+// BackendGitLab is the backend of errors returned by the GitLab source.
+const BackendGitLab = "gitlab"
+
 const (
-	TypePersonal = "personal"
-	TypeProject  = "project"
-	TypeGroup    = "group"
+	TypePersonal         = "personal"
+	TypeProject          = "project"
+	TypeGroup            = "group"
+	TypeImpersonation    = "impersonation"
+	TypeDeploy           = "deploy"
+	TypeTrigger          = "trigger"
+	TypeRunner           = "runner"
+	TypeOAuthApplication = "oauth_application"
+	TypeServiceAccount   = "service_account"
+	TypeDeployKey        = "deploy_key"
 )
 
+// communityTypes are the source types available without an enterprise license.
+var communityTypes = map[string]bool{
+	TypePersonal:         true,
+	TypeImpersonation:    true,
+	TypeDeploy:           true,
+	TypeTrigger:          true,
+	TypeRunner:           true,
+	TypeOAuthApplication: true,
+	TypeServiceAccount:   true,
+	TypeDeployKey:        true,
+}
+
+// IsCommunityType returns true if the source type is available without an enterprise license.
+func IsCommunityType(typ string) bool {
+	return communityTypes[typ]
+}
+
 type GitLabOption func(*GitLab)
 
 func WithDryRun(dryRun bool) GitLabOption {
@@ -26,6 +60,28 @@ func WithDryRun(dryRun bool) GitLabOption {
 	}
 }
 
+// WithRateLimit configures the client-side rate limit, shared by all sources of the same URL.
+func WithRateLimit(limit backend.RateLimit) GitLabOption {
+	return func(g *GitLab) {
+		g.rateLimit = limit
+	}
+}
+
+// WithLocation sets the timezone of the GitLab instance, expiry dates are calendar dates in it. The default is UTC.
+func WithLocation(loc *time.Location) GitLabOption {
+	return func(g *GitLab) {
+		g.location = loc
+	}
+}
+
+// WithExpiryWarning sets how long before its expiry a warning is logged for the source token.
+// The default is DefaultCredentialWarning.
+func WithExpiryWarning(d time.Duration) GitLabOption {
+	return func(g *GitLab) {
+		g.expiryWarning = d
+	}
+}
+
 // GitLab implements the application TokenSource for GitLab tokens.
 type GitLab struct {
 	client  *gitlab.Client
@@ -33,63 +89,452 @@ type GitLab struct {
 	dryRun  bool
 	backoff retry.Backoff
 
+	rateLimit backend.RateLimit
+	limiter   *backend.Limiter
+	location  *time.Location
+	// expiryWarning is how long before its expiry a warning is logged for the source token
+	expiryWarning time.Duration
+
+	// user is the user of the source token
+	user *gitlab.User
+	// userIDs caches the IDs of token owners by username
+	userIDs map[string]int64
+	// cache holds the token listings of the run
+	cache *listCache
+
 	log *logger.Logger
 	ctx context.Context
 }
 
-func (g *GitLab) findPersonalToken(source *token.Source) (*gitlab.PersonalAccessToken, error) {
-	lsopt := &gitlab.ListPersonalAccessTokensOptions{
-		Search: gitlab.Ptr(source.Name),
-		// Info: we cannot rotate inactive tokens.
-		State: gitlab.Ptr(string(gitlab.AccessTokenStateActive)),
+// detectUser gets the user of the source token and whether it has admin rights. Tokens that can't read
+// their user, e.g. without the read_user scope, are no admin and have no user.
+func (g *GitLab) detectUser() error {
+	b := g.backoff
+	user := &gitlab.User{}
+	resp := &gitlab.Response{}
+	err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		user, resp, err = g.client.Users.CurrentUser(gitlab.WithContext(g.ctx))
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if errors.Is(err, ErrForbidden) {
+		g.log.Warn("source token can't read its user, assuming no admin rights", lctx.Err(err))
+		g.user, g.admin = nil, false
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get current user: %w", err)
+	}
+
+	g.user = user
+	g.admin = user.IsAdmin
+	g.log.Debug("detected source user", lctx.Str("username", user.Username), lctx.Int64("id", user.ID), lctx.Bool("admin", user.IsAdmin))
+
+	return nil
+}
+
+// expiryDate returns the expiry date of a new token in the timezone of the GitLab instance.
+func (g *GitLab) expiryDate(rotation *token.Rotation) time.Time {
+	return rotation.ExpiryDate(time.Now(), g.loc())
+}
+
+// expiration returns the end of an expiry date, GitLab keeps tokens valid until the end of their expiry date.
+// Tokens without expiry date never expire.
+func (g *GitLab) expiration(date *gitlab.ISOTime) time.Time {
+	if date == nil {
+		return time.Time{}
+	}
+	return token.EndOfDay(time.Time(*date), g.loc())
+}
+
+// ReportsExpiry returns false for oauth application secrets, they never expire and have no creation time,
+// and for runner authentication tokens, as runner listings don't include the expiry of their token.
+func (g *GitLab) ReportsExpiry(source *token.Source) bool {
+	return source.Type != TypeOAuthApplication && source.Type != TypeRunner
+}
+
+// timeOrZero returns the time of an optional timestamp.
+func timeOrZero(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+func (g *GitLab) loc() *time.Location {
+	if g.location == nil {
+		return time.UTC
+	}
+	return g.location
+}
+
+// ownerID returns the user ID of a token owner, given as username or ID. An empty owner is the current user.
+// Tokens of other users can only be managed as admin.
+func (g *GitLab) ownerID(owner string) (int64, error) {
+	if g.isCurrentUser(owner) {
+		return g.user.ID, nil
+	}
+	if owner == "" {
+		return 0, fmt.Errorf("%w: the source token can't read its user, set owner", ErrUserNotFound)
+	}
+
+	if !g.admin {
+		g.log.Error("managing tokens of other users is only supported as admin", lctx.Str("owner", owner))
+		return 0, ErrAdminRequired
+	}
+
+	if id, err := strconv.ParseInt(owner, 10, 64); err == nil {
+		return id, nil
+	}
+
+	if id, ok := g.userIDs[owner]; ok {
+		return id, nil
 	}
 
 	b := g.backoff
-	toks := []*gitlab.PersonalAccessToken{}
+	users := []*gitlab.User{}
 	resp := &gitlab.Response{}
 	err := retry.Do(g.ctx, b, func(ctx context.Context) error {
 		var err error
-		toks, resp, err = g.client.PersonalAccessTokens.ListPersonalAccessTokens(lsopt, gitlab.WithContext(g.ctx))
+		users, resp, err = g.client.Users.ListUsers(&gitlab.ListUsersOptions{Username: gitlab.Ptr(owner)}, gitlab.WithContext(g.ctx))
 		if retryErr := g.isRetriable(resp, err); retryErr != nil {
 			return retryErr
 		}
-
-		return err
+		return nil
 	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to find user %s: %w", owner, err)
+	}
 
+	for _, user := range users {
+		if user.Username == owner {
+			g.userIDs[owner] = user.ID
+			return user.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrUserNotFound, owner)
+}
+
+// personalAccessToken adds the fields of personal access tokens the client doesn't decode yet.
+type personalAccessToken struct {
+	gitlab.PersonalAccessToken
+
+	LastUsedIPs []string `json:"last_used_ips"`
+}
+
+func (g *GitLab) findPersonalToken(source *token.Source) (*personalAccessToken, error) {
+	uid, err := g.ownerID(source.Owner)
+	if err != nil {
+		return nil, err
+	}
+
+	// Info: we cannot rotate inactive tokens.
+	toks, err := g.personalTokens(uid, gitlab.AccessTokenStateActive)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list personal tokens: %w", err)
 	}
 
-	gltoken := &gitlab.PersonalAccessToken{}
+	candidates := []*personalAccessToken{}
 	for _, tok := range toks {
 		if tok.Name == source.Name {
-			g.log.Debug("matching personal token", lctx.Str("name", tok.Name), lctx.Int64("id", tok.ID))
-			gltoken = tok
-			break
+			candidates = append(candidates, tok)
 		}
 	}
 
-	if gltoken.ID == 0 {
-		return nil, ErrTokenNotFound
+	if len(candidates) == 0 {
+		return nil, g.inactivePersonalToken(source, uid)
 	}
 
+	gltoken, err := selectPersonalToken(source, candidates)
+	if err != nil {
+		return nil, err
+	}
+	g.log.Debug("matching personal token", lctx.Str("name", gltoken.Name), lctx.Int64("id", gltoken.ID))
+
 	return gltoken, nil
 }
 
+// inactivePersonalToken tells an expired or revoked token apart from a token that never existed.
+func (g *GitLab) inactivePersonalToken(source *token.Source, uid int64) error {
+	toks, err := g.personalTokens(uid, gitlab.AccessTokenStateInactive)
+	if err != nil {
+		return fmt.Errorf("failed to list inactive personal tokens: %w", err)
+	}
+
+	var newest *personalAccessToken
+	for _, tok := range toks {
+		if tok.Name == source.Name && (newest == nil || tok.ID > newest.ID) {
+			newest = tok
+		}
+	}
+
+	if newest == nil {
+		return ErrTokenNotFound
+	}
+	return inactiveError(source.Name, newest.ID, newest.Revoked)
+}
+
+// personalTokens lists all personal tokens of a user in a state, once per run.
+func (g *GitLab) personalTokens(uid int64, state gitlab.AccessTokenState) ([]*personalAccessToken, error) {
+	return cachedList(g.cache, listKey(listPersonal, uid, string(state)), func() ([]*personalAccessToken, error) {
+		lsopt := &gitlab.ListPersonalAccessTokensOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100, Page: 1},
+			State:       gitlab.Ptr(string(state)),
+			UserID:      gitlab.Ptr(uid),
+		}
+
+		all := []*personalAccessToken{}
+		for lsopt.Page != 0 {
+			b := g.backoff
+			toks := []*personalAccessToken{}
+			resp := &gitlab.Response{}
+			err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+				// the client doesn't decode last_used_ips, so the listing is requested directly
+				req, err := g.client.NewRequest(http.MethodGet, "personal_access_tokens", lsopt, []gitlab.RequestOptionFunc{gitlab.WithContext(g.ctx)})
+				if err != nil {
+					return err
+				}
+				toks = []*personalAccessToken{}
+				resp, err = g.client.Do(req, &toks)
+				if retryErr := g.isRetriable(resp, err); retryErr != nil {
+					return retryErr
+				}
+
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			all = append(all, toks...)
+			lsopt.Page = resp.NextPage
+		}
+		g.log.Debug("listed personal tokens", lctx.Int64("userID", uid), lctx.Str("state", string(state)), lctx.Int("count", len(all)))
+
+		return all, nil
+	})
+}
+
+// inactiveError reports why the newest token with a matching name is no longer active.
+func inactiveError(name string, id int64, revoked bool) error {
+	if revoked {
+		return fmt.Errorf("%w: '%s' (ID %d)", ErrTokenRevoked, name, id)
+	}
+	return fmt.Errorf("%w: '%s' (ID %d)", ErrTokenExpired, name, id)
+}
+
+// selectPersonalToken selects the personal token pinned by ID, resolved in the previous run or the only candidate.
+func selectPersonalToken(source *token.Source, candidates []*personalAccessToken) (*personalAccessToken, error) {
+	return selectToken(source, candidates, func(tok *personalAccessToken) (int64, string) {
+		return tok.ID, fmt.Sprintf("expires %s", tok.ExpiresAt)
+	})
+}
+
+// selectToken selects the token pinned by ID, the token resolved in the previous run or the only token
+// with a matching name. Several tokens with the same name are ambiguous without an ID, e.g. after an interrupted
+// rotation. The describe func returns the ID of a token and a hint listed with the candidates of an ambiguous name.
+func selectToken[T any](source *token.Source, candidates []T, describe func(T) (int64, string)) (T, error) {
+	var zero T
+	// a pin never resolves to another token, only the token of the previous run falls back to the name
+	id := source.LastID
+	if source.ID != "" {
+		id = source.ID
+	}
+	if id != "" {
+		for _, tok := range candidates {
+			if tokID, _ := describe(tok); strconv.FormatInt(tokID, 10) == id {
+				return tok, nil
+			}
+		}
+	}
+
+	switch {
+	case source.ID != "":
+		return zero, fmt.Errorf("%w: pinned ID %s", ErrTokenNotFound, source.ID)
+	case len(candidates) == 0:
+		return zero, ErrTokenNotFound
+	case len(candidates) == 1:
+		return candidates[0], nil
+	}
+
+	ids := make([]string, 0, len(candidates))
+	for _, tok := range candidates {
+		id, hint := describe(tok)
+		ids = append(ids, fmt.Sprintf("%d (%s)", id, hint))
+	}
+	return zero, fmt.Errorf("%w '%s', set source.id to one of: %s", ErrAmbiguousToken, source.Name, strings.Join(ids, ", "))
+}
+
+// isRetriable classifies the result of a request, see backend.Classify. Rate limit headers pause all requests to the instance.
 func (g *GitLab) isRetriable(resp *gitlab.Response, err error) error {
-	if err == nil {
-		switch resp.StatusCode {
-		case http.StatusUnauthorized:
-			return ErrUnauthorized
-		case http.StatusForbidden:
-			return ErrForbidden
-		case http.StatusNotFound:
-			return ErrNotFound
+	if resp == nil || resp.Response == nil {
+		if err != nil {
+			g.log.Debug("retry on err", lctx.Err(err))
+		}
+		return backend.Classify(BackendGitLab, nil, err)
+	}
+
+	if g.limiter != nil {
+		if wait := g.limiter.Observe(resp.Response); wait > 0 {
+			g.log.Info("rate limit reached, pausing requests", lctx.Duration("wait", wait), lctx.Str("status", resp.Status))
+		}
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		g.log.Debug("retry on server error", lctx.Str("status", resp.Status))
+	}
+
+	return backend.Classify(BackendGitLab, resp.Response, err)
+}
+
+// ResolveProjects returns the full paths of all projects matching a glob pattern, e.g. "group/*".
+// A "*" does not match "/", so "group/*/*" matches the projects of direct subgroups.
+func (g *GitLab) ResolveProjects(pattern string) ([]string, error) {
+	base := globBase(pattern)
+	switch base {
+	case pattern:
+		return []string{pattern}, nil
+	case "":
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPattern, pattern)
+	}
+
+	opt := &gitlab.ListGroupProjectsOptions{
+		ListOptions:      gitlab.ListOptions{PerPage: 100, Page: 1},
+		IncludeSubGroups: gitlab.Ptr(true),
+		Archived:         gitlab.Ptr(false),
+		Simple:           gitlab.Ptr(true),
+	}
+
+	paths := []string{}
+	for opt.Page != 0 {
+		b := g.backoff
+		projects := []*gitlab.Project{}
+		resp := &gitlab.Response{}
+		err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+			var err error
+			projects, resp, err = g.client.Groups.ListGroupProjects(base, opt, gitlab.WithContext(g.ctx))
+			if retryErr := g.isRetriable(resp, err); retryErr != nil {
+				return retryErr
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list projects of group %s: %w", base, err)
+		}
+
+		for _, p := range projects {
+			if ok, _ := path.Match(pattern, p.PathWithNamespace); ok {
+				paths = append(paths, p.PathWithNamespace)
+			}
+		}
+		opt.Page = resp.NextPage
+	}
+
+	g.log.Debug("resolved projects", lctx.Str("pattern", pattern), lctx.Int("count", len(paths)))
+	return paths, nil
+}
+
+// ResolveGroups returns the full paths of all groups matching a glob pattern, e.g. "group/*".
+func (g *GitLab) ResolveGroups(pattern string) ([]string, error) {
+	base := globBase(pattern)
+	switch base {
+	case pattern:
+		return []string{pattern}, nil
+	case "":
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPattern, pattern)
+	}
+
+	opt := &gitlab.ListDescendantGroupsOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100, Page: 1},
+	}
+
+	paths := []string{}
+	for opt.Page != 0 {
+		b := g.backoff
+		groups := []*gitlab.Group{}
+		resp := &gitlab.Response{}
+		err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+			var err error
+			groups, resp, err = g.client.Groups.ListDescendantGroups(base, opt, gitlab.WithContext(g.ctx))
+			if retryErr := g.isRetriable(resp, err); retryErr != nil {
+				return retryErr
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list subgroups of group %s: %w", base, err)
+		}
+
+		for _, grp := range groups {
+			if ok, _ := path.Match(pattern, grp.FullPath); ok {
+				paths = append(paths, grp.FullPath)
+			}
+		}
+		opt.Page = resp.NextPage
+	}
+
+	g.log.Debug("resolved groups", lctx.Str("pattern", pattern), lctx.Int("count", len(paths)))
+	return paths, nil
+}
+
+// globBase returns the leading path segments of a glob pattern without wildcards.
+func globBase(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if strings.ContainsAny(segment, "*?[") {
+			return strings.Join(segments[:i], "/")
+		}
+	}
+	return pattern
+}
+
+// File is a file of a GitLab repository at a resolved revision.
+type File struct {
+	Project      string
+	Path         string
+	Ref          string
+	CommitID     string
+	LastCommitID string
+	BlobID       string
+	Content      []byte
+}
+
+// GetFile returns a file of a project repository at a branch, tag or commit SHA, "HEAD" is the default branch.
+func (g *GitLab) GetFile(project, filePath, ref string) (*File, error) {
+	opt := &gitlab.GetFileOptions{
+		Ref: gitlab.Ptr(ref),
+	}
+
+	b := g.backoff
+	file := &gitlab.File{}
+	resp := &gitlab.Response{}
+	err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		file, resp, err = g.client.RepositoryFiles.GetFile(project, filePath, opt, gitlab.WithContext(g.ctx))
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
 		}
 		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file %s from %s@%s: %w", filePath, project, ref, err)
+	}
+
+	content, err := base64.StdEncoding.DecodeString(file.Content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode file %s: %w", filePath, err)
 	}
 
-	g.log.Debug("retry on err", lctx.Err(err))
-	return retry.RetryableError(err)
+	return &File{
+		Project:      project,
+		Path:         file.FilePath,
+		Ref:          file.Ref,
+		CommitID:     file.CommitID,
+		LastCommitID: file.LastCommitID,
+		BlobID:       file.BlobID,
+		Content:      content,
+	}, nil
 }
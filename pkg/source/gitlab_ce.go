@@ -13,23 +13,20 @@ import (
 	lctx "github.com/hamba/logger/v2/ctx"
 	"github.com/sethvargo/go-retry"
 	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"gitlab.com/sickit/token-operator/pkg/backend"
 	"gitlab.com/sickit/token-operator/pkg/token"
 )
 
 func NewGitLabSource(ctx context.Context, url, token string, obsvr *observe.Observer, opts ...GitLabOption) (*GitLab, error) {
-	glab, err := gitlab.NewClient(token, gitlab.WithBaseURL(url))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create gitlab client: %w", err)
-	}
-
 	b := retry.NewExponential(50 * time.Millisecond)
 	b = retry.WithMaxRetries(10, b)
 	b = retry.WithMaxDuration(30*time.Second, b)
 
 	glsrc := &GitLab{
-		client:  glab,
 		admin:   false,
 		backoff: b,
+		userIDs: map[string]int64{},
+		cache:   newListCache(),
 		log:     obsvr.Log,
 		ctx:     ctx,
 	}
@@ -38,11 +35,46 @@ func NewGitLabSource(ctx context.Context, url, token string, obsvr *observe.Obse
 		opt(glsrc)
 	}
 
+	// retries are handled by isRetriable, the limiter is shared by all sources of the same URL
+	glsrc.limiter = backend.SharedLimiter(url, glsrc.rateLimit)
+	glab, err := gitlab.NewClient(token,
+		gitlab.WithBaseURL(url),
+		gitlab.WithCustomLimiter(glsrc.limiter),
+		gitlab.WithCustomRetryMax(0),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gitlab client: %w", err)
+	}
+	glsrc.client = glab
+
+	// admin rights are required to create tokens and to manage tokens of other users
+	if err = glsrc.detectUser(); err != nil {
+		return nil, err
+	}
+
 	return glsrc, nil
 }
 
-func (g *GitLab) GetToken(source *token.Source) (*token.Token, error) {
-	if source.Type != TypePersonal {
+func (g *GitLab) GetToken(source *token.Source) (_ *token.Token, err error) {
+	defer func() { err = annotate("get", source.Name, err) }()
+
+	switch source.Type {
+	case TypePersonal:
+	case TypeImpersonation:
+		return g.getImpersonationToken(source)
+	case TypeDeploy:
+		return g.getDeployToken(source)
+	case TypeTrigger:
+		return g.getTriggerToken(source)
+	case TypeRunner:
+		return g.getRunnerToken(source)
+	case TypeOAuthApplication:
+		return g.getApplicationSecret(source)
+	case TypeServiceAccount:
+		return g.getServiceAccountToken(source)
+	case TypeDeployKey:
+		return g.getDeployKey(source)
+	default:
 		return nil, ErrLicenseRequired
 	}
 
@@ -51,36 +83,62 @@ func (g *GitLab) GetToken(source *token.Source) (*token.Token, error) {
 		return nil, fmt.Errorf("failed to find personal token: %w", err)
 	}
 
-	expires, err := time.Parse(time.DateOnly, gltoken.ExpiresAt.String())
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse expiration: %w", err)
-	}
+	expires := g.expiration(gltoken.ExpiresAt)
 
 	return &token.Token{
+		ID:          strconv.FormatInt(gltoken.ID, 10),
 		Name:        gltoken.Name,
 		Description: gltoken.Description,
 		Scopes:      gltoken.Scopes,
+		Type:        TypePersonal,
+		Owner:       strconv.FormatInt(gltoken.UserID, 10),
 		Value:       "",
 		Expiration:  expires,
+		Created:     timeOrZero(gltoken.CreatedAt),
+		LastUsed:    timeOrZero(gltoken.LastUsedAt),
+		LastUsedIPs: gltoken.LastUsedIPs,
 	}, nil
 }
 
-func (g *GitLab) CreateToken(config *token.Config) (*token.Token, error) {
-	if config.Source.Type != TypePersonal {
+func (g *GitLab) CreateToken(config *token.Config) (_ *token.Token, err error) {
+	defer func() { err = annotate("create", config.Source.Name, err) }()
+
+	switch config.Source.Type {
+	case TypePersonal:
+	case TypeImpersonation:
+		return g.createImpersonationToken(config)
+	case TypeDeploy:
+		return g.createDeployToken(config)
+	case TypeTrigger:
+		return g.createTriggerToken(config)
+	case TypeRunner:
+		// runners are registered with a runner manager, only their authentication token is rotated
+		return nil, ErrCreationUnsupported
+	case TypeOAuthApplication:
+		// applications need a redirect URI and scopes, only their secret is rotated
+		return nil, ErrCreationUnsupported
+	case TypeServiceAccount:
+		return g.createServiceAccountToken(config)
+	case TypeDeployKey:
+		return g.createDeployKey(config)
+	default:
 		return nil, ErrLicenseRequired
 	}
 
+	// the preflight rejects personal tokens that would have to be created without admin
 	if !g.admin {
 		g.log.Error("Personal token creation only supported as admin", lctx.Str("name", config.Source.Name))
-		return nil, ErrLicenseRequired
+		return nil, ErrAdminRequired
 	}
 
-	expireISO, err := gitlab.ParseISOTime(time.Now().Add(config.Rotation.Validity).Format(time.DateOnly))
+	uid, err := g.ownerID(config.Source.Owner)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse validity: %w", err)
+		return nil, fmt.Errorf("failed to create personal access token: %w", err)
 	}
 
-	opt := &gitlab.CreatePersonalAccessTokenForCurrentUserOptions{
+	expireISO := gitlab.ISOTime(g.expiryDate(config.Rotation))
+
+	opt := &gitlab.CreatePersonalAccessTokenOptions{
 		Name:        &config.Source.Name,
 		Description: &config.Source.Description,
 		Scopes:      &config.Source.Scopes,
@@ -88,13 +146,14 @@ func (g *GitLab) CreateToken(config *token.Config) (*token.Token, error) {
 	}
 
 	if g.dryRun {
-		g.log.Info("dry-run flag set, not creating token for current user", lctx.Str("name", config.Source.Name))
+		g.log.Info("dry-run flag set, not creating token for user", lctx.Str("name", config.Source.Name), lctx.Int64("userID", uid))
 		return &token.Token{
 			Name:        config.Source.Name,
 			Description: config.Source.Description,
 			Scopes:      config.Source.Scopes,
 			Type:        TypePersonal,
-			Expiration:  time.Now().Add(config.Rotation.Validity),
+			Owner:       strconv.FormatInt(uid, 10),
+			Expiration:  g.expiration(&expireISO),
 			Value:       "dry-run",
 		}, nil
 	}
@@ -104,7 +163,7 @@ func (g *GitLab) CreateToken(config *token.Config) (*token.Token, error) {
 	resp := &gitlab.Response{}
 	err = retry.Do(g.ctx, b, func(ctx context.Context) error {
 		var err error
-		tok, resp, err = g.client.Users.CreatePersonalAccessTokenForCurrentUser(opt, gitlab.WithContext(g.ctx))
+		tok, resp, err = g.client.Users.CreatePersonalAccessToken(uid, opt, gitlab.WithContext(g.ctx))
 		if retryErr := g.isRetriable(resp, err); retryErr != nil {
 			return retryErr
 		}
@@ -119,24 +178,43 @@ func (g *GitLab) CreateToken(config *token.Config) (*token.Token, error) {
 		g.log.Error("failed to create personal access token", lctx.Str("name", config.Source.Name), lctx.Str("status", resp.Status))
 		return nil, ErrTokenCreationFailed
 	}
+	g.log.Debug("created personal token", lctx.Str("name", tok.Name), lctx.Int64("id", tok.ID), lctx.Int64("userID", tok.UserID))
+	g.cache.invalidate(listPersonal, uid)
 
-	expire, err := time.Parse(time.DateOnly, tok.ExpiresAt.String())
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse expiration: %w", err)
-	}
+	expire := g.expiration(tok.ExpiresAt)
 
 	return &token.Token{
+		ID:          strconv.FormatInt(tok.ID, 10),
 		Name:        tok.Name,
 		Description: tok.Description,
 		Scopes:      tok.Scopes,
 		Type:        TypePersonal,
 		Owner:       strconv.FormatInt(tok.UserID, 10),
+		Value:       tok.Token,
 		Expiration:  expire,
 	}, nil
 }
 
-func (g *GitLab) RotateToken(config *token.Config) (*token.Token, error) {
-	if config.Source.Type != TypePersonal {
+func (g *GitLab) RotateToken(config *token.Config) (_ *token.Token, err error) {
+	defer func() { err = annotate("rotate", config.Source.Name, err) }()
+
+	switch config.Source.Type {
+	case TypePersonal:
+	case TypeImpersonation:
+		return g.rotateImpersonationToken(config)
+	case TypeDeploy:
+		return g.rotateDeployToken(config)
+	case TypeTrigger:
+		return g.rotateTriggerToken(config)
+	case TypeRunner:
+		return g.rotateRunnerToken(config)
+	case TypeOAuthApplication:
+		return g.rotateApplicationSecret(config)
+	case TypeServiceAccount:
+		return g.rotateServiceAccountToken(config)
+	case TypeDeployKey:
+		return g.rotateDeployKey(config)
+	default:
 		return nil, ErrLicenseRequired
 	}
 
@@ -146,10 +224,7 @@ func (g *GitLab) RotateToken(config *token.Config) (*token.Token, error) {
 		return nil, fmt.Errorf("failed to rotate token: %w", err)
 	}
 
-	expireISO, err := gitlab.ParseISOTime(time.Now().Add(config.Rotation.Validity).Format(time.DateOnly))
-	if err != nil {
-		return nil, fmt.Errorf("failed to rotate token: %w", err)
-	}
+	expireISO := gitlab.ISOTime(g.expiryDate(config.Rotation))
 
 	rtopt := &gitlab.RotatePersonalAccessTokenOptions{
 		ExpiresAt: &expireISO,
@@ -163,7 +238,7 @@ func (g *GitLab) RotateToken(config *token.Config) (*token.Token, error) {
 			Scopes:      config.Source.Scopes,
 			Type:        TypePersonal,
 			Owner:       strconv.FormatInt(gltoken.UserID, 10),
-			Expiration:  time.Now().Add(config.Rotation.Validity),
+			Expiration:  g.expiration(&expireISO),
 			Value:       "dry-run",
 		}, nil
 	}
@@ -189,13 +264,12 @@ func (g *GitLab) RotateToken(config *token.Config) (*token.Token, error) {
 		return nil, ErrTokenRotationFailed
 	}
 	g.log.Debug("rotated personal token", lctx.Str("name", tok.Name), lctx.Int64("id", tok.ID))
+	g.cache.invalidate(listPersonal, gltoken.UserID)
 
-	expire, err := time.Parse(time.DateOnly, tok.ExpiresAt.String())
-	if err != nil {
-		return nil, fmt.Errorf("failed to rotate token: %w", err)
-	}
+	expire := g.expiration(tok.ExpiresAt)
 
 	return &token.Token{
+		ID:          strconv.FormatInt(tok.ID, 10),
 		Name:        tok.Name,
 		Description: tok.Description,
 		Scopes:      tok.Scopes,
@@ -206,8 +280,26 @@ func (g *GitLab) RotateToken(config *token.Config) (*token.Token, error) {
 	}, nil
 }
 
-func (g *GitLab) DeleteToken(source *token.Source) error {
-	if source.Type != TypePersonal {
+func (g *GitLab) DeleteToken(source *token.Source) (err error) {
+	defer func() { err = annotate("delete", source.Name, err) }()
+
+	switch source.Type {
+	case TypePersonal:
+	case TypeImpersonation:
+		return g.deleteImpersonationToken(source)
+	case TypeDeploy:
+		return g.deleteDeployToken(source)
+	case TypeTrigger:
+		return g.deleteTriggerToken(source)
+	case TypeRunner:
+		return g.deleteRunner(source)
+	case TypeOAuthApplication:
+		return g.deleteApplication(source)
+	case TypeServiceAccount:
+		return g.deleteServiceAccountToken(source)
+	case TypeDeployKey:
+		return g.deleteDeployKey(source)
+	default:
 		return ErrLicenseRequired
 	}
 
@@ -216,16 +308,20 @@ func (g *GitLab) DeleteToken(source *token.Source) error {
 		return fmt.Errorf("failed to find personal token: %w", err)
 	}
 
+	return g.revokePersonalToken(source, gltoken.ID)
+}
+
+func (g *GitLab) revokePersonalToken(source *token.Source, id int64) error {
 	if g.dryRun {
-		g.log.Info("dry-run flag set, not deleting token for user", lctx.Str("name", source.Name), lctx.Int64("userID", gltoken.UserID))
+		g.log.Info("dry-run flag set, not revoking personal token", lctx.Str("name", source.Name), lctx.Int64("id", id))
 		return nil
 	}
 
 	b := g.backoff
 	resp := &gitlab.Response{}
-	err = retry.Do(g.ctx, b, func(ctx context.Context) error {
+	err := retry.Do(g.ctx, b, func(ctx context.Context) error {
 		var err error
-		resp, err = g.client.PersonalAccessTokens.RevokePersonalAccessToken(gltoken.ID, gitlab.WithContext(g.ctx))
+		resp, err = g.client.PersonalAccessTokens.RevokePersonalAccessToken(id, gitlab.WithContext(g.ctx))
 		if retryErr := g.isRetriable(resp, err); retryErr != nil {
 			return retryErr
 		}
@@ -236,6 +332,9 @@ func (g *GitLab) DeleteToken(source *token.Source) error {
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	// the owner is not known for revocations of a previous run
+	g.cache.invalidate(listPersonal, nil)
+
 	if resp.StatusCode != http.StatusNoContent {
 		g.log.Error("failed to revoke token", lctx.Str("name", source.Name), lctx.Str("status", resp.Status))
 		return ErrTokenRevocationFailed
@@ -243,3 +342,29 @@ func (g *GitLab) DeleteToken(source *token.Source) error {
 
 	return nil
 }
+
+// RevokeToken revokes a token by its ID, used for the previous token of an emulated or overlapping rotation.
+func (g *GitLab) RevokeToken(source *token.Source, tok *token.Token) (err error) {
+	defer func() { err = annotate("revoke", source.Name, err) }()
+
+	switch source.Type {
+	case TypePersonal:
+		id, err := strconv.ParseInt(tok.ID, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid personal token ID %s: %w", tok.ID, err)
+		}
+		return g.revokePersonalToken(source, id)
+	case TypeImpersonation:
+		return g.revokeImpersonationToken(source, tok)
+	case TypeDeploy:
+		return g.revokeDeployToken(source, tok)
+	case TypeTrigger:
+		return g.revokeTriggerToken(source, tok)
+	case TypeServiceAccount:
+		return g.revokeServiceAccountToken(source, tok)
+	case TypeDeployKey:
+		return g.revokeDeployKey(source, tok)
+	}
+
+	return ErrLicenseRequired
+}
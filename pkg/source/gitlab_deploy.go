@@ -0,0 +1,262 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	lctx "github.com/hamba/logger/v2/ctx"
+	"github.com/sethvargo/go-retry"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+const (
+	OwnerTypeProject = "project"
+	OwnerTypeGroup   = "group"
+)
+
+// isGroupOwner returns true if a deploy token belongs to a group, project is the default.
+func isGroupOwner(source *token.Source) (bool, error) {
+	switch source.OwnerType {
+	case "", OwnerTypeProject:
+		return false, nil
+	case OwnerTypeGroup:
+		return true, nil
+	}
+	return false, fmt.Errorf("%w: %s", ErrInvalidOwnerType, source.OwnerType)
+}
+
+func (g *GitLab) findDeployToken(source *token.Source) (*gitlab.DeployToken, error) {
+	group, err := isGroupOwner(source)
+	if err != nil {
+		return nil, err
+	}
+
+	toks, err := g.deployTokens(source.Owner, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deploy tokens: %w", err)
+	}
+
+	candidates := []*gitlab.DeployToken{}
+	var inactive *gitlab.DeployToken
+	for _, tok := range toks {
+		if tok.Name != source.Name {
+			continue
+		}
+		if tok.Revoked || tok.Expired {
+			if inactive == nil || tok.ID > inactive.ID {
+				inactive = tok
+			}
+			continue
+		}
+		candidates = append(candidates, tok)
+	}
+	if len(candidates) == 0 && inactive != nil {
+		return nil, inactiveError(source.Name, inactive.ID, inactive.Revoked)
+	}
+
+	gltoken, err := selectToken(source, candidates, func(tok *gitlab.DeployToken) (int64, string) {
+		return tok.ID, tok.Username
+	})
+	if err != nil {
+		return nil, err
+	}
+	g.log.Debug("matching deploy token", lctx.Str("name", gltoken.Name), lctx.Int64("id", gltoken.ID), lctx.Str("owner", source.Owner))
+
+	return gltoken, nil
+}
+
+// deployTokens lists all deploy tokens of a project or group, once per run.
+func (g *GitLab) deployTokens(owner string, group bool) ([]*gitlab.DeployToken, error) {
+	return cachedList(g.cache, listKey(listDeploy, owner, strconv.FormatBool(group)), func() ([]*gitlab.DeployToken, error) {
+		all := []*gitlab.DeployToken{}
+		page := int64(1)
+		for page != 0 {
+			b := g.backoff
+			opt := gitlab.ListOptions{PerPage: 100, Page: page}
+			toks := []*gitlab.DeployToken{}
+			resp := &gitlab.Response{}
+			err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+				var err error
+				if group {
+					toks, resp, err = g.client.DeployTokens.ListGroupDeployTokens(owner, &gitlab.ListGroupDeployTokensOptions{ListOptions: opt}, gitlab.WithContext(g.ctx))
+				} else {
+					toks, resp, err = g.client.DeployTokens.ListProjectDeployTokens(owner, &gitlab.ListProjectDeployTokensOptions{ListOptions: opt}, gitlab.WithContext(g.ctx))
+				}
+				if retryErr := g.isRetriable(resp, err); retryErr != nil {
+					return retryErr
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			all = append(all, toks...)
+			page = resp.NextPage
+		}
+
+		return all, nil
+	})
+}
+
+func (g *GitLab) getDeployToken(source *token.Source) (*token.Token, error) {
+	gltoken, err := g.findDeployToken(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find deploy token: %w", err)
+	}
+
+	return deployToken(gltoken, source.Owner), nil
+}
+
+func (g *GitLab) createDeployToken(config *token.Config) (*token.Token, error) {
+	group, err := isGroupOwner(&config.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create deploy token: %w", err)
+	}
+
+	// deploy tokens expire at an exact time, the end of the expiry date
+	expire := token.EndOfDay(g.expiryDate(config.Rotation), g.loc())
+
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not creating deploy token", lctx.Str("name", config.Source.Name), lctx.Str("owner", config.Source.Owner))
+		return &token.Token{
+			Name:       config.Source.Name,
+			Scopes:     config.Source.Scopes,
+			Type:       TypeDeploy,
+			Owner:      config.Source.Owner,
+			Username:   "dry-run",
+			Expiration: expire,
+			Value:      "dry-run",
+		}, nil
+	}
+
+	b := g.backoff
+	tok := &gitlab.DeployToken{}
+	resp := &gitlab.Response{}
+	err = retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		if group {
+			tok, resp, err = g.client.DeployTokens.CreateGroupDeployToken(config.Source.Owner, &gitlab.CreateGroupDeployTokenOptions{
+				Name:      &config.Source.Name,
+				Scopes:    &config.Source.Scopes,
+				ExpiresAt: &expire,
+			}, gitlab.WithContext(g.ctx))
+		} else {
+			tok, resp, err = g.client.DeployTokens.CreateProjectDeployToken(config.Source.Owner, &gitlab.CreateProjectDeployTokenOptions{
+				Name:      &config.Source.Name,
+				Scopes:    &config.Source.Scopes,
+				ExpiresAt: &expire,
+			}, gitlab.WithContext(g.ctx))
+		}
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create deploy token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusCreated {
+		g.log.Error("failed to create deploy token", lctx.Str("name", config.Source.Name), lctx.Str("status", resp.Status))
+		return nil, ErrTokenCreationFailed
+	}
+	g.log.Debug("created deploy token", lctx.Str("name", tok.Name), lctx.Int64("id", tok.ID), lctx.Str("owner", config.Source.Owner))
+	g.cache.invalidate(listDeploy, config.Source.Owner)
+
+	return deployToken(tok, config.Source.Owner), nil
+}
+
+// rotateDeployToken emulates rotation, as deploy tokens can't be rotated through the API:
+// a new token with the same name is created and the previous one is revoked once the new one is stored.
+func (g *GitLab) rotateDeployToken(config *token.Config) (*token.Token, error) {
+	previous, err := g.getDeployToken(&config.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate token: %w", err)
+	}
+
+	tok, err := g.createDeployToken(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate token: %w", err)
+	}
+	tok.Replaces = previous
+
+	return tok, nil
+}
+
+func (g *GitLab) revokeDeployToken(source *token.Source, tok *token.Token) error {
+	group, err := isGroupOwner(source)
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseInt(tok.ID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid deploy token ID %s: %w", tok.ID, err)
+	}
+
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not revoking deploy token", lctx.Str("name", source.Name), lctx.Int64("id", id), lctx.Str("owner", source.Owner))
+		return nil
+	}
+
+	b := g.backoff
+	resp := &gitlab.Response{}
+	err = retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		if group {
+			resp, err = g.client.DeployTokens.DeleteGroupDeployToken(source.Owner, id, gitlab.WithContext(g.ctx))
+		} else {
+			resp, err = g.client.DeployTokens.DeleteProjectDeployToken(source.Owner, id, gitlab.WithContext(g.ctx))
+		}
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke deploy token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	g.cache.invalidate(listDeploy, source.Owner)
+
+	if resp.StatusCode != http.StatusNoContent {
+		g.log.Error("failed to revoke deploy token", lctx.Str("name", source.Name), lctx.Str("status", resp.Status))
+		return ErrTokenRevocationFailed
+	}
+	g.log.Debug("revoked deploy token", lctx.Str("name", source.Name), lctx.Int64("id", id), lctx.Str("owner", source.Owner))
+
+	return nil
+}
+
+func (g *GitLab) deleteDeployToken(source *token.Source) error {
+	tok, err := g.getDeployToken(source)
+	if err != nil {
+		return err
+	}
+
+	return g.revokeDeployToken(source, tok)
+}
+
+// deployToken converts a GitLab deploy token, deploy tokens without expiration never expire.
+func deployToken(tok *gitlab.DeployToken, owner string) *token.Token {
+	expires := time.Time{}
+	if tok.ExpiresAt != nil {
+		expires = *tok.ExpiresAt
+	}
+
+	return &token.Token{
+		ID:         strconv.FormatInt(tok.ID, 10),
+		Name:       tok.Name,
+		Scopes:     tok.Scopes,
+		Type:       TypeDeploy,
+		Owner:      owner,
+		Username:   tok.Username,
+		Value:      tok.Token,
+		Expiration: expires,
+	}
+}
@@ -0,0 +1,246 @@
+package source
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	lctx "github.com/hamba/logger/v2/ctx"
+	"github.com/sethvargo/go-retry"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"gitlab.com/sickit/token-operator/pkg/token"
+	"golang.org/x/crypto/ssh"
+)
+
+// generateKeyPair generates an ed25519 key pair, the public key in authorized_keys format
+// and the private key in OpenSSH PEM format.
+func generateKeyPair(comment string) (string, string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate key pair: %w", err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode public key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, comment)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	public := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " " + comment
+	return public, string(pem.EncodeToMemory(block)), nil
+}
+
+func (g *GitLab) findDeployKey(source *token.Source) (*gitlab.ProjectDeployKey, error) {
+	keys, err := g.deployKeys(source.Owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deploy keys: %w", err)
+	}
+
+	now := time.Now()
+	candidates := []*gitlab.ProjectDeployKey{}
+	var expired *gitlab.ProjectDeployKey
+	for _, k := range keys {
+		if k.Title != source.Name {
+			continue
+		}
+		if k.ExpiresAt != nil && k.ExpiresAt.Before(now) {
+			if expired == nil || k.ID > expired.ID {
+				expired = k
+			}
+			continue
+		}
+		candidates = append(candidates, k)
+	}
+	// deleted deploy keys are gone, a remaining key can only have expired
+	if len(candidates) == 0 && expired != nil {
+		return nil, inactiveError(source.Name, expired.ID, false)
+	}
+
+	key, err := selectToken(source, candidates, func(k *gitlab.ProjectDeployKey) (int64, string) {
+		return k.ID, k.FingerprintSHA256
+	})
+	if err != nil {
+		return nil, err
+	}
+	g.log.Debug("matching deploy key", lctx.Str("name", key.Title), lctx.Int64("id", key.ID), lctx.Str("fingerprint", key.FingerprintSHA256), lctx.Str("owner", source.Owner))
+
+	return key, nil
+}
+
+// deployKeys lists all deploy keys of a project, once per run.
+func (g *GitLab) deployKeys(owner string) ([]*gitlab.ProjectDeployKey, error) {
+	return cachedList(g.cache, listKey(listDeployKey, owner, ""), func() ([]*gitlab.ProjectDeployKey, error) {
+		opt := &gitlab.ListProjectDeployKeysOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100, Page: 1},
+		}
+
+		all := []*gitlab.ProjectDeployKey{}
+		for opt.Page != 0 {
+			b := g.backoff
+			keys := []*gitlab.ProjectDeployKey{}
+			resp := &gitlab.Response{}
+			err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+				var err error
+				keys, resp, err = g.client.DeployKeys.ListProjectDeployKeys(owner, opt, gitlab.WithContext(g.ctx))
+				if retryErr := g.isRetriable(resp, err); retryErr != nil {
+					return retryErr
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			all = append(all, keys...)
+			opt.Page = resp.NextPage
+		}
+
+		return all, nil
+	})
+}
+
+func (g *GitLab) getDeployKey(source *token.Source) (*token.Token, error) {
+	key, err := g.findDeployKey(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find deploy key: %w", err)
+	}
+
+	return deployKey(key, source.Owner, ""), nil
+}
+
+// createDeployKey generates a key pair and registers its public key, the private key is the value of the token.
+func (g *GitLab) createDeployKey(config *token.Config) (*token.Token, error) {
+	// deploy keys expire at an exact time, the end of the expiry date
+	expire := token.EndOfDay(g.expiryDate(config.Rotation), g.loc())
+
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not creating deploy key", lctx.Str("name", config.Source.Name), lctx.Str("owner", config.Source.Owner), lctx.Bool("canPush", config.Source.CanPush))
+		return &token.Token{
+			Name:       config.Source.Name,
+			Type:       TypeDeployKey,
+			Owner:      config.Source.Owner,
+			Expiration: expire,
+			Value:      "dry-run",
+		}, nil
+	}
+
+	public, private, err := generateKeyPair(config.Source.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	b := g.backoff
+	key := &gitlab.ProjectDeployKey{}
+	resp := &gitlab.Response{}
+	err = retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		key, resp, err = g.client.DeployKeys.AddDeployKey(config.Source.Owner, &gitlab.AddDeployKeyOptions{
+			Title:     &config.Source.Name,
+			Key:       &public,
+			CanPush:   &config.Source.CanPush,
+			ExpiresAt: &expire,
+		}, gitlab.WithContext(g.ctx))
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create deploy key: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusCreated {
+		g.log.Error("failed to create deploy key", lctx.Str("name", config.Source.Name), lctx.Str("status", resp.Status))
+		return nil, ErrTokenCreationFailed
+	}
+	g.log.Debug("created deploy key", lctx.Str("name", key.Title), lctx.Int64("id", key.ID), lctx.Str("fingerprint", key.FingerprintSHA256), lctx.Str("owner", config.Source.Owner))
+	g.cache.invalidate(listDeployKey, config.Source.Owner)
+
+	return deployKey(key, config.Source.Owner, private), nil
+}
+
+// rotateDeployKey emulates rotation, as deploy keys can't be rotated:
+// a new key with the same title is registered and the previous one is deleted once the new one is stored.
+func (g *GitLab) rotateDeployKey(config *token.Config) (*token.Token, error) {
+	previous, err := g.getDeployKey(&config.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate token: %w", err)
+	}
+
+	tok, err := g.createDeployKey(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate token: %w", err)
+	}
+	tok.Replaces = previous
+
+	return tok, nil
+}
+
+func (g *GitLab) revokeDeployKey(source *token.Source, tok *token.Token) error {
+	id, err := strconv.ParseInt(tok.ID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid deploy key ID %s: %w", tok.ID, err)
+	}
+
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not deleting deploy key", lctx.Str("name", source.Name), lctx.Int64("id", id), lctx.Str("owner", source.Owner))
+		return nil
+	}
+
+	b := g.backoff
+	resp := &gitlab.Response{}
+	err = retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		resp, err = g.client.DeployKeys.DeleteDeployKey(source.Owner, id, gitlab.WithContext(g.ctx))
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete deploy key: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	g.cache.invalidate(listDeployKey, source.Owner)
+
+	if resp.StatusCode != http.StatusNoContent {
+		g.log.Error("failed to delete deploy key", lctx.Str("name", source.Name), lctx.Str("status", resp.Status))
+		return ErrTokenRevocationFailed
+	}
+	g.log.Debug("deleted deploy key", lctx.Str("name", source.Name), lctx.Int64("id", id), lctx.Str("owner", source.Owner))
+
+	return nil
+}
+
+func (g *GitLab) deleteDeployKey(source *token.Source) error {
+	tok, err := g.getDeployKey(source)
+	if err != nil {
+		return err
+	}
+
+	return g.revokeDeployKey(source, tok)
+}
+
+// deployKey converts a GitLab deploy key, the private key is only known when the key is created.
+// Deploy keys without expiration never expire.
+func deployKey(key *gitlab.ProjectDeployKey, owner, private string) *token.Token {
+	return &token.Token{
+		ID:         strconv.FormatInt(key.ID, 10),
+		Name:       key.Title,
+		Type:       TypeDeployKey,
+		Owner:      owner,
+		Value:      private,
+		Expiration: timeOrZero(key.ExpiresAt),
+		Created:    timeOrZero(key.CreatedAt),
+	}
+}
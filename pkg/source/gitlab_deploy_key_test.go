@@ -0,0 +1,158 @@
+package source
+
+import (
+	"crypto/ed25519"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/sickit/token-operator/pkg/token"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerateKeyPair(t *testing.T) {
+	public, private, err := generateKeyPair("deploy-key")
+
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(public, "ssh-ed25519 "))
+	assert.True(t, strings.HasSuffix(public, " deploy-key"))
+	assert.Contains(t, private, "BEGIN OPENSSH PRIVATE KEY")
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(public))
+	require.NoError(t, err)
+	key, err := ssh.ParseRawPrivateKey([]byte(private))
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	assert.IsType(t, &ed25519.PrivateKey{}, key)
+	assert.Equal(t, pub.Marshal(), signer.PublicKey().Marshal())
+}
+
+// serveDeployKeys serves the deploy keys of group/project, created keys are added to the listing.
+func serveDeployKeys(t *testing.T, mux *http.ServeMux, keys []map[string]any) *[]string {
+	t.Helper()
+
+	deleted := []string{}
+	mux.HandleFunc("GET /projects/{id}/deploy_keys", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "group/project", r.PathValue("id"))
+		writeJSON(w, http.StatusOK, keys)
+	})
+	mux.HandleFunc("POST /projects/{id}/deploy_keys", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "group/project", r.PathValue("id"))
+		body := map[string]any{}
+		decodeJSON(t, r, &body)
+		body["id"] = 10 + len(keys)
+		keys = append(keys, body)
+		writeJSON(w, http.StatusCreated, body)
+	})
+	mux.HandleFunc("DELETE /projects/{id}/deploy_keys/{key}", func(w http.ResponseWriter, r *http.Request) {
+		deleted = append(deleted, r.PathValue("key"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return &deleted
+}
+
+func TestGitLab_CreateDeployKey(t *testing.T) {
+	mux := http.NewServeMux()
+	serveUser(mux, false)
+	serveDeployKeys(t, mux, nil)
+	g, err := newTestGitLab(t, mux)
+	require.NoError(t, err)
+	config := &token.Config{
+		Name:     "deploy",
+		Rotation: &token.Rotation{Validity: 30 * 24 * time.Hour},
+		Source:   token.Source{Name: "deploy", Type: TypeDeployKey, Owner: "group/project", CanPush: true},
+	}
+
+	tok, err := g.CreateToken(config)
+	require.NoError(t, err)
+
+	assert.Equal(t, "10", tok.ID)
+	assert.Equal(t, "deploy", tok.Name)
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), tok.Expiration, 48*time.Hour)
+
+	created, err := g.GetToken(&config.Source)
+	require.NoError(t, err)
+	assert.Equal(t, "10", created.ID)
+	assert.Empty(t, created.Value, "the private key is only known on creation")
+
+	key, err := ssh.ParseRawPrivateKey([]byte(tok.Value))
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	keys, err := g.deployKeys("group/project")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(keys[0].Key))
+	require.NoError(t, err)
+	assert.Equal(t, signer.PublicKey().Marshal(), pub.Marshal(), "the public key of the private key is registered")
+	assert.True(t, keys[0].CanPush)
+}
+
+func TestGitLab_RotateDeployKey(t *testing.T) {
+	mux := http.NewServeMux()
+	serveUser(mux, false)
+	deleted := serveDeployKeys(t, mux, []map[string]any{
+		{"id": 5, "title": "deploy", "key": "ssh-ed25519 AAAA deploy"},
+		{"id": 6, "title": "other", "key": "ssh-ed25519 BBBB other"},
+	})
+	g, err := newTestGitLab(t, mux)
+	require.NoError(t, err)
+	config := &token.Config{
+		Name:     "deploy",
+		Rotation: &token.Rotation{Validity: 30 * 24 * time.Hour},
+		Source:   token.Source{Name: "deploy", Type: TypeDeployKey, Owner: "group/project"},
+	}
+
+	tok, err := g.RotateToken(config)
+	require.NoError(t, err)
+
+	assert.Equal(t, "12", tok.ID)
+	require.NotNil(t, tok.Replaces)
+	assert.Equal(t, "5", tok.Replaces.ID)
+	assert.Empty(t, *deleted, "the previous key is deleted once the new key is stored")
+
+	require.NoError(t, g.RevokeToken(&config.Source, tok.Replaces))
+	assert.Equal(t, []string{"5"}, *deleted)
+}
+
+func TestGitLab_DeleteDeployKey(t *testing.T) {
+	mux := http.NewServeMux()
+	serveUser(mux, false)
+	deleted := serveDeployKeys(t, mux, []map[string]any{
+		{"id": 5, "title": "deploy", "key": "ssh-ed25519 AAAA deploy"},
+		{"id": 6, "title": "other", "key": "ssh-ed25519 BBBB other"},
+	})
+	g, err := newTestGitLab(t, mux)
+	require.NoError(t, err)
+
+	err = g.DeleteToken(&token.Source{Name: "deploy", Type: TypeDeployKey, Owner: "group/project"})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"5"}, *deleted)
+}
+
+func TestGitLab_DeployKeySelection(t *testing.T) {
+	mux := http.NewServeMux()
+	serveUser(mux, false)
+	serveDeployKeys(t, mux, []map[string]any{
+		{"id": 5, "title": "deploy", "key": "ssh-ed25519 AAAA deploy"},
+		{"id": 7, "title": "deploy", "key": "ssh-ed25519 CCCC deploy"},
+		{"id": 8, "title": "deploy", "key": "ssh-ed25519 DDDD deploy", "expires_at": "2020-01-01T00:00:00Z"},
+	})
+	g, err := newTestGitLab(t, mux)
+	require.NoError(t, err)
+	source := token.Source{Name: "deploy", Type: TypeDeployKey, Owner: "group/project"}
+
+	_, err = g.GetToken(&source)
+	assert.ErrorIs(t, err, ErrAmbiguousToken)
+
+	source.ID = "7"
+	tok, err := g.GetToken(&source)
+	require.NoError(t, err)
+	assert.Equal(t, "7", tok.ID)
+}
@@ -0,0 +1,187 @@
+package source
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+// deployOwners are the owner types of deploy tokens with the path of their API.
+var deployOwners = []struct {
+	ownerType string
+	owner     string
+	prefix    string
+}{
+	{ownerType: "", owner: "group/project", prefix: "/projects/{id}/deploy_tokens"},
+	{ownerType: OwnerTypeGroup, owner: "group", prefix: "/groups/{id}/deploy_tokens"},
+}
+
+// serveDeployTokens serves the deploy tokens of an owner below prefix, created tokens are added to the listing.
+func serveDeployTokens(t *testing.T, mux *http.ServeMux, prefix, owner string, toks []map[string]any) *[]string {
+	t.Helper()
+
+	revoked := []string{}
+	mux.HandleFunc("GET "+prefix, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, owner, r.PathValue("id"))
+		writeJSON(w, http.StatusOK, toks)
+	})
+	mux.HandleFunc("POST "+prefix, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, owner, r.PathValue("id"))
+		body := map[string]any{}
+		decodeJSON(t, r, &body)
+		assert.NotEmpty(t, body["expires_at"])
+		id := 10 + len(toks)
+		tok := map[string]any{
+			"id":         id,
+			"name":       body["name"],
+			"scopes":     body["scopes"],
+			"username":   "gitlab+deploy-token-" + body["name"].(string),
+			"token":      "gldt-secret",
+			"expires_at": body["expires_at"],
+		}
+		toks = append(toks, tok)
+		writeJSON(w, http.StatusCreated, tok)
+	})
+	mux.HandleFunc("DELETE "+prefix+"/{token}", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, owner, r.PathValue("id"))
+		revoked = append(revoked, r.PathValue("token"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return &revoked
+}
+
+func deployConfig(ownerType, owner string) *token.Config {
+	return &token.Config{
+		Name:     "registry",
+		Rotation: &token.Rotation{Validity: 30 * 24 * time.Hour},
+		Source:   token.Source{Name: "registry", Type: TypeDeploy, Owner: owner, OwnerType: ownerType, Scopes: []string{"read_registry"}},
+	}
+}
+
+func TestGitLab_CreateDeployToken(t *testing.T) {
+	for _, owner := range deployOwners {
+		t.Run(owner.prefix, func(t *testing.T) {
+			mux := http.NewServeMux()
+			serveUser(mux, false)
+			serveDeployTokens(t, mux, owner.prefix, owner.owner, nil)
+			g, err := newTestGitLab(t, mux)
+			require.NoError(t, err)
+			config := deployConfig(owner.ownerType, owner.owner)
+
+			tok, err := g.CreateToken(config)
+			require.NoError(t, err)
+
+			assert.Equal(t, "10", tok.ID)
+			assert.Equal(t, owner.owner, tok.Owner)
+			assert.Equal(t, "gldt-secret", tok.Value)
+			assert.Equal(t, "gitlab+deploy-token-registry", tok.Username, "the username is stored with the token")
+			assert.Equal(t, []string{"read_registry"}, tok.Scopes)
+			assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), tok.Expiration, 48*time.Hour)
+
+			found, err := g.GetToken(&config.Source)
+			require.NoError(t, err)
+			assert.Equal(t, "10", found.ID, "the listing is refreshed after the creation")
+			assert.Equal(t, "gitlab+deploy-token-registry", found.Username)
+		})
+	}
+}
+
+func TestGitLab_FindDeployToken(t *testing.T) {
+	mux := http.NewServeMux()
+	serveUser(mux, false)
+	serveDeployTokens(t, mux, "/projects/{id}/deploy_tokens", "group/project", []map[string]any{
+		{"id": 3, "name": "registry", "username": "gitlab+deploy-token-3", "revoked": true},
+		{"id": 4, "name": "registry", "username": "gitlab+deploy-token-4"},
+		{"id": 5, "name": "other", "username": "gitlab+deploy-token-5"},
+	})
+	serveDeployTokens(t, mux, "/groups/{id}/deploy_tokens", "group/project", []map[string]any{
+		{"id": 7, "name": "registry", "username": "gitlab+deploy-token-7"},
+		{"id": 8, "name": "registry", "username": "gitlab+deploy-token-8"},
+	})
+	g, err := newTestGitLab(t, mux)
+	require.NoError(t, err)
+
+	tok, err := g.GetToken(&deployConfig("", "group/project").Source)
+	require.NoError(t, err)
+	assert.Equal(t, "4", tok.ID, "revoked tokens are skipped")
+	assert.Equal(t, "gitlab+deploy-token-4", tok.Username)
+
+	// the group of the same path has its own tokens
+	source := deployConfig(OwnerTypeGroup, "group/project").Source
+	_, err = g.GetToken(&source)
+	assert.ErrorIs(t, err, ErrAmbiguousToken)
+	source.ID = "8"
+	tok, err = g.GetToken(&source)
+	require.NoError(t, err)
+	assert.Equal(t, "8", tok.ID)
+
+	_, err = g.GetToken(&deployConfig("user", "group/project").Source)
+	assert.ErrorIs(t, err, ErrInvalidOwnerType)
+}
+
+func TestGitLab_DeployTokenInactive(t *testing.T) {
+	mux := http.NewServeMux()
+	serveUser(mux, false)
+	serveDeployTokens(t, mux, "/projects/{id}/deploy_tokens", "group/project", []map[string]any{
+		{"id": 3, "name": "registry", "revoked": true},
+		{"id": 4, "name": "registry", "expired": true},
+	})
+	g, err := newTestGitLab(t, mux)
+	require.NoError(t, err)
+
+	_, err = g.GetToken(&deployConfig("", "group/project").Source)
+
+	assert.ErrorIs(t, err, ErrTokenExpired)
+}
+
+func TestGitLab_RotateDeployToken(t *testing.T) {
+	for _, owner := range deployOwners {
+		t.Run(owner.prefix, func(t *testing.T) {
+			mux := http.NewServeMux()
+			serveUser(mux, false)
+			revoked := serveDeployTokens(t, mux, owner.prefix, owner.owner, []map[string]any{
+				{"id": 5, "name": "registry", "username": "gitlab+deploy-token-5"},
+			})
+			g, err := newTestGitLab(t, mux)
+			require.NoError(t, err)
+			config := deployConfig(owner.ownerType, owner.owner)
+
+			tok, err := g.RotateToken(config)
+			require.NoError(t, err)
+
+			assert.Equal(t, "11", tok.ID)
+			assert.Equal(t, "gitlab+deploy-token-registry", tok.Username, "the new token has a new username")
+			require.NotNil(t, tok.Replaces)
+			assert.Equal(t, "5", tok.Replaces.ID)
+			assert.Empty(t, *revoked, "the previous token is revoked once the new token is stored")
+
+			require.NoError(t, g.RevokeToken(&config.Source, tok.Replaces))
+			assert.Equal(t, []string{"5"}, *revoked)
+		})
+	}
+}
+
+func TestGitLab_DeleteDeployToken(t *testing.T) {
+	for _, owner := range deployOwners {
+		t.Run(owner.prefix, func(t *testing.T) {
+			mux := http.NewServeMux()
+			serveUser(mux, false)
+			revoked := serveDeployTokens(t, mux, owner.prefix, owner.owner, []map[string]any{
+				{"id": 5, "name": "registry"},
+				{"id": 6, "name": "other"},
+			})
+			g, err := newTestGitLab(t, mux)
+			require.NoError(t, err)
+
+			err = g.DeleteToken(&deployConfig(owner.ownerType, owner.owner).Source)
+
+			require.NoError(t, err)
+			assert.Equal(t, []string{"5"}, *revoked)
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	lctx "github.com/hamba/logger/v2/ctx"
+	"github.com/sethvargo/go-retry"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+func (g *GitLab) findImpersonationToken(source *token.Source) (*gitlab.ImpersonationToken, int64, error) {
+	if !g.admin {
+		g.log.Error("impersonation tokens are only supported as admin", lctx.Str("name", source.Name))
+		return nil, 0, ErrAdminRequired
+	}
+
+	uid, err := g.ownerID(source.Owner)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	toks, err := g.impersonationTokens(uid)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list impersonation tokens: %w", err)
+	}
+
+	candidates := []*gitlab.ImpersonationToken{}
+	var inactive *gitlab.ImpersonationToken
+	for _, tok := range toks {
+		if tok.Name != source.Name {
+			continue
+		}
+		if !tok.Active {
+			if inactive == nil || tok.ID > inactive.ID {
+				inactive = tok
+			}
+			continue
+		}
+		candidates = append(candidates, tok)
+	}
+	if len(candidates) == 0 && inactive != nil {
+		return nil, 0, inactiveError(source.Name, inactive.ID, inactive.Revoked)
+	}
+
+	gltoken, err := selectToken(source, candidates, func(tok *gitlab.ImpersonationToken) (int64, string) {
+		return tok.ID, fmt.Sprintf("expires %s", tok.ExpiresAt)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	g.log.Debug("matching impersonation token", lctx.Str("name", gltoken.Name), lctx.Int64("id", gltoken.ID), lctx.Int64("userID", uid))
+
+	return gltoken, uid, nil
+}
+
+// impersonationTokens lists all impersonation tokens of a user, once per run.
+func (g *GitLab) impersonationTokens(uid int64) ([]*gitlab.ImpersonationToken, error) {
+	return cachedList(g.cache, listKey(listImpersonation, uid, ""), func() ([]*gitlab.ImpersonationToken, error) {
+		opt := &gitlab.GetAllImpersonationTokensOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100, Page: 1},
+		}
+
+		all := []*gitlab.ImpersonationToken{}
+		for opt.Page != 0 {
+			b := g.backoff
+			toks := []*gitlab.ImpersonationToken{}
+			resp := &gitlab.Response{}
+			err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+				var err error
+				toks, resp, err = g.client.Users.GetAllImpersonationTokens(uid, opt, gitlab.WithContext(g.ctx))
+				if retryErr := g.isRetriable(resp, err); retryErr != nil {
+					return retryErr
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			all = append(all, toks...)
+			opt.Page = resp.NextPage
+		}
+
+		return all, nil
+	})
+}
+
+func (g *GitLab) getImpersonationToken(source *token.Source) (*token.Token, error) {
+	gltoken, uid, err := g.findImpersonationToken(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find impersonation token: %w", err)
+	}
+
+	return g.impersonationToken(gltoken, uid), nil
+}
+
+func (g *GitLab) createImpersonationToken(config *token.Config) (*token.Token, error) {
+	if !g.admin {
+		g.log.Error("impersonation tokens are only supported as admin", lctx.Str("name", config.Source.Name))
+		return nil, ErrAdminRequired
+	}
+
+	uid, err := g.ownerID(config.Source.Owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create impersonation token: %w", err)
+	}
+
+	expire := g.expiryDate(config.Rotation)
+
+	opt := &gitlab.CreateImpersonationTokenOptions{
+		Name:      &config.Source.Name,
+		Scopes:    &config.Source.Scopes,
+		ExpiresAt: &expire,
+	}
+
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not creating impersonation token for user", lctx.Str("name", config.Source.Name), lctx.Int64("userID", uid))
+		return &token.Token{
+			Name:       config.Source.Name,
+			Scopes:     config.Source.Scopes,
+			Type:       TypeImpersonation,
+			Owner:      strconv.FormatInt(uid, 10),
+			Expiration: token.EndOfDay(expire, g.loc()),
+			Value:      "dry-run",
+		}, nil
+	}
+
+	b := g.backoff
+	tok := &gitlab.ImpersonationToken{}
+	resp := &gitlab.Response{}
+	err = retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		tok, resp, err = g.client.Users.CreateImpersonationToken(uid, opt, gitlab.WithContext(g.ctx))
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create impersonation token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusCreated {
+		g.log.Error("failed to create impersonation token", lctx.Str("name", config.Source.Name), lctx.Str("status", resp.Status))
+		return nil, ErrTokenCreationFailed
+	}
+	g.log.Debug("created impersonation token", lctx.Str("name", tok.Name), lctx.Int64("id", tok.ID), lctx.Int64("userID", uid))
+	g.cache.invalidate(listImpersonation, uid)
+
+	return g.impersonationToken(tok, uid), nil
+}
+
+// rotateImpersonationToken emulates rotation, as impersonation tokens can't be rotated in place:
+// a new token with the same name is created and the previous one is revoked once the new one is stored.
+func (g *GitLab) rotateImpersonationToken(config *token.Config) (*token.Token, error) {
+	previous, err := g.getImpersonationToken(&config.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate token: %w", err)
+	}
+
+	tok, err := g.createImpersonationToken(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate token: %w", err)
+	}
+	tok.Replaces = previous
+
+	return tok, nil
+}
+
+func (g *GitLab) revokeImpersonationToken(source *token.Source, tok *token.Token) error {
+	id, err := strconv.ParseInt(tok.ID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid impersonation token ID %s: %w", tok.ID, err)
+	}
+	uid, err := strconv.ParseInt(tok.Owner, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid impersonation token owner %s: %w", tok.Owner, err)
+	}
+
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not revoking impersonation token for user", lctx.Str("name", source.Name), lctx.Int64("id", id), lctx.Int64("userID", uid))
+		return nil
+	}
+
+	b := g.backoff
+	resp := &gitlab.Response{}
+	err = retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		resp, err = g.client.Users.RevokeImpersonationToken(uid, id, gitlab.WithContext(g.ctx))
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke impersonation token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	g.cache.invalidate(listImpersonation, uid)
+
+	if resp.StatusCode != http.StatusNoContent {
+		g.log.Error("failed to revoke impersonation token", lctx.Str("name", source.Name), lctx.Str("status", resp.Status))
+		return ErrTokenRevocationFailed
+	}
+	g.log.Debug("revoked impersonation token", lctx.Str("name", source.Name), lctx.Int64("id", id), lctx.Int64("userID", uid))
+
+	return nil
+}
+
+func (g *GitLab) deleteImpersonationToken(source *token.Source) error {
+	tok, err := g.getImpersonationToken(source)
+	if err != nil {
+		return err
+	}
+
+	return g.revokeImpersonationToken(source, tok)
+}
+
+func (g *GitLab) impersonationToken(tok *gitlab.ImpersonationToken, uid int64) *token.Token {
+	return &token.Token{
+		ID:         strconv.FormatInt(tok.ID, 10),
+		Name:       tok.Name,
+		Scopes:     tok.Scopes,
+		Type:       TypeImpersonation,
+		Owner:      strconv.FormatInt(uid, 10),
+		Value:      tok.Token,
+		Expiration: g.expiration(tok.ExpiresAt),
+		Created:    timeOrZero(tok.CreatedAt),
+		LastUsed:   timeOrZero(tok.LastUsedAt),
+	}
+}
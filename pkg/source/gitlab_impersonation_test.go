@@ -0,0 +1,198 @@
+package source
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+// serveImpersonationTokens serves the impersonation tokens of user 42, created tokens are added to the listing.
+// Revocations are answered with revokeStatus and recorded.
+func serveImpersonationTokens(t *testing.T, mux *http.ServeMux, toks []map[string]any, revokeStatus int) *[]string {
+	t.Helper()
+
+	revoked := []string{}
+	mux.HandleFunc("GET /users/{uid}/impersonation_tokens", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "42", r.PathValue("uid"))
+		writeJSON(w, http.StatusOK, toks)
+	})
+	mux.HandleFunc("POST /users/{uid}/impersonation_tokens", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "42", r.PathValue("uid"))
+		body := map[string]any{}
+		decodeJSON(t, r, &body)
+		assert.NotEmpty(t, body["expires_at"])
+		tok := map[string]any{
+			"id":         10 + len(toks),
+			"name":       body["name"],
+			"scopes":     body["scopes"],
+			"active":     true,
+			"token":      "glpat-impersonation",
+			"expires_at": time.Now().Add(30 * 24 * time.Hour).Format(time.DateOnly),
+		}
+		toks = append(toks, tok)
+		writeJSON(w, http.StatusCreated, tok)
+	})
+	mux.HandleFunc("DELETE /users/{uid}/impersonation_tokens/{id}", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "42", r.PathValue("uid"))
+		revoked = append(revoked, r.PathValue("id"))
+		w.WriteHeader(revokeStatus)
+	})
+
+	return &revoked
+}
+
+func impersonationConfig() *token.Config {
+	return &token.Config{
+		Name:     "impersonation",
+		Rotation: &token.Rotation{Validity: 30 * 24 * time.Hour},
+		Source:   token.Source{Name: "bot", Type: TypeImpersonation, Owner: "42", Scopes: []string{"read_api"}},
+	}
+}
+
+func TestGitLab_ImpersonationTokenRequiresAdmin(t *testing.T) {
+	mux := http.NewServeMux()
+	serveUser(mux, false)
+	g, err := newTestGitLab(t, mux)
+	require.NoError(t, err)
+	config := impersonationConfig()
+
+	_, err = g.GetToken(&config.Source)
+	assert.ErrorIs(t, err, ErrAdminRequired)
+	_, err = g.CreateToken(config)
+	assert.ErrorIs(t, err, ErrAdminRequired)
+	_, err = g.RotateToken(config)
+	assert.ErrorIs(t, err, ErrAdminRequired)
+}
+
+func TestGitLab_CreateImpersonationToken(t *testing.T) {
+	mux := http.NewServeMux()
+	serveUser(mux, true)
+	serveImpersonationTokens(t, mux, nil, http.StatusNoContent)
+	g, err := newTestGitLab(t, mux)
+	require.NoError(t, err)
+	config := impersonationConfig()
+
+	tok, err := g.CreateToken(config)
+	require.NoError(t, err)
+
+	assert.Equal(t, "10", tok.ID)
+	assert.Equal(t, "bot", tok.Name)
+	assert.Equal(t, "42", tok.Owner)
+	assert.Equal(t, "glpat-impersonation", tok.Value)
+	assert.Equal(t, []string{"read_api"}, tok.Scopes)
+
+	created, err := g.GetToken(&config.Source)
+	require.NoError(t, err)
+	assert.Equal(t, "10", created.ID, "the listing is refreshed after the creation")
+}
+
+func TestGitLab_RotateImpersonationToken(t *testing.T) {
+	mux := http.NewServeMux()
+	serveUser(mux, true)
+	revoked := serveImpersonationTokens(t, mux, []map[string]any{
+		{"id": 5, "name": "bot", "active": true, "expires_at": "2030-01-01"},
+		{"id": 6, "name": "other", "active": true, "expires_at": "2030-01-01"},
+	}, http.StatusNoContent)
+	g, err := newTestGitLab(t, mux)
+	require.NoError(t, err)
+	config := impersonationConfig()
+
+	tok, err := g.RotateToken(config)
+	require.NoError(t, err)
+
+	assert.Equal(t, "12", tok.ID)
+	assert.Equal(t, "glpat-impersonation", tok.Value)
+	require.NotNil(t, tok.Replaces)
+	assert.Equal(t, "5", tok.Replaces.ID)
+	assert.Equal(t, "42", tok.Replaces.Owner)
+	assert.Empty(t, *revoked, "the previous token is revoked once the new token is stored")
+
+	require.NoError(t, g.RevokeToken(&config.Source, tok.Replaces))
+	assert.Equal(t, []string{"5"}, *revoked)
+}
+
+func TestGitLab_RevokeImpersonationToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		tok     token.Token
+		status  int
+		wantErr error
+		wantAny bool
+	}{
+		{name: "revoked", tok: token.Token{ID: "5", Owner: "42"}, status: http.StatusNoContent},
+		{name: "unexpected status", tok: token.Token{ID: "5", Owner: "42"}, status: http.StatusOK, wantErr: ErrTokenRevocationFailed},
+		{name: "invalid ID", tok: token.Token{ID: "bot", Owner: "42"}, status: http.StatusNoContent, wantAny: true},
+		{name: "invalid owner", tok: token.Token{ID: "5", Owner: "alice"}, status: http.StatusNoContent, wantAny: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			serveUser(mux, true)
+			revoked := serveImpersonationTokens(t, mux, nil, tt.status)
+			g, err := newTestGitLab(t, mux)
+			require.NoError(t, err)
+
+			err = g.RevokeToken(&impersonationConfig().Source, &tt.tok)
+
+			switch {
+			case tt.wantAny:
+				assert.Error(t, err)
+				assert.Empty(t, *revoked, "tokens with invalid ID or owner are not revoked")
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, []string{"5"}, *revoked)
+			}
+		})
+	}
+}
+
+func TestGitLab_ImpersonationTokenInactive(t *testing.T) {
+	tests := []struct {
+		name    string
+		toks    []map[string]any
+		wantErr error
+	}{
+		{
+			name: "expired",
+			toks: []map[string]any{
+				{"id": 3, "name": "bot", "active": false, "revoked": true},
+				{"id": 4, "name": "bot", "active": false, "expires_at": "2020-01-01"},
+			},
+			wantErr: ErrTokenExpired,
+		},
+		{
+			name: "revoked",
+			toks: []map[string]any{
+				{"id": 3, "name": "bot", "active": false, "expires_at": "2020-01-01"},
+				{"id": 4, "name": "bot", "active": false, "revoked": true},
+			},
+			wantErr: ErrTokenRevoked,
+		},
+		{
+			name:    "never existed",
+			toks:    []map[string]any{{"id": 3, "name": "other", "active": false, "revoked": true}},
+			wantErr: ErrTokenNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			serveUser(mux, true)
+			serveImpersonationTokens(t, mux, tt.toks, http.StatusNoContent)
+			g, err := newTestGitLab(t, mux)
+			require.NoError(t, err)
+
+			_, err = g.GetToken(&impersonationConfig().Source)
+
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	lctx "github.com/hamba/logger/v2/ctx"
+	"github.com/sethvargo/go-retry"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+// findApplication finds an instance-wide OAuth application by its name, or by its ID if pinned.
+func (g *GitLab) findApplication(source *token.Source) (*gitlab.Application, error) {
+	apps, err := g.applications()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list oauth applications: %w", err)
+	}
+
+	var glapp *gitlab.Application
+	for _, app := range apps {
+		if source.ID != "" {
+			if strconv.FormatInt(app.ID, 10) == source.ID {
+				glapp = app
+			}
+			continue
+		}
+		if app.ApplicationName != source.Name {
+			continue
+		}
+		if glapp != nil {
+			g.log.Error("found multiple oauth applications with the same name", lctx.Str("name", app.ApplicationName), lctx.Int64("id", app.ID), lctx.Int64("otherID", glapp.ID))
+			return nil, fmt.Errorf("%w '%s', set source.id to one of: %d, %d", ErrAmbiguousToken, source.Name, glapp.ID, app.ID)
+		}
+		glapp = app
+	}
+
+	if glapp == nil {
+		return nil, ErrTokenNotFound
+	}
+	g.log.Debug("matching oauth application", lctx.Str("name", glapp.ApplicationName), lctx.Int64("id", glapp.ID))
+
+	return glapp, nil
+}
+
+// applications lists all instance-wide OAuth applications, once per run.
+func (g *GitLab) applications() ([]*gitlab.Application, error) {
+	return cachedList(g.cache, listKey(listApplication, "", ""), func() ([]*gitlab.Application, error) {
+		all := []*gitlab.Application{}
+		page := int64(1)
+		for page != 0 {
+			b := g.backoff
+			opt := &gitlab.ListApplicationsOptions{ListOptions: gitlab.ListOptions{PerPage: 100, Page: page}}
+			apps := []*gitlab.Application{}
+			resp := &gitlab.Response{}
+			err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+				var err error
+				apps, resp, err = g.client.Applications.ListApplications(opt, gitlab.WithContext(g.ctx))
+				if retryErr := g.isRetriable(resp, err); retryErr != nil {
+					return retryErr
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			all = append(all, apps...)
+			page = resp.NextPage
+		}
+
+		return all, nil
+	})
+}
+
+// getApplicationSecret returns the OAuth application, its secret is only returned on renewal.
+// Secrets don't expire, their expiration is tracked in the status.
+func (g *GitLab) getApplicationSecret(source *token.Source) (*token.Token, error) {
+	glapp, err := g.findApplication(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find oauth application: %w", err)
+	}
+
+	return applicationSecret(glapp, time.Time{}), nil
+}
+
+// rotateApplicationSecret renews the secret of an OAuth application, the previous secret is invalid immediately.
+func (g *GitLab) rotateApplicationSecret(config *token.Config) (*token.Token, error) {
+	if !g.admin {
+		g.log.Error("oauth application secrets can only be renewed as admin", lctx.Str("name", config.Source.Name))
+		return nil, ErrAdminRequired
+	}
+
+	glapp, err := g.findApplication(&config.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate token: %w", err)
+	}
+
+	expires := time.Now().Add(config.Rotation.Lifetime())
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not renewing oauth application secret", lctx.Str("name", config.Source.Name), lctx.Int64("id", glapp.ID))
+		tok := applicationSecret(glapp, expires)
+		tok.Value = "dry-run"
+		return tok, nil
+	}
+
+	b := g.backoff
+	renewed := &gitlab.Application{}
+	resp := &gitlab.Response{}
+	err = retry.Do(g.ctx, b, func(ctx context.Context) error {
+		// the client has no method for the renew-secret endpoint yet
+		req, err := g.client.NewRequest(http.MethodPost, fmt.Sprintf("applications/%d/renew-secret", glapp.ID), nil, []gitlab.RequestOptionFunc{gitlab.WithContext(g.ctx)})
+		if err != nil {
+			return err
+		}
+		resp, err = g.client.Do(req, renewed)
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to renew oauth application secret: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK || renewed.Secret == "" {
+		g.log.Error("failed to renew oauth application secret", lctx.Str("name", config.Source.Name), lctx.Str("status", resp.Status))
+		return nil, ErrTokenRotationFailed
+	}
+	g.log.Debug("renewed oauth application secret", lctx.Str("name", config.Source.Name), lctx.Int64("id", glapp.ID))
+
+	return applicationSecret(renewed, expires), nil
+}
+
+// deleteApplication refuses deletion, the secret can't be revoked without deleting the application and all its grants.
+func (g *GitLab) deleteApplication(source *token.Source) error {
+	return fmt.Errorf("%w: %s, deleting it would remove the application '%s'", ErrDeletionUnsupported, TypeOAuthApplication, source.Name)
+}
+
+// applicationSecret converts an OAuth application, the client ID is stored as username.
+func applicationSecret(app *gitlab.Application, expires time.Time) *token.Token {
+	return &token.Token{
+		ID:         strconv.FormatInt(app.ID, 10),
+		Name:       app.ApplicationName,
+		Type:       TypeOAuthApplication,
+		Username:   app.ApplicationID,
+		Value:      app.Secret,
+		Expiration: expires,
+	}
+}
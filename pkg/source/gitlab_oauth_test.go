@@ -0,0 +1,133 @@
+package source
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+func TestGitLab_ApplicationSecretRefusesDeletion(t *testing.T) {
+	mux := http.NewServeMux()
+	serveUser(mux, true)
+	mux.HandleFunc("DELETE /applications/", func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("application must not be deleted")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	g, err := newTestGitLab(t, mux)
+	require.NoError(t, err)
+
+	err = g.DeleteToken(&token.Source{Name: "grafana", Type: TypeOAuthApplication})
+
+	assert.ErrorIs(t, err, ErrDeletionUnsupported)
+}
+
+// serveApplications serves the oauth applications, renewals are answered with status and secret.
+func serveApplications(t *testing.T, mux *http.ServeMux, status int, secret string) *[]string {
+	t.Helper()
+
+	renewed := []string{}
+	mux.HandleFunc("GET /applications", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, []map[string]any{
+			{"id": 3, "application_id": "client-3", "application_name": "grafana"},
+			{"id": 4, "application_id": "client-4", "application_name": "argocd"},
+			{"id": 5, "application_id": "client-5", "application_name": "argocd"},
+		})
+	})
+	mux.HandleFunc("POST /applications/{id}/renew-secret", func(w http.ResponseWriter, r *http.Request) {
+		renewed = append(renewed, r.PathValue("id"))
+		writeJSON(w, status, map[string]any{
+			"id":               3,
+			"application_id":   "client-3",
+			"application_name": "grafana",
+			"secret":           secret,
+		})
+	})
+
+	return &renewed
+}
+
+func TestGitLab_RotateApplicationSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		admin   bool
+		status  int
+		secret  string
+		wantErr error
+	}{
+		{name: "created", admin: true, status: http.StatusCreated, secret: "gloas-new"},
+		{name: "ok", admin: true, status: http.StatusOK, secret: "gloas-new"},
+		{name: "no secret", admin: true, status: http.StatusCreated, wantErr: ErrTokenRotationFailed},
+		{name: "unexpected status", admin: true, status: http.StatusAccepted, secret: "gloas-new", wantErr: ErrTokenRotationFailed},
+		{name: "forbidden", admin: true, status: http.StatusForbidden, wantErr: ErrForbidden},
+		{name: "no admin", status: http.StatusCreated, secret: "gloas-new", wantErr: ErrAdminRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			serveUser(mux, tt.admin)
+			renewed := serveApplications(t, mux, tt.status, tt.secret)
+			g, err := newTestGitLab(t, mux)
+			require.NoError(t, err)
+			config := &token.Config{
+				Name:     "grafana",
+				Rotation: &token.Rotation{Validity: 30 * 24 * time.Hour},
+				Source:   token.Source{Name: "grafana", Type: TypeOAuthApplication},
+			}
+
+			tok, err := g.RotateToken(config)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, []string{"3"}, *renewed)
+			assert.Equal(t, "3", tok.ID)
+			assert.Equal(t, "gloas-new", tok.Value)
+			assert.Equal(t, "client-3", tok.Username, "the client ID is stored as username")
+			assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), tok.Expiration, time.Minute)
+		})
+	}
+}
+
+func TestGitLab_FindApplication(t *testing.T) {
+	mux := http.NewServeMux()
+	serveUser(mux, true)
+	serveApplications(t, mux, http.StatusCreated, "gloas-new")
+	g, err := newTestGitLab(t, mux)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		source  token.Source
+		wantID  string
+		wantErr error
+	}{
+		{name: "single match", source: token.Source{Name: "grafana"}, wantID: "3"},
+		{name: "ambiguous", source: token.Source{Name: "argocd"}, wantErr: ErrAmbiguousToken},
+		{name: "pinned", source: token.Source{Name: "argocd", ID: "5"}, wantID: "5"},
+		{name: "stale pin", source: token.Source{Name: "argocd", ID: "6"}, wantErr: ErrTokenNotFound},
+		{name: "no match", source: token.Source{Name: "vault"}, wantErr: ErrTokenNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.source.Type = TypeOAuthApplication
+
+			tok, err := g.GetToken(&tt.source)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantID, tok.ID)
+			assert.Empty(t, tok.Value, "the secret is only returned on renewal")
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	lctx "github.com/hamba/logger/v2/ctx"
+	"github.com/sethvargo/go-retry"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+// DefaultCredentialWarning is how long before its expiry a warning is logged for the source token, unless configured otherwise.
+const DefaultCredentialWarning = 30 * 24 * time.Hour
+
+// ScopeAPI is the scope the source token needs to manage tokens.
+const ScopeAPI = "api"
+
+// Credential describes the token of the source.
+type Credential struct {
+	ID     int64
+	Name   string
+	Scopes []string
+	// ExpiresAt is zero if the token never expires.
+	ExpiresAt time.Time
+}
+
+// Credential introspects the token of the source.
+func (g *GitLab) Credential() (*Credential, error) {
+	b := g.backoff
+	pat := &gitlab.PersonalAccessToken{}
+	resp := &gitlab.Response{}
+	err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		pat, resp, err = g.client.PersonalAccessTokens.GetSinglePersonalAccessToken(gitlab.WithContext(g.ctx))
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get source token: %w", err)
+	}
+
+	return &Credential{ID: pat.ID, Name: pat.Name, Scopes: pat.Scopes, ExpiresAt: g.expiration(pat.ExpiresAt)}, nil
+}
+
+// Preflight checks the source token covers the configured tokens before any of them is changed.
+// The api scope is required, admin rights are required for impersonation tokens, oauth applications, instance service accounts,
+// tokens of other users and to create personal tokens.
+func (g *GitLab) Preflight(configs []token.Config) error {
+	cred, err := g.Credential()
+	if err != nil {
+		// OAuth and job tokens can't be introspected, their permissions are checked on use
+		if errors.Is(err, ErrNotFound) {
+			g.log.Warn("source token can't be introspected, skipping preflight", lctx.Err(err))
+			return nil
+		}
+		return annotate("preflight", "", err)
+	}
+
+	if err = g.preflight(cred, configs, time.Now()); err != nil {
+		return annotate("preflight", cred.Name, err)
+	}
+	return annotate("preflight", cred.Name, g.preflightCreation(configs))
+}
+
+// preflightCreation checks the personal tokens of the source user exist if the source token is no admin,
+// as only admins can create personal tokens, e.g. to replace an expired token or to overlap a rotation.
+func (g *GitLab) preflightCreation(configs []token.Config) error {
+	if g.admin {
+		return nil
+	}
+
+	errs := []error{}
+	for _, cfg := range configs {
+		if cfg.State != token.TokenStateActive || cfg.Source.Type != TypePersonal || !g.isCurrentUser(cfg.Source.Owner) {
+			continue
+		}
+		if cfg.Rotation != nil && cfg.Rotation.Strategy == token.RotationStrategyOverlap {
+			errs = append(errs, fmt.Errorf("%w: token %s overlaps rotations, which creates personal tokens", ErrAdminRequired, cfg.Name))
+			continue
+		}
+
+		_, err := g.findPersonalToken(&cfg.Source)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrTokenNotFound):
+			errs = append(errs, fmt.Errorf("%w: token %s is missing and can't be created", ErrAdminRequired, cfg.Name))
+		case errors.Is(err, ErrTokenExpired), errors.Is(err, ErrTokenRevoked):
+			// without recovery the token isn't replaced
+			if cfg.Recovery != token.RecoveryPolicyFail {
+				errs = append(errs, fmt.Errorf("%w: token %s can't be replaced: %w", ErrAdminRequired, cfg.Name, err))
+			}
+		default:
+			return err
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (g *GitLab) preflight(cred *Credential, configs []token.Config, now time.Time) error {
+	g.log.Debug("source token",
+		lctx.Str("name", cred.Name),
+		lctx.Strs("scopes", cred.Scopes),
+		lctx.Time("expiresAt", cred.ExpiresAt),
+		lctx.Bool("admin", g.admin),
+	)
+
+	if !slices.Contains(cred.Scopes, ScopeAPI) {
+		return fmt.Errorf("%w: %s, has %v", ErrInsufficientScope, ScopeAPI, cred.Scopes)
+	}
+
+	errs := []error{}
+	rotated := false
+	for _, cfg := range configs {
+		if cfg.State == token.TokenStateInactive {
+			continue
+		}
+		if g.isSourceToken(cred, &cfg.Source) {
+			rotated = true
+		}
+
+		switch cfg.Source.Type {
+		case TypeImpersonation, TypeOAuthApplication:
+			if !g.admin {
+				errs = append(errs, fmt.Errorf("%w: token %s", ErrAdminRequired, cfg.Name))
+			}
+		case TypePersonal:
+			if !g.admin && !g.isCurrentUser(cfg.Source.Owner) {
+				errs = append(errs, fmt.Errorf("%w: token %s of user %s", ErrAdminRequired, cfg.Name, cfg.Source.Owner))
+			}
+		case TypeServiceAccount:
+			if !g.admin && cfg.Source.Group == "" {
+				errs = append(errs, fmt.Errorf("%w: token %s of instance service account %s", ErrAdminRequired, cfg.Name, cfg.Source.Owner))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	// a self-rotating source token is renewed like any other token
+	if !cred.ExpiresAt.IsZero() && !rotated && cred.ExpiresAt.Before(now.Add(g.credentialWarning())) {
+		g.log.Warn("source token expires soon and is not rotated by this configuration",
+			lctx.Str("name", cred.Name),
+			lctx.Str("expireDate", cred.ExpiresAt.String()),
+			lctx.Duration("expireDuration", cred.ExpiresAt.Sub(now)),
+		)
+	}
+
+	return nil
+}
+
+func (g *GitLab) credentialWarning() time.Duration {
+	if g.expiryWarning == 0 {
+		return DefaultCredentialWarning
+	}
+	return g.expiryWarning
+}
+
+// isCurrentUser returns true if the owner, given as username or ID, is the user of the source token.
+// No owner is the current user if the source token can't read its user.
+func (g *GitLab) isCurrentUser(owner string) bool {
+	if g.user == nil {
+		return false
+	}
+	return owner == "" || owner == g.user.Username || owner == strconv.FormatInt(g.user.ID, 10)
+}
+
+// isSourceToken returns true if a configured token is the source token itself.
+func (g *GitLab) isSourceToken(cred *Credential, source *token.Source) bool {
+	if source.Type != TypePersonal || !g.isCurrentUser(source.Owner) {
+		return false
+	}
+	if source.ID != "" {
+		return source.ID == strconv.FormatInt(cred.ID, 10)
+	}
+	return source.Name == cred.Name
+}
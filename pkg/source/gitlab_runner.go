@@ -0,0 +1,169 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	lctx "github.com/hamba/logger/v2/ctx"
+	"github.com/sethvargo/go-retry"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+// findRunner finds a runner by its description, in the owner project or group if set,
+// otherwise in the runners available to the user of the source token.
+func (g *GitLab) findRunner(source *token.Source) (*gitlab.Runner, error) {
+	group, err := isGroupOwner(source)
+	if err != nil {
+		return nil, err
+	}
+
+	runners, err := g.runners(source.Owner, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runners: %w", err)
+	}
+
+	// a runner may be listed more than once, e.g. if it is assigned to several projects
+	candidates := []*gitlab.Runner{}
+	seen := map[int64]bool{}
+	for _, runner := range runners {
+		if runner.Description == source.Name && !seen[runner.ID] {
+			seen[runner.ID] = true
+			candidates = append(candidates, runner)
+		}
+	}
+
+	glrunner, err := selectToken(source, candidates, func(runner *gitlab.Runner) (int64, string) {
+		return runner.ID, runner.RunnerType
+	})
+	if err != nil {
+		return nil, err
+	}
+	g.log.Debug("matching runner", lctx.Str("name", glrunner.Description), lctx.Int64("id", glrunner.ID), lctx.Str("owner", source.Owner))
+
+	return glrunner, nil
+}
+
+// runners lists the runners of a project or group, or the runners available to the user without owner, once per run.
+func (g *GitLab) runners(owner string, group bool) ([]*gitlab.Runner, error) {
+	return cachedList(g.cache, listKey(listRunner, owner, strconv.FormatBool(group)), func() ([]*gitlab.Runner, error) {
+		all := []*gitlab.Runner{}
+		page := int64(1)
+		for page != 0 {
+			b := g.backoff
+			opt := gitlab.ListOptions{PerPage: 100, Page: page}
+			runners := []*gitlab.Runner{}
+			resp := &gitlab.Response{}
+			err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+				var err error
+				switch {
+				case owner == "":
+					runners, resp, err = g.client.Runners.ListRunners(&gitlab.ListRunnersOptions{ListOptions: opt}, gitlab.WithContext(g.ctx))
+				case group:
+					runners, resp, err = g.client.Runners.ListGroupsRunners(owner, &gitlab.ListGroupsRunnersOptions{ListOptions: opt}, gitlab.WithContext(g.ctx))
+				default:
+					runners, resp, err = g.client.Runners.ListProjectRunners(owner, &gitlab.ListProjectRunnersOptions{ListOptions: opt}, gitlab.WithContext(g.ctx))
+				}
+				if retryErr := g.isRetriable(resp, err); retryErr != nil {
+					return retryErr
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			all = append(all, runners...)
+			page = resp.NextPage
+		}
+
+		return all, nil
+	})
+}
+
+func (g *GitLab) getRunnerToken(source *token.Source) (*token.Token, error) {
+	glrunner, err := g.findRunner(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find runner: %w", err)
+	}
+
+	expires := time.Time{}
+	if glrunner.TokenExpiresAt != nil {
+		expires = *glrunner.TokenExpiresAt
+	}
+
+	return &token.Token{
+		ID:         strconv.FormatInt(glrunner.ID, 10),
+		Name:       glrunner.Description,
+		Type:       TypeRunner,
+		Owner:      source.Owner,
+		Expiration: expires,
+	}, nil
+}
+
+// rotateRunnerToken resets the authentication token of a runner, the previous token is invalid immediately.
+func (g *GitLab) rotateRunnerToken(config *token.Config) (*token.Token, error) {
+	glrunner, err := g.findRunner(&config.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate token: %w", err)
+	}
+
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not resetting runner authentication token", lctx.Str("name", config.Source.Name), lctx.Int64("id", glrunner.ID))
+		return &token.Token{
+			ID:         strconv.FormatInt(glrunner.ID, 10),
+			Name:       config.Source.Name,
+			Type:       TypeRunner,
+			Owner:      config.Source.Owner,
+			Expiration: time.Now().Add(config.Rotation.Lifetime()),
+			Value:      "dry-run",
+		}, nil
+	}
+
+	b := g.backoff
+	auth := &gitlab.RunnerAuthenticationToken{}
+	resp := &gitlab.Response{}
+	err = retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		auth, resp, err = g.client.Runners.ResetRunnerAuthenticationToken(glrunner.ID, gitlab.WithContext(g.ctx))
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reset runner authentication token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusCreated || auth.Token == nil {
+		g.log.Error("failed to reset runner authentication token", lctx.Str("name", config.Source.Name), lctx.Str("status", resp.Status))
+		return nil, ErrTokenRotationFailed
+	}
+	g.log.Debug("reset runner authentication token", lctx.Str("name", config.Source.Name), lctx.Int64("id", glrunner.ID))
+	// a runner is listed for the user and for its projects and groups
+	g.cache.invalidate(listRunner, nil)
+
+	// without runner_token_expiration_interval the token never expires, its expiry is tracked in the status store
+	expires := time.Now().Add(config.Rotation.Lifetime())
+	if auth.TokenExpiresAt != nil {
+		expires = *auth.TokenExpiresAt
+	}
+
+	return &token.Token{
+		ID:         strconv.FormatInt(glrunner.ID, 10),
+		Name:       glrunner.Description,
+		Type:       TypeRunner,
+		Owner:      config.Source.Owner,
+		Value:      *auth.Token,
+		Expiration: expires,
+	}, nil
+}
+
+// deleteRunner refuses deletion, the authentication token can't be revoked without removing the whole runner.
+func (g *GitLab) deleteRunner(source *token.Source) error {
+	return fmt.Errorf("%w: %s, deleting it would remove the runner '%s'", ErrDeletionUnsupported, TypeRunner, source.Name)
+}
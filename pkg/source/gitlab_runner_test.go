@@ -0,0 +1,101 @@
+package source
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+func TestGitLab_RunnerToken(t *testing.T) {
+	expiresAt := time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		expiresAt *time.Time
+		want      func(now time.Time) time.Time
+	}{
+		{
+			name: "token without expiration",
+			want: func(now time.Time) time.Time { return now.Add(30 * 24 * time.Hour) },
+		},
+		{
+			name:      "token with expiration",
+			expiresAt: &expiresAt,
+			want:      func(time.Time) time.Time { return expiresAt },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resets := 0
+			mux := http.NewServeMux()
+			serveUser(mux, false)
+			mux.HandleFunc("GET /runners", func(w http.ResponseWriter, _ *http.Request) {
+				// runner listings have no token expiry
+				writeJSON(w, http.StatusOK, []map[string]any{
+					{"id": 5, "description": "build"},
+					{"id": 6, "description": "deploy"},
+				})
+			})
+			mux.HandleFunc("POST /runners/5/reset_authentication_token", func(w http.ResponseWriter, _ *http.Request) {
+				resets++
+				writeJSON(w, http.StatusCreated, map[string]any{"token": "glrt-new", "token_expires_at": tt.expiresAt})
+			})
+			g, err := newTestGitLab(t, mux)
+			require.NoError(t, err)
+			config := &token.Config{Name: "build", Rotation: &token.Rotation{Validity: 30 * 24 * time.Hour}, Source: token.Source{Name: "build", Type: TypeRunner}}
+
+			assert.False(t, g.ReportsExpiry(&config.Source), "runner expiry is tracked in the status store")
+
+			tok, err := g.GetToken(&config.Source)
+			require.NoError(t, err)
+			assert.Equal(t, "5", tok.ID)
+			assert.True(t, tok.Expiration.IsZero())
+
+			tok, err = g.RotateToken(config)
+			require.NoError(t, err)
+			assert.Equal(t, 1, resets)
+			assert.Equal(t, "glrt-new", tok.Value)
+			assert.WithinDuration(t, tt.want(time.Now()), tok.Expiration, time.Minute)
+		})
+	}
+}
+
+func TestGitLab_RunnerTokenAmbiguous(t *testing.T) {
+	mux := http.NewServeMux()
+	serveUser(mux, false)
+	mux.HandleFunc("GET /runners", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, []map[string]any{
+			{"id": 5, "description": "build"},
+			{"id": 7, "description": "build"},
+		})
+	})
+	g, err := newTestGitLab(t, mux)
+	require.NoError(t, err)
+
+	_, err = g.GetToken(&token.Source{Name: "build", Type: TypeRunner})
+	assert.ErrorIs(t, err, ErrAmbiguousToken)
+
+	tok, err := g.GetToken(&token.Source{Name: "build", Type: TypeRunner, LastID: "7"})
+	require.NoError(t, err)
+	assert.Equal(t, "7", tok.ID)
+}
+
+func TestGitLab_RunnerTokenRefusesDeletion(t *testing.T) {
+	mux := http.NewServeMux()
+	serveUser(mux, true)
+	mux.HandleFunc("DELETE /runners/5", func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("runner must not be removed")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	g, err := newTestGitLab(t, mux)
+	require.NoError(t, err)
+
+	err = g.DeleteToken(&token.Source{Name: "build", Type: TypeRunner})
+
+	assert.ErrorIs(t, err, ErrDeletionUnsupported)
+}
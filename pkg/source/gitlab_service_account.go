@@ -0,0 +1,443 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	lctx "github.com/hamba/logger/v2/ctx"
+	"github.com/sethvargo/go-retry"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+// serviceAccount is a service account of the instance, or of a group if group is set.
+type serviceAccount struct {
+	id       int64
+	username string
+	group    string
+}
+
+// owner returns the owner of its tokens, the user ID of the service account.
+func (s *serviceAccount) owner() string {
+	return strconv.FormatInt(s.id, 10)
+}
+
+// findServiceAccount finds the service account named by the token owner, in the token group if set.
+func (g *GitLab) findServiceAccount(source *token.Source) (*serviceAccount, error) {
+	if source.Group == "" && !g.admin {
+		g.log.Error("instance service accounts are only supported as admin", lctx.Str("name", source.Name))
+		return nil, ErrAdminRequired
+	}
+
+	accounts, err := g.serviceAccounts(source.Group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service accounts: %w", err)
+	}
+
+	for _, account := range accounts {
+		if account.username == source.Owner {
+			g.log.Debug("matching service account", lctx.Str("username", account.username), lctx.Int64("id", account.id), lctx.Str("group", account.group))
+			return account, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: service account %s", ErrUserNotFound, source.Owner)
+}
+
+// serviceAccounts lists all service accounts of a group, or of the instance without group, once per run.
+func (g *GitLab) serviceAccounts(group string) ([]*serviceAccount, error) {
+	return cachedList(g.cache, listKey(listServiceAccount, group, ""), func() ([]*serviceAccount, error) {
+		opt := &gitlab.ListServiceAccountsOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100, Page: 1},
+		}
+
+		all := []*serviceAccount{}
+		for opt.Page != 0 {
+			b := g.backoff
+			accounts := []*serviceAccount{}
+			resp := &gitlab.Response{}
+			err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+				var err error
+				accounts = []*serviceAccount{}
+				if group == "" {
+					var sas []*gitlab.ServiceAccount
+					sas, resp, err = g.client.Users.ListServiceAccounts(opt, gitlab.WithContext(g.ctx))
+					for _, sa := range sas {
+						accounts = append(accounts, &serviceAccount{id: sa.ID, username: sa.Username})
+					}
+				} else {
+					var sas []*gitlab.GroupServiceAccount
+					sas, resp, err = g.client.Groups.ListServiceAccounts(group, opt, gitlab.WithContext(g.ctx))
+					for _, sa := range sas {
+						accounts = append(accounts, &serviceAccount{id: sa.ID, username: sa.UserName, group: group})
+					}
+				}
+				if retryErr := g.isRetriable(resp, err); retryErr != nil {
+					return retryErr
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			all = append(all, accounts...)
+			opt.Page = resp.NextPage
+		}
+
+		return all, nil
+	})
+}
+
+// createServiceAccount creates the service account named by the token owner, in the token group if set.
+func (g *GitLab) createServiceAccount(source *token.Source) (*serviceAccount, error) {
+	b := g.backoff
+	account := &serviceAccount{username: source.Owner, group: source.Group}
+	resp := &gitlab.Response{}
+	err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		if source.Group == "" {
+			var usr *gitlab.User
+			usr, resp, err = g.client.Users.CreateServiceAccountUser(&gitlab.CreateServiceAccountUserOptions{
+				Name:     &source.Owner,
+				Username: &source.Owner,
+			}, gitlab.WithContext(g.ctx))
+			if usr != nil {
+				account.id = usr.ID
+			}
+		} else {
+			var sa *gitlab.GroupServiceAccount
+			sa, resp, err = g.client.Groups.CreateServiceAccount(source.Group, &gitlab.CreateServiceAccountOptions{
+				Name:     &source.Owner,
+				Username: &source.Owner,
+			}, gitlab.WithContext(g.ctx))
+			if sa != nil {
+				account.id = sa.ID
+			}
+		}
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create service account %s: %w", source.Owner, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusCreated {
+		g.log.Error("failed to create service account", lctx.Str("username", source.Owner), lctx.Str("status", resp.Status))
+		return nil, ErrTokenCreationFailed
+	}
+	g.log.Info("created service account", lctx.Str("username", account.username), lctx.Int64("id", account.id), lctx.Str("group", account.group))
+	g.cache.invalidate(listServiceAccount, source.Group)
+
+	return account, nil
+}
+
+// serviceAccountTokens lists the tokens of a service account in a state, once per run.
+// Tokens of instance service accounts are personal tokens of the service account user.
+func (g *GitLab) serviceAccountTokens(account *serviceAccount, state gitlab.AccessTokenState) ([]*personalAccessToken, error) {
+	if account.group == "" {
+		return g.personalTokens(account.id, state)
+	}
+
+	return cachedList(g.cache, listKey(listServiceAccountToken, account.id, string(state)), func() ([]*personalAccessToken, error) {
+		opt := &gitlab.ListServiceAccountPersonalAccessTokensOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100, Page: 1},
+			State:       gitlab.Ptr(string(state)),
+		}
+
+		all := []*personalAccessToken{}
+		for opt.Page != 0 {
+			b := g.backoff
+			toks := []*gitlab.PersonalAccessToken{}
+			resp := &gitlab.Response{}
+			err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+				var err error
+				toks, resp, err = g.client.Groups.ListServiceAccountPersonalAccessTokens(account.group, account.id, opt, gitlab.WithContext(g.ctx))
+				if retryErr := g.isRetriable(resp, err); retryErr != nil {
+					return retryErr
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			for _, tok := range toks {
+				all = append(all, &personalAccessToken{PersonalAccessToken: *tok})
+			}
+			opt.Page = resp.NextPage
+		}
+
+		return all, nil
+	})
+}
+
+// invalidateServiceAccountTokens drops the token listings of a service account after a change.
+func (g *GitLab) invalidateServiceAccountTokens(account *serviceAccount) {
+	if account.group == "" {
+		g.cache.invalidate(listPersonal, account.id)
+		return
+	}
+	g.cache.invalidate(listServiceAccountToken, account.id)
+}
+
+func (g *GitLab) findServiceAccountToken(source *token.Source) (*personalAccessToken, *serviceAccount, error) {
+	account, err := g.findServiceAccount(source)
+	if err != nil {
+		// a missing service account is created with its token
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, nil, fmt.Errorf("%w: service account %s is missing", ErrTokenNotFound, source.Owner)
+		}
+		return nil, nil, err
+	}
+
+	toks, err := g.serviceAccountTokens(account, gitlab.AccessTokenStateActive)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list service account tokens: %w", err)
+	}
+
+	candidates := []*personalAccessToken{}
+	for _, tok := range toks {
+		if tok.Name == source.Name {
+			candidates = append(candidates, tok)
+		}
+	}
+
+	if len(candidates) == 0 {
+		inactive, err := g.serviceAccountTokens(account, gitlab.AccessTokenStateInactive)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list inactive service account tokens: %w", err)
+		}
+
+		var newest *personalAccessToken
+		for _, tok := range inactive {
+			if tok.Name == source.Name && (newest == nil || tok.ID > newest.ID) {
+				newest = tok
+			}
+		}
+		if newest == nil {
+			return nil, nil, ErrTokenNotFound
+		}
+		return nil, nil, inactiveError(source.Name, newest.ID, newest.Revoked)
+	}
+
+	gltoken, err := selectPersonalToken(source, candidates)
+	if err != nil {
+		return nil, nil, err
+	}
+	g.log.Debug("matching service account token", lctx.Str("name", gltoken.Name), lctx.Int64("id", gltoken.ID), lctx.Int64("userID", account.id))
+
+	return gltoken, account, nil
+}
+
+func (g *GitLab) getServiceAccountToken(source *token.Source) (*token.Token, error) {
+	gltoken, account, err := g.findServiceAccountToken(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find service account token: %w", err)
+	}
+
+	tok := g.serviceAccountToken(&gltoken.PersonalAccessToken, account)
+	tok.LastUsedIPs = gltoken.LastUsedIPs
+
+	return tok, nil
+}
+
+// createServiceAccountToken creates a token of the service account, the service account is created if it is missing.
+func (g *GitLab) createServiceAccountToken(config *token.Config) (*token.Token, error) {
+	account, err := g.findServiceAccount(&config.Source)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return nil, fmt.Errorf("failed to create service account token: %w", err)
+	}
+
+	expireISO := gitlab.ISOTime(g.expiryDate(config.Rotation))
+
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not creating service account token", lctx.Str("name", config.Source.Name), lctx.Str("username", config.Source.Owner), lctx.Bool("accountExists", account != nil))
+		return &token.Token{
+			Name:        config.Source.Name,
+			Description: config.Source.Description,
+			Scopes:      config.Source.Scopes,
+			Type:        TypeServiceAccount,
+			Owner:       config.Source.Owner,
+			Expiration:  g.expiration(&expireISO),
+			Value:       "dry-run",
+		}, nil
+	}
+
+	if account == nil {
+		account, err = g.createServiceAccount(&config.Source)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b := g.backoff
+	tok := &gitlab.PersonalAccessToken{}
+	resp := &gitlab.Response{}
+	err = retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		if account.group == "" {
+			tok, resp, err = g.client.Users.CreatePersonalAccessToken(account.id, &gitlab.CreatePersonalAccessTokenOptions{
+				Name:        &config.Source.Name,
+				Description: &config.Source.Description,
+				Scopes:      &config.Source.Scopes,
+				ExpiresAt:   &expireISO,
+			}, gitlab.WithContext(g.ctx))
+		} else {
+			tok, resp, err = g.client.Groups.CreateServiceAccountPersonalAccessToken(account.group, account.id, &gitlab.CreateServiceAccountPersonalAccessTokenOptions{
+				Name:        &config.Source.Name,
+				Description: &config.Source.Description,
+				Scopes:      &config.Source.Scopes,
+				ExpiresAt:   &expireISO,
+			}, gitlab.WithContext(g.ctx))
+		}
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create service account token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusCreated {
+		g.log.Error("failed to create service account token", lctx.Str("name", config.Source.Name), lctx.Str("status", resp.Status))
+		return nil, ErrTokenCreationFailed
+	}
+	g.log.Debug("created service account token", lctx.Str("name", tok.Name), lctx.Int64("id", tok.ID), lctx.Int64("userID", account.id))
+	g.invalidateServiceAccountTokens(account)
+
+	return g.serviceAccountToken(tok, account), nil
+}
+
+func (g *GitLab) rotateServiceAccountToken(config *token.Config) (*token.Token, error) {
+	gltoken, account, err := g.findServiceAccountToken(&config.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate token: %w", err)
+	}
+
+	expireISO := gitlab.ISOTime(g.expiryDate(config.Rotation))
+
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not rotating service account token", lctx.Str("name", config.Source.Name), lctx.Int64("userID", account.id))
+		return &token.Token{
+			Name:        config.Source.Name,
+			Description: config.Source.Description,
+			Scopes:      config.Source.Scopes,
+			Type:        TypeServiceAccount,
+			Owner:       account.owner(),
+			Expiration:  g.expiration(&expireISO),
+			Value:       "dry-run",
+		}, nil
+	}
+
+	b := g.backoff
+	tok := &gitlab.PersonalAccessToken{}
+	resp := &gitlab.Response{}
+	err = retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		if account.group == "" {
+			tok, resp, err = g.client.PersonalAccessTokens.RotatePersonalAccessToken(gltoken.ID, &gitlab.RotatePersonalAccessTokenOptions{
+				ExpiresAt: &expireISO,
+			}, gitlab.WithContext(g.ctx))
+		} else {
+			tok, resp, err = g.client.Groups.RotateServiceAccountPersonalAccessToken(account.group, account.id, gltoken.ID, &gitlab.RotateServiceAccountPersonalAccessTokenOptions{
+				ExpiresAt: &expireISO,
+			}, gitlab.WithContext(g.ctx))
+		}
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		g.log.Error("failed to rotate service account token", lctx.Str("name", config.Source.Name), lctx.Str("status", resp.Status))
+		return nil, ErrTokenRotationFailed
+	}
+	g.log.Debug("rotated service account token", lctx.Str("name", tok.Name), lctx.Int64("id", tok.ID), lctx.Int64("userID", account.id))
+	g.invalidateServiceAccountTokens(account)
+
+	return g.serviceAccountToken(tok, account), nil
+}
+
+func (g *GitLab) revokeServiceAccountToken(source *token.Source, tok *token.Token) error {
+	id, err := strconv.ParseInt(tok.ID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid service account token ID %s: %w", tok.ID, err)
+	}
+	uid, err := strconv.ParseInt(tok.Owner, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid service account token owner %s: %w", tok.Owner, err)
+	}
+	account := &serviceAccount{id: uid, username: source.Owner, group: source.Group}
+
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not revoking service account token", lctx.Str("name", source.Name), lctx.Int64("id", id), lctx.Int64("userID", uid))
+		return nil
+	}
+
+	b := g.backoff
+	resp := &gitlab.Response{}
+	err = retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		if account.group == "" {
+			resp, err = g.client.PersonalAccessTokens.RevokePersonalAccessToken(id, gitlab.WithContext(g.ctx))
+		} else {
+			resp, err = g.client.Groups.RevokeServiceAccountPersonalAccessToken(account.group, uid, id, gitlab.WithContext(g.ctx))
+		}
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke service account token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	g.invalidateServiceAccountTokens(account)
+
+	if resp.StatusCode != http.StatusNoContent {
+		g.log.Error("failed to revoke service account token", lctx.Str("name", source.Name), lctx.Str("status", resp.Status))
+		return ErrTokenRevocationFailed
+	}
+	g.log.Debug("revoked service account token", lctx.Str("name", source.Name), lctx.Int64("id", id), lctx.Int64("userID", uid))
+
+	return nil
+}
+
+// deleteServiceAccountToken revokes the token, the service account itself is kept.
+func (g *GitLab) deleteServiceAccountToken(source *token.Source) error {
+	tok, err := g.getServiceAccountToken(source)
+	if err != nil {
+		return err
+	}
+
+	return g.revokeServiceAccountToken(source, tok)
+}
+
+func (g *GitLab) serviceAccountToken(tok *gitlab.PersonalAccessToken, account *serviceAccount) *token.Token {
+	return &token.Token{
+		ID:          strconv.FormatInt(tok.ID, 10),
+		Name:        tok.Name,
+		Description: tok.Description,
+		Scopes:      tok.Scopes,
+		Type:        TypeServiceAccount,
+		Owner:       account.owner(),
+		Value:       tok.Token,
+		Expiration:  g.expiration(tok.ExpiresAt),
+		Created:     timeOrZero(tok.CreatedAt),
+		LastUsed:    timeOrZero(tok.LastUsedAt),
+	}
+}
@@ -0,0 +1,257 @@
+package source
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+// fakeServiceAccounts is a stand-in of the service account API of the instance, or of a group if group is set.
+type fakeServiceAccounts struct {
+	t        *testing.T
+	group    string
+	accounts []map[string]any
+	// tokens are the tokens of the service accounts by their user ID
+	tokens map[string][]map[string]any
+
+	created []string
+	rotated []string
+	revoked []string
+}
+
+func newFakeServiceAccounts(t *testing.T, group string) *fakeServiceAccounts {
+	return &fakeServiceAccounts{t: t, group: group, tokens: map[string][]map[string]any{}}
+}
+
+// serve registers the endpoints of the instance or the group service accounts.
+func (f *fakeServiceAccounts) serve(mux *http.ServeMux) {
+	accounts := "/service_accounts"
+	tokens := "/users/{uid}/personal_access_tokens"
+	tok := "/personal_access_tokens/{id}"
+	if f.group != "" {
+		accounts = "/groups/{group}/service_accounts"
+		tokens = accounts + "/{uid}/personal_access_tokens"
+		tok = tokens + "/{id}"
+	}
+
+	mux.HandleFunc("GET "+accounts, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(f.t, f.group, r.PathValue("group"))
+		writeJSON(w, http.StatusOK, f.accounts)
+	})
+	mux.HandleFunc("POST "+accounts, func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]any{}
+		decodeJSON(f.t, r, &body)
+		account := map[string]any{"id": 100 + len(f.accounts), "username": body["username"], "name": body["name"]}
+		f.accounts = append(f.accounts, account)
+		f.created = append(f.created, body["username"].(string))
+		writeJSON(w, http.StatusCreated, account)
+	})
+
+	list := func(w http.ResponseWriter, r *http.Request, uid string) {
+		active := r.URL.Query().Get("state") != "inactive"
+		toks := []map[string]any{}
+		for _, tok := range f.tokens[uid] {
+			if tok["active"] == active {
+				toks = append(toks, tok)
+			}
+		}
+		writeJSON(w, http.StatusOK, toks)
+	}
+	if f.group == "" {
+		// tokens of instance service accounts are personal tokens of the service account user
+		mux.HandleFunc("GET /personal_access_tokens", func(w http.ResponseWriter, r *http.Request) {
+			list(w, r, r.URL.Query().Get("user_id"))
+		})
+	} else {
+		mux.HandleFunc("GET "+tokens, func(w http.ResponseWriter, r *http.Request) {
+			list(w, r, r.PathValue("uid"))
+		})
+	}
+
+	mux.HandleFunc("POST "+tokens, func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]any{}
+		decodeJSON(f.t, r, &body)
+		writeJSON(w, http.StatusCreated, f.addToken(r.PathValue("uid"), body["name"].(string), body["expires_at"]))
+	})
+	mux.HandleFunc("POST "+tok+"/rotate", func(w http.ResponseWriter, r *http.Request) {
+		uid, previous := f.token(r)
+		if previous == nil {
+			writeJSON(w, http.StatusNotFound, map[string]any{"message": "404 Not Found"})
+			return
+		}
+		body := map[string]any{}
+		decodeJSON(f.t, r, &body)
+		previous["active"] = false
+		f.rotated = append(f.rotated, r.PathValue("id"))
+		writeJSON(w, http.StatusOK, f.addToken(uid, previous["name"].(string), body["expires_at"]))
+	})
+	mux.HandleFunc("DELETE "+tok, func(w http.ResponseWriter, r *http.Request) {
+		_, revoked := f.token(r)
+		if revoked == nil {
+			writeJSON(w, http.StatusNotFound, map[string]any{"message": "404 Not Found"})
+			return
+		}
+		revoked["active"] = false
+		revoked["revoked"] = true
+		f.revoked = append(f.revoked, r.PathValue("id"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (f *fakeServiceAccounts) addToken(uid, name string, expiresAt any) map[string]any {
+	id := 10
+	for _, toks := range f.tokens {
+		id += len(toks)
+	}
+	userID, _ := strconv.Atoi(uid)
+	tok := map[string]any{"id": id, "name": name, "user_id": userID, "active": true, "token": "glpat-service-account", "expires_at": expiresAt}
+	f.tokens[uid] = append(f.tokens[uid], tok)
+	return tok
+}
+
+// token returns the token of a request and the ID of its service account, instance tokens are found by ID alone.
+func (f *fakeServiceAccounts) token(r *http.Request) (string, map[string]any) {
+	for uid, toks := range f.tokens {
+		if r.PathValue("uid") != "" && r.PathValue("uid") != uid {
+			continue
+		}
+		for _, tok := range toks {
+			if strconv.Itoa(tok["id"].(int)) == r.PathValue("id") {
+				return uid, tok
+			}
+		}
+	}
+	return "", nil
+}
+
+// serviceAccountVariants are the instance and group service accounts, instance service accounts require admin.
+var serviceAccountVariants = []struct {
+	name  string
+	group string
+	admin bool
+}{
+	{name: "instance", admin: true},
+	{name: "group", group: "bots"},
+}
+
+func serviceAccountConfig(group string) *token.Config {
+	return &token.Config{
+		Name:     "renovate",
+		Rotation: &token.Rotation{Validity: 30 * 24 * time.Hour},
+		Source:   token.Source{Name: "renovate", Type: TypeServiceAccount, Owner: "renovate-bot", Group: group, Scopes: []string{"api"}},
+	}
+}
+
+func TestGitLab_CreateServiceAccountToken(t *testing.T) {
+	for _, variant := range serviceAccountVariants {
+		t.Run(variant.name, func(t *testing.T) {
+			f := newFakeServiceAccounts(t, variant.group)
+			mux := http.NewServeMux()
+			serveUser(mux, variant.admin)
+			f.serve(mux)
+			g, err := newTestGitLab(t, mux)
+			require.NoError(t, err)
+			config := serviceAccountConfig(variant.group)
+
+			_, err = g.GetToken(&config.Source)
+			assert.ErrorIs(t, err, ErrTokenNotFound, "a missing service account has no token")
+
+			tok, err := g.CreateToken(config)
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{"renovate-bot"}, f.created, "the missing service account is created")
+			assert.Equal(t, "100", tok.Owner)
+			assert.Equal(t, "10", tok.ID)
+			assert.Equal(t, "glpat-service-account", tok.Value)
+			assert.Equal(t, TypeServiceAccount, tok.Type)
+
+			found, err := g.GetToken(&config.Source)
+			require.NoError(t, err)
+			assert.Equal(t, "10", found.ID, "the listings are refreshed after the creation")
+
+			// the existing service account gets a second token
+			config.Source.Name = "renovate-ci"
+			_, err = g.CreateToken(config)
+			require.NoError(t, err)
+			assert.Len(t, f.created, 1)
+			assert.Len(t, f.tokens["100"], 2)
+		})
+	}
+}
+
+func TestGitLab_RotateServiceAccountToken(t *testing.T) {
+	for _, variant := range serviceAccountVariants {
+		t.Run(variant.name, func(t *testing.T) {
+			f := newFakeServiceAccounts(t, variant.group)
+			f.accounts = []map[string]any{{"id": 100, "username": "renovate-bot"}, {"id": 101, "username": "other-bot"}}
+			f.addToken("100", "renovate", "2030-01-01")
+			mux := http.NewServeMux()
+			serveUser(mux, variant.admin)
+			f.serve(mux)
+			g, err := newTestGitLab(t, mux)
+			require.NoError(t, err)
+			config := serviceAccountConfig(variant.group)
+
+			tok, err := g.RotateToken(config)
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{"10"}, f.rotated)
+			assert.Equal(t, "11", tok.ID)
+			assert.Equal(t, "100", tok.Owner)
+			assert.Nil(t, tok.Replaces, "the previous token is revoked by the rotation")
+
+			found, err := g.GetToken(&config.Source)
+			require.NoError(t, err)
+			assert.Equal(t, "11", found.ID, "the listings are refreshed after the rotation")
+		})
+	}
+}
+
+func TestGitLab_RevokeServiceAccountToken(t *testing.T) {
+	for _, variant := range serviceAccountVariants {
+		t.Run(variant.name, func(t *testing.T) {
+			f := newFakeServiceAccounts(t, variant.group)
+			f.accounts = []map[string]any{{"id": 100, "username": "renovate-bot"}}
+			f.addToken("100", "renovate", "2030-01-01")
+			f.addToken("100", "renovate", "2030-01-01")
+			mux := http.NewServeMux()
+			serveUser(mux, variant.admin)
+			f.serve(mux)
+			g, err := newTestGitLab(t, mux)
+			require.NoError(t, err)
+			config := serviceAccountConfig(variant.group)
+
+			// the previous token of an overlapping rotation
+			require.NoError(t, g.RevokeToken(&config.Source, &token.Token{ID: "10", Owner: "100"}))
+			assert.Equal(t, []string{"10"}, f.revoked)
+
+			require.NoError(t, g.DeleteToken(&config.Source))
+			assert.Equal(t, []string{"10", "11"}, f.revoked)
+
+			_, err = g.GetToken(&config.Source)
+			assert.ErrorIs(t, err, ErrTokenRevoked)
+			assert.Len(t, f.accounts, 1, "the service account is kept")
+		})
+	}
+}
+
+func TestGitLab_InstanceServiceAccountRequiresAdmin(t *testing.T) {
+	f := newFakeServiceAccounts(t, "")
+	mux := http.NewServeMux()
+	serveUser(mux, false)
+	f.serve(mux)
+	g, err := newTestGitLab(t, mux)
+	require.NoError(t, err)
+	config := serviceAccountConfig("")
+
+	_, err = g.GetToken(&config.Source)
+	assert.ErrorIs(t, err, ErrAdminRequired)
+	_, err = g.CreateToken(config)
+	assert.ErrorIs(t, err, ErrAdminRequired)
+	assert.Empty(t, f.created)
+}
@@ -0,0 +1,416 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hamba/cmd/v3/observe"
+	"github.com/hamba/logger/v2"
+	"github.com/sethvargo/go-retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+// newTestGitLab creates a source against a stand-in of the GitLab API, mux serves the paths below /api/v4.
+func newTestGitLab(t *testing.T, mux *http.ServeMux, opts ...GitLabOption) (*GitLab, error) {
+	t.Helper()
+
+	srv := httptest.NewServer(http.StripPrefix("/api/v4", mux))
+	t.Cleanup(srv.Close)
+
+	obsvr := &observe.Observer{Log: logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)}
+	g, err := NewGitLabSource(context.Background(), srv.URL+"/api/v4", "secret", obsvr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	g.backoff = retry.WithMaxRetries(3, retry.NewConstant(time.Millisecond))
+
+	return g, nil
+}
+
+// serveUser serves the user of the source token.
+func serveUser(mux *http.ServeMux, admin bool) {
+	mux.HandleFunc("GET /user", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"id": 1, "username": "tocli", "is_admin": admin})
+	})
+}
+
+// decodeJSON decodes the body of a request to the stand-in.
+func decodeJSON(t *testing.T, r *http.Request, v any) {
+	t.Helper()
+
+	require.NoError(t, json.NewDecoder(r.Body).Decode(v))
+}
+
+func TestSelectPersonalToken(t *testing.T) {
+	candidates := []*personalAccessToken{
+		{PersonalAccessToken: gitlab.PersonalAccessToken{ID: 12, Name: "renovate"}},
+		{PersonalAccessToken: gitlab.PersonalAccessToken{ID: 15, Name: "renovate"}},
+	}
+
+	tests := []struct {
+		name       string
+		source     token.Source
+		candidates []*personalAccessToken
+		wantID     int64
+		wantErr    error
+	}{
+		{
+			name:       "single match",
+			source:     token.Source{Name: "renovate"},
+			candidates: candidates[:1],
+			wantID:     12,
+		},
+		{
+			name:       "no match",
+			source:     token.Source{Name: "renovate"},
+			candidates: nil,
+			wantErr:    ErrTokenNotFound,
+		},
+		{
+			name:       "ambiguous",
+			source:     token.Source{Name: "renovate"},
+			candidates: candidates,
+			wantErr:    ErrAmbiguousToken,
+		},
+		{
+			name:       "pinned",
+			source:     token.Source{Name: "renovate", ID: "15"},
+			candidates: candidates,
+			wantID:     15,
+		},
+		{
+			name:       "previous run",
+			source:     token.Source{Name: "renovate", LastID: "12"},
+			candidates: candidates,
+			wantID:     12,
+		},
+		{
+			name:       "stale pin",
+			source:     token.Source{Name: "renovate", ID: "3", LastID: "15"},
+			candidates: candidates,
+			wantErr:    ErrTokenNotFound,
+		},
+		{
+			name:       "stale pin with single match",
+			source:     token.Source{Name: "renovate", ID: "3"},
+			candidates: candidates[:1],
+			wantErr:    ErrTokenNotFound,
+		},
+		{
+			name:       "stale previous run with single match",
+			source:     token.Source{Name: "renovate", LastID: "3"},
+			candidates: candidates[:1],
+			wantID:     12,
+		},
+		{
+			name:       "pinned but missing",
+			source:     token.Source{Name: "renovate", ID: "3"},
+			candidates: nil,
+			wantErr:    ErrTokenNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectPersonalToken(&tt.source, tt.candidates)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantID, got.ID)
+		})
+	}
+}
+
+func TestGitLab_personalTokensPages(t *testing.T) {
+	pages := map[string][]map[string]any{
+		"1": {{"id": 12, "name": "other", "active": true, "expires_at": "2030-01-01"}},
+		"2": {{"id": 15, "name": "renovate", "active": true, "expires_at": "2030-01-01", "last_used_ips": []string{"10.0.0.1"}}},
+	}
+	requests := 0
+	mux := http.NewServeMux()
+	serveUser(mux, false)
+	mux.HandleFunc("GET /personal_access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "1", r.URL.Query().Get("user_id"))
+		assert.Equal(t, "active", r.URL.Query().Get("state"))
+		page := r.URL.Query().Get("page")
+		if page == "1" {
+			w.Header().Set("X-Next-Page", "2")
+		}
+		writeJSON(w, http.StatusOK, pages[page])
+	})
+	g, err := newTestGitLab(t, mux)
+	require.NoError(t, err)
+
+	tok, err := g.findPersonalToken(&token.Source{Name: "renovate", Type: TypePersonal})
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(15), tok.ID, "the token on the second page is found")
+	assert.Equal(t, []string{"10.0.0.1"}, tok.LastUsedIPs)
+	assert.Equal(t, 2, requests)
+
+	_, err = g.findPersonalToken(&token.Source{Name: "other", Type: TypePersonal})
+	require.NoError(t, err)
+	assert.Equal(t, 2, requests, "the listing is cached for the run")
+}
+
+func TestGitLab_preflight(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	cred := &Credential{ID: 7, Name: "tocli", Scopes: []string{"api"}, ExpiresAt: now.Add(90 * 24 * time.Hour)}
+	personal := token.Config{Name: "renovate", State: token.TokenStateActive, Source: token.Source{Name: "renovate", Type: TypePersonal}}
+	impersonation := token.Config{Name: "bot", State: token.TokenStateActive, Source: token.Source{Name: "bot", Type: TypeImpersonation, Owner: "bot"}}
+	other := token.Config{Name: "other", State: token.TokenStateActive, Source: token.Source{Name: "other", Type: TypePersonal, Owner: "alice"}}
+	instanceAccount := token.Config{Name: "ci", State: token.TokenStateActive, Source: token.Source{Name: "ci", Type: TypeServiceAccount, Owner: "ci-bot"}}
+	groupAccount := token.Config{Name: "deploy", State: token.TokenStateActive, Source: token.Source{Name: "deploy", Type: TypeServiceAccount, Owner: "deploy-bot", Group: "group"}}
+
+	tests := []struct {
+		name    string
+		admin   bool
+		cred    *Credential
+		configs []token.Config
+		wantErr error
+	}{
+		{
+			name:    "covers own tokens",
+			cred:    cred,
+			configs: []token.Config{personal},
+		},
+		{
+			name:    "missing api scope",
+			cred:    &Credential{Name: "tocli", Scopes: []string{"read_api"}},
+			configs: []token.Config{personal},
+			wantErr: ErrInsufficientScope,
+		},
+		{
+			name:    "impersonation requires admin",
+			cred:    cred,
+			configs: []token.Config{personal, impersonation},
+			wantErr: ErrAdminRequired,
+		},
+		{
+			name:    "other user requires admin",
+			cred:    cred,
+			configs: []token.Config{other},
+			wantErr: ErrAdminRequired,
+		},
+		{
+			name:    "instance service account requires admin",
+			cred:    cred,
+			configs: []token.Config{instanceAccount},
+			wantErr: ErrAdminRequired,
+		},
+		{
+			name:    "group service account",
+			cred:    cred,
+			configs: []token.Config{groupAccount},
+		},
+		{
+			name:    "admin",
+			admin:   true,
+			cred:    cred,
+			configs: []token.Config{impersonation, other, instanceAccount},
+		},
+		{
+			name:    "inactive tokens are ignored",
+			cred:    cred,
+			configs: []token.Config{{Name: "bot", State: token.TokenStateInactive, Source: impersonation.Source}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &GitLab{
+				admin: tt.admin,
+				user:  &gitlab.User{ID: 1, Username: "tocli"},
+				log:   logger.New(io.Discard, logger.LogfmtFormat(), logger.Error),
+			}
+
+			err := g.preflight(tt.cred, tt.configs, now)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestGitLab_preflightExpiryWarning(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	personal := token.Config{Name: "renovate", State: token.TokenStateActive, Source: token.Source{Name: "renovate", Type: TypePersonal}}
+
+	tests := []struct {
+		name      string
+		expiresIn time.Duration
+		opts      []GitLabOption
+		wantWarn  bool
+	}{
+		{name: "default window", expiresIn: 20 * 24 * time.Hour, wantWarn: true},
+		{name: "outside default window", expiresIn: 40 * 24 * time.Hour},
+		{name: "configured window", expiresIn: 40 * 24 * time.Hour, opts: []GitLabOption{WithExpiryWarning(60 * 24 * time.Hour)}, wantWarn: true},
+		{name: "outside configured window", expiresIn: 20 * 24 * time.Hour, opts: []GitLabOption{WithExpiryWarning(7 * 24 * time.Hour)}},
+		{name: "no expiry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			g := &GitLab{
+				user: &gitlab.User{ID: 1, Username: "tocli"},
+				log:  logger.New(buf, logger.LogfmtFormat(), logger.Warn),
+			}
+			for _, opt := range tt.opts {
+				opt(g)
+			}
+			cred := &Credential{ID: 7, Name: "tocli", Scopes: []string{"api"}}
+			if tt.expiresIn != 0 {
+				cred.ExpiresAt = now.Add(tt.expiresIn)
+			}
+
+			require.NoError(t, g.preflight(cred, []token.Config{personal}, now))
+
+			if tt.wantWarn {
+				assert.Contains(t, buf.String(), "source token expires soon")
+				return
+			}
+			assert.Empty(t, buf.String())
+		})
+	}
+}
+
+func TestGitLab_isSourceToken(t *testing.T) {
+	g := &GitLab{user: &gitlab.User{ID: 1, Username: "tocli"}}
+	cred := &Credential{ID: 7, Name: "tocli"}
+
+	assert.True(t, g.isSourceToken(cred, &token.Source{Name: "tocli", Type: TypePersonal}))
+	assert.True(t, g.isSourceToken(cred, &token.Source{Name: "renamed", ID: "7", Type: TypePersonal, Owner: "tocli"}))
+	assert.False(t, g.isSourceToken(cred, &token.Source{Name: "tocli", ID: "8", Type: TypePersonal}))
+	assert.False(t, g.isSourceToken(cred, &token.Source{Name: "tocli", Type: TypePersonal, Owner: "alice"}))
+	assert.False(t, g.isSourceToken(cred, &token.Source{Name: "tocli", Type: TypeImpersonation}))
+}
+
+func TestGitLab_preflightCreation(t *testing.T) {
+	rotation := &token.Rotation{RotateBefore: 24 * time.Hour, Validity: 30 * 24 * time.Hour}
+	overlap := &token.Rotation{RotateBefore: 24 * time.Hour, Validity: 30 * 24 * time.Hour, Strategy: token.RotationStrategyOverlap, GracePeriod: time.Hour}
+	personal := func(name string, rot *token.Rotation, recovery token.RecoveryPolicy) token.Config {
+		return token.Config{Name: name, State: token.TokenStateActive, Rotation: rot, Recovery: recovery, Source: token.Source{Name: name, Type: TypePersonal}}
+	}
+
+	tests := []struct {
+		name    string
+		admin   bool
+		configs []token.Config
+		wantErr error
+	}{
+		{name: "existing token", configs: []token.Config{personal("renovate", rotation, "")}},
+		{name: "missing token", configs: []token.Config{personal("missing", rotation, "")}, wantErr: ErrAdminRequired},
+		{name: "expired token", configs: []token.Config{personal("expired", rotation, "")}, wantErr: ErrAdminRequired},
+		{name: "expired token without recovery", configs: []token.Config{personal("expired", rotation, token.RecoveryPolicyFail)}},
+		{name: "overlap", configs: []token.Config{personal("renovate", overlap, "")}, wantErr: ErrAdminRequired},
+		{name: "admin", admin: true, configs: []token.Config{personal("missing", overlap, "")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			serveUser(mux, tt.admin)
+			mux.HandleFunc("GET /personal_access_tokens", func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("state") == "inactive" {
+					writeJSON(w, http.StatusOK, []map[string]any{{"id": 3, "name": "expired", "active": false}})
+					return
+				}
+				writeJSON(w, http.StatusOK, []map[string]any{{"id": 4, "name": "renovate", "active": true}})
+			})
+			g, err := newTestGitLab(t, mux)
+			require.NoError(t, err)
+
+			err = g.preflightCreation(tt.configs)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestGitLab_detectUser(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		admin     bool
+		wantAdmin bool
+		wantUser  bool
+	}{
+		{name: "admin", status: http.StatusOK, admin: true, wantAdmin: true, wantUser: true},
+		{name: "no admin", status: http.StatusOK, wantUser: true},
+		{name: "user forbidden", status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /user", func(w http.ResponseWriter, _ *http.Request) {
+				if tt.status != http.StatusOK {
+					writeJSON(w, tt.status, map[string]any{"message": "403 Forbidden"})
+					return
+				}
+				writeJSON(w, http.StatusOK, map[string]any{"id": 1, "username": "tocli", "is_admin": tt.admin})
+			})
+			g, err := newTestGitLab(t, mux)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantAdmin, g.admin)
+			assert.Equal(t, tt.wantUser, g.user != nil)
+			assert.Equal(t, tt.wantUser, g.isCurrentUser(""))
+
+			_, err = g.ownerID("")
+			if tt.wantUser {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrUserNotFound)
+			}
+			_, err = g.ownerID("42")
+			if tt.wantAdmin {
+				assert.NoError(t, err, "admins manage tokens of other users")
+			} else {
+				assert.ErrorIs(t, err, ErrAdminRequired)
+			}
+		})
+	}
+}
+
+func TestGitLab_detectUserFails(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"message": "401 Unauthorized"})
+	})
+
+	_, err := newTestGitLab(t, mux)
+
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestGitLab_preflightWithoutUser(t *testing.T) {
+	g := &GitLab{log: logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)}
+	cred := &Credential{ID: 7, Name: "tocli", Scopes: []string{"api"}}
+	personal := token.Config{Name: "renovate", State: token.TokenStateActive, Source: token.Source{Name: "renovate", Type: TypePersonal}}
+
+	err := g.preflight(cred, []token.Config{personal}, time.Now())
+
+	assert.ErrorIs(t, err, ErrAdminRequired)
+	assert.NoError(t, g.preflightCreation([]token.Config{personal}))
+}
@@ -0,0 +1,206 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	lctx "github.com/hamba/logger/v2/ctx"
+	"github.com/sethvargo/go-retry"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+// findTriggerToken finds a pipeline trigger of the owner project by its description.
+func (g *GitLab) findTriggerToken(source *token.Source) (*gitlab.PipelineTrigger, error) {
+	triggers, err := g.triggerTokens(source.Owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pipeline triggers: %w", err)
+	}
+
+	candidates := []*gitlab.PipelineTrigger{}
+	for _, trigger := range triggers {
+		if trigger.Description == source.Name && trigger.DeletedAt == nil {
+			candidates = append(candidates, trigger)
+		}
+	}
+
+	gltrigger, err := selectToken(source, candidates, func(trigger *gitlab.PipelineTrigger) (int64, string) {
+		return trigger.ID, fmt.Sprintf("created %s", trigger.CreatedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	g.log.Debug("matching pipeline trigger", lctx.Str("name", gltrigger.Description), lctx.Int64("id", gltrigger.ID), lctx.Str("owner", source.Owner))
+
+	return gltrigger, nil
+}
+
+// triggerTokens lists all pipeline triggers of a project, once per run.
+func (g *GitLab) triggerTokens(owner string) ([]*gitlab.PipelineTrigger, error) {
+	return cachedList(g.cache, listKey(listTrigger, owner, ""), func() ([]*gitlab.PipelineTrigger, error) {
+		opt := &gitlab.ListPipelineTriggersOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100, Page: 1},
+		}
+
+		all := []*gitlab.PipelineTrigger{}
+		for opt.Page != 0 {
+			b := g.backoff
+			triggers := []*gitlab.PipelineTrigger{}
+			resp := &gitlab.Response{}
+			err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+				var err error
+				triggers, resp, err = g.client.PipelineTriggers.ListPipelineTriggers(owner, opt, gitlab.WithContext(g.ctx))
+				if retryErr := g.isRetriable(resp, err); retryErr != nil {
+					return retryErr
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			all = append(all, triggers...)
+			opt.Page = resp.NextPage
+		}
+
+		return all, nil
+	})
+}
+
+func (g *GitLab) getTriggerToken(source *token.Source) (*token.Token, error) {
+	gltrigger, err := g.findTriggerToken(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find trigger token: %w", err)
+	}
+
+	return triggerToken(gltrigger, source.Owner), nil
+}
+
+func (g *GitLab) createTriggerToken(config *token.Config) (*token.Token, error) {
+	opt := &gitlab.AddPipelineTriggerOptions{
+		Description: &config.Source.Name,
+	}
+
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not creating pipeline trigger", lctx.Str("name", config.Source.Name), lctx.Str("owner", config.Source.Owner))
+		return &token.Token{
+			Name:       config.Source.Name,
+			Type:       TypeTrigger,
+			Owner:      config.Source.Owner,
+			Created:    time.Now(),
+			Expiration: time.Now().Add(config.Rotation.Lifetime()),
+			Value:      "dry-run",
+		}, nil
+	}
+
+	b := g.backoff
+	trigger := &gitlab.PipelineTrigger{}
+	resp := &gitlab.Response{}
+	err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		trigger, resp, err = g.client.PipelineTriggers.AddPipelineTrigger(config.Source.Owner, opt, gitlab.WithContext(g.ctx))
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pipeline trigger: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusCreated {
+		g.log.Error("failed to create pipeline trigger", lctx.Str("name", config.Source.Name), lctx.Str("status", resp.Status))
+		return nil, ErrTokenCreationFailed
+	}
+	g.log.Debug("created pipeline trigger", lctx.Str("name", trigger.Description), lctx.Int64("id", trigger.ID), lctx.Str("owner", config.Source.Owner))
+	g.cache.invalidate(listTrigger, config.Source.Owner)
+
+	tok := triggerToken(trigger, config.Source.Owner)
+	tok.Expiration = tok.Created.Add(config.Rotation.Lifetime())
+
+	return tok, nil
+}
+
+// rotateTriggerToken emulates rotation, as trigger tokens can't be rotated:
+// a new trigger with the same description is created and the previous one is deleted once the new one is stored.
+func (g *GitLab) rotateTriggerToken(config *token.Config) (*token.Token, error) {
+	previous, err := g.getTriggerToken(&config.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate token: %w", err)
+	}
+
+	tok, err := g.createTriggerToken(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate token: %w", err)
+	}
+	tok.Replaces = previous
+
+	return tok, nil
+}
+
+func (g *GitLab) revokeTriggerToken(source *token.Source, tok *token.Token) error {
+	id, err := strconv.ParseInt(tok.ID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid pipeline trigger ID %s: %w", tok.ID, err)
+	}
+
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not deleting pipeline trigger", lctx.Str("name", source.Name), lctx.Int64("id", id), lctx.Str("owner", source.Owner))
+		return nil
+	}
+
+	b := g.backoff
+	resp := &gitlab.Response{}
+	err = retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		resp, err = g.client.PipelineTriggers.DeletePipelineTrigger(source.Owner, id, gitlab.WithContext(g.ctx))
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete pipeline trigger: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	g.cache.invalidate(listTrigger, source.Owner)
+
+	if resp.StatusCode != http.StatusNoContent {
+		g.log.Error("failed to delete pipeline trigger", lctx.Str("name", source.Name), lctx.Str("status", resp.Status))
+		return ErrTokenRevocationFailed
+	}
+	g.log.Debug("deleted pipeline trigger", lctx.Str("name", source.Name), lctx.Int64("id", id), lctx.Str("owner", source.Owner))
+
+	return nil
+}
+
+func (g *GitLab) deleteTriggerToken(source *token.Source) error {
+	tok, err := g.getTriggerToken(source)
+	if err != nil {
+		return err
+	}
+
+	return g.revokeTriggerToken(source, tok)
+}
+
+// triggerToken converts a pipeline trigger, trigger tokens never expire and are rotated based on their creation.
+func triggerToken(trigger *gitlab.PipelineTrigger, owner string) *token.Token {
+	created := time.Time{}
+	if trigger.CreatedAt != nil {
+		created = *trigger.CreatedAt
+	}
+
+	return &token.Token{
+		ID:       strconv.FormatInt(trigger.ID, 10),
+		Name:     trigger.Description,
+		Type:     TypeTrigger,
+		Owner:    owner,
+		Value:    trigger.Token,
+		Created:  created,
+		LastUsed: timeOrZero(trigger.LastUsed),
+	}
+}
@@ -0,0 +1,52 @@
+package source
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+func TestGitLab_TriggerTokenSelection(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  token.Source
+		wantID  string
+		wantErr error
+	}{
+		{name: "ambiguous", source: token.Source{Name: "deploy"}, wantErr: ErrAmbiguousToken},
+		{name: "pinned", source: token.Source{Name: "deploy", ID: "3"}, wantID: "3"},
+		{name: "previous run", source: token.Source{Name: "deploy", LastID: "4"}, wantID: "4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			serveUser(mux, false)
+			mux.HandleFunc("GET /projects/{id}/triggers", func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "group/project", r.PathValue("id"))
+				writeJSON(w, http.StatusOK, []map[string]any{
+					{"id": 3, "description": "deploy", "token": "glptt-3"},
+					{"id": 4, "description": "deploy", "token": "glptt-4"},
+					{"id": 5, "description": "deploy", "token": "glptt-5", "deleted_at": "2025-06-01T00:00:00Z"},
+				})
+			})
+			g, err := newTestGitLab(t, mux)
+			require.NoError(t, err)
+			src := tt.source
+			src.Type = TypeTrigger
+			src.Owner = "group/project"
+
+			tok, err := g.GetToken(&src)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantID, tok.ID)
+		})
+	}
+}
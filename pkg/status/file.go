@@ -0,0 +1,105 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/goccy/go-yaml"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+// File implements the application StatusStore in a YAML file, keyed by the token name.
+type File struct {
+	path   string
+	dryRun bool
+
+	mu       sync.Mutex
+	statuses map[string]*token.Status
+}
+
+// NewFile reads the status file, a missing file is created on the first update.
+func NewFile(path string) (*File, error) {
+	f := &File{
+		path:     path,
+		statuses: map[string]*token.Status{},
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return f, nil
+		}
+		return nil, fmt.Errorf("failed to read status file: %w", err)
+	}
+
+	if err = yaml.Unmarshal(data, &f.statuses); err != nil {
+		return nil, fmt.Errorf("failed to parse status file: %w", err)
+	}
+	if f.statuses == nil {
+		f.statuses = map[string]*token.Status{}
+	}
+
+	return f, nil
+}
+
+// WithDryRun keeps status updates in memory only.
+func (f *File) WithDryRun(dryRun bool) {
+	f.dryRun = dryRun
+}
+
+// GetStatus returns the status of a token, or an empty status if there is none.
+func (f *File) GetStatus(name string) (*token.Status, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status, ok := f.statuses[name]
+	if !ok {
+		return &token.Status{}, nil
+	}
+
+	cp := *status
+	cp.PendingRevocations = append([]token.Revocation(nil), status.PendingRevocations...)
+	return &cp, nil
+}
+
+// SetStatus updates the status of a token and writes the status file.
+func (f *File) SetStatus(name string, status *token.Status) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cp := *status
+	f.statuses[name] = &cp
+
+	if f.dryRun {
+		return nil
+	}
+
+	data, err := yaml.Marshal(f.statuses)
+	if err != nil {
+		return fmt.Errorf("failed to encode status: %w", err)
+	}
+
+	// write to a temporary file first, so an interrupted write doesn't lose pending revocations
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write status file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write status file: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write status file: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to write status file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package status
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.yaml")
+
+	f, err := NewFile(path)
+	require.NoError(t, err)
+
+	status, err := f.GetStatus("missing")
+	require.NoError(t, err)
+	assert.Equal(t, &token.Status{}, status)
+
+	revokeAfter := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = f.SetStatus("renovate", &token.Status{
+		SourceID:           "42",
+		PendingRevocations: []token.Revocation{{ID: "41", Owner: "7", RevokeAfter: revokeAfter}},
+	})
+	require.NoError(t, err)
+
+	// a new store reads the persisted status
+	f, err = NewFile(path)
+	require.NoError(t, err)
+	status, err = f.GetStatus("renovate")
+	require.NoError(t, err)
+	assert.Equal(t, "42", status.SourceID)
+	require.Len(t, status.PendingRevocations, 1)
+	assert.Equal(t, "41", status.PendingRevocations[0].ID)
+	assert.True(t, revokeAfter.Equal(status.PendingRevocations[0].RevokeAfter))
+}
+
+func TestFile_DryRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.yaml")
+
+	f, err := NewFile(path)
+	require.NoError(t, err)
+	f.WithDryRun(true)
+	require.NoError(t, f.SetStatus("renovate", &token.Status{SourceID: "42"}))
+
+	status, err := f.GetStatus("renovate")
+	require.NoError(t, err)
+	assert.Equal(t, "42", status.SourceID)
+
+	assert.NoFileExists(t, path)
+}
@@ -6,13 +6,26 @@ import (
 
 // Config defines settings for a specific token.
 type Config struct {
-	Name     string     `yaml:"name" validate:"required"`
-	State    TokenState `yaml:"state" validate:"required"`
-	Rotation *Rotation  `yaml:"rotation,omitempty"`
-	Source   Source     `yaml:"source" validate:"required"`
-	Vault    Vault      `yaml:"vault" validate:"required"`
+	Name     string         `yaml:"name" validate:"required"`
+	Template string         `yaml:"template,omitempty"` // name of the template the token is based on
+	State    TokenState     `yaml:"state" validate:"required"`
+	Rotation *Rotation      `yaml:"rotation,omitempty"`
+	Recovery RecoveryPolicy `yaml:"recovery,omitempty"`
+	Source   Source         `yaml:"source" validate:"required"`
+	Vault    Vault          `yaml:"vault" validate:"required"`
+	// Origin is the config entry or generator the token was defined by, for error messages.
+	Origin string `yaml:"-"`
 }
 
+type RecoveryPolicy string
+
+const (
+	// RecoveryPolicyRecreate creates a replacement for an expired or revoked token, the default.
+	RecoveryPolicyRecreate RecoveryPolicy = "recreate"
+	// RecoveryPolicyFail reports an expired or revoked token as error.
+	RecoveryPolicyFail RecoveryPolicy = "fail"
+)
+
 type TokenState string
 
 const (
@@ -26,23 +39,69 @@ type Status struct {
 	SourceID  string `yaml:"token_id"`
 	VaultID   string `yaml:"vault_id"`
 	ItemID    string `yaml:"item_id"`
-	ExpiresAt string `yaml:"expires_at"`
+	ExpiresAt string `yaml:"expires_at"` // end of the token's validity, as RFC 3339
+	// LastUsedAt is the last use of the token reported by the source, as RFC 3339.
+	LastUsedAt string `yaml:"last_used_at,omitempty"`
+	// PinnedID is the source.id configured when the token was recorded, the token was resolved from or rotated from it.
+	PinnedID string `yaml:"pinned_id,omitempty"`
+	// RevokedInactiveAt is set once the token was revoked as inactive, as RFC 3339. It is not recreated while set.
+	RevokedInactiveAt string `yaml:"revoked_inactive_at,omitempty"`
+	// PendingRevocations are previous tokens of an overlapping rotation, revoked after their grace period.
+	PendingRevocations []Revocation `yaml:"pending_revocations,omitempty"`
+}
+
+// Revocation is a replaced token that is revoked once its grace period has passed.
+type Revocation struct {
+	ID          string    `yaml:"id"`
+	Owner       string    `yaml:"owner,omitempty"`
+	RevokeAfter time.Time `yaml:"revoke_after"`
 }
 
+type RotationStrategy string
+
+const (
+	// RotationStrategyRotate replaces the token, the previous token is invalid immediately.
+	RotationStrategyRotate RotationStrategy = "rotate"
+	// RotationStrategyOverlap creates a new token, the previous token is revoked after a grace period.
+	RotationStrategyOverlap RotationStrategy = "overlap"
+)
+
 // Rotation defines the validity and
 type Rotation struct {
 	RotateBefore time.Duration `yaml:"rotate_before" validate:"required"`
-	Validity     time.Duration `yaml:"validity" validate:"required"`
+	Validity     time.Duration `yaml:"validity" validate:"required_without=ValidityDays"`
+	// ValidityDays is the validity in calendar days, it takes precedence over Validity
+	ValidityDays int `yaml:"validity_days,omitempty" validate:"gte=0"`
+	// BusinessDays moves expiry dates off weekends and Holidays to the previous business day
+	BusinessDays bool `yaml:"business_days,omitempty"`
+	// Holidays are dates formatted as YYYY-MM-DD, skipped with BusinessDays
+	Holidays []string `yaml:"holidays,omitempty" validate:"dive,datetime=2006-01-02"`
+	// Strategy is either rotate (default) or overlap
+	Strategy RotationStrategy `yaml:"strategy,omitempty"`
+	// GracePeriod is the time the previous token stays valid with the overlap strategy
+	GracePeriod time.Duration `yaml:"grace_period,omitempty"`
+	// InactiveAfter stops rotating tokens that were not used for this long, since their creation if never used
+	InactiveAfter time.Duration `yaml:"inactive_after,omitempty" validate:"required_with=RevokeInactive"`
+	// RevokeInactive revokes tokens once they are inactive, instead of only not rotating them
+	RevokeInactive bool `yaml:"revoke_inactive,omitempty"`
 }
 
 // Source defines the source of a token.
 type Source struct {
 	Name        string   `yaml:"name" validate:"required"`
+	ID          string   `yaml:"id,omitempty"` // pins the token if several tokens share the name
 	Description string   `yaml:"description"`
 	Type        string   `yaml:"type" validate:"required"` // personal, project, group, ...
 	Owner       string   `yaml:"owner"`                    // user/project/group ID or full name
+	OwnerType   string   `yaml:"owner_type,omitempty"`     // project or group, for owners of deploy tokens
+	Group       string   `yaml:"group,omitempty"`          // group of a group service account, instance service account if empty
+	CanPush     bool     `yaml:"can_push,omitempty"`       // grants write access to deploy keys, read-only by default
 	Role        string   `yaml:"role"`
-	Scopes      []string `yaml:"scopes" validate:"required"`
+	Scopes      []string `yaml:"scopes"`               // required for access tokens
+	Connection  string   `yaml:"connection,omitempty"` // named source connection, defaults to the source
+
+	// LastID is the ID resolved in the previous run, it follows the token across rotations.
+	LastID string `yaml:"-"`
 }
 
 // Vault defines the target vault item for a token.
@@ -53,10 +112,43 @@ type Vault struct {
 	PathID string `yaml:"pathID"`
 	// ItemID is an optional ID for a vault item, used by 1password as item ID
 	ItemID string `yaml:"itemID"`
-	// Path is the name of the vault or project
-	Path string `yaml:"path" validate:"required"`
+	// Path is the name of the vault or project, not used for gitlab instance variables
+	Path string `yaml:"path"`
 	// Item is the name of the vault item
 	Item string `yaml:"item" validate:"required"`
-	// Field is the name of the password field, does not apply for bitwarden
-	Field string `yaml:"field" validate:"required"`
+	// Field is the name of the password field, does not apply for bitwarden and gitlab
+	Field string `yaml:"field"`
+	// UsernameField is the name of the username field for tokens with a username, defaults to "username"
+	UsernameField string `yaml:"username_field,omitempty"`
+	// Variable configures the CI/CD variable of the gitlab vault, with Path as project or group and Item as key
+	Variable Variable `yaml:"variable,omitempty"`
+}
+
+const (
+	VariableOwnerProject  = "project"
+	VariableOwnerGroup    = "group"
+	VariableOwnerInstance = "instance"
+)
+
+// Variable defines the options of a GitLab CI/CD variable.
+type Variable struct {
+	// OwnerType is project (default), group or instance, instance variables have no Path
+	OwnerType string `yaml:"owner_type,omitempty" validate:"omitempty,oneof=project group instance"`
+	Masked    bool   `yaml:"masked,omitempty"`
+	Protected bool   `yaml:"protected,omitempty"`
+	// Hidden variables are masked and can't be read back, they are only hidden on creation
+	Hidden bool `yaml:"hidden,omitempty"`
+	// EnvironmentScope limits the variable to environments, defaults to all (*)
+	EnvironmentScope string `yaml:"environment_scope,omitempty"`
+}
+
+// DefaultUsernameField is the vault field of a token username, if no UsernameField is set.
+const DefaultUsernameField = "username"
+
+// UsernameFieldOrDefault returns the vault field of a token username.
+func (v *Vault) UsernameFieldOrDefault() string {
+	if v.UsernameField == "" {
+		return DefaultUsernameField
+	}
+	return v.UsernameField
 }
@@ -0,0 +1,102 @@
+package token
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrorKind classifies the errors of sources and vaults.
+type ErrorKind string
+
+const (
+	ErrorKindUnknown      ErrorKind = "unknown"
+	ErrorKindUnauthorized ErrorKind = "unauthorized"
+	ErrorKindForbidden    ErrorKind = "forbidden"
+	ErrorKindNotFound     ErrorKind = "not_found"
+	ErrorKindExpired      ErrorKind = "expired"
+	ErrorKindRevoked      ErrorKind = "revoked"
+	ErrorKindRateLimited  ErrorKind = "rate_limited"
+	ErrorKindUnavailable  ErrorKind = "unavailable"
+	ErrorKindInvalid      ErrorKind = "invalid"
+)
+
+// Error is returned by sources and vaults, it describes a failed operation on a backend.
+// Use errors.As to access it, the wrapped error can still be matched with errors.Is.
+type Error struct {
+	// Op is the operation, e.g. get, create, rotate, revoke or delete.
+	Op string
+	// Backend is the type of the source or vault, e.g. gitlab or 1password.
+	Backend string
+	// Status is the HTTP status of the response, zero if there was none.
+	Status int
+	// Kind classifies the error.
+	Kind ErrorKind
+	// Token is the name of the token or vault item.
+	Token string
+	// Retriable is true if the operation may succeed when retried later.
+	Retriable bool
+
+	Err error
+}
+
+func (e *Error) Error() string {
+	msg := string(e.Kind)
+	if e.Err != nil {
+		msg = e.Err.Error()
+	}
+
+	prefix := []string{}
+	for _, s := range []string{e.Backend, e.Op} {
+		if s != "" {
+			prefix = append(prefix, s)
+		}
+	}
+	if e.Token != "" {
+		prefix = append(prefix, "'"+e.Token+"'")
+	}
+
+	if len(prefix) == 0 {
+		return msg
+	}
+	return strings.Join(prefix, " ") + ": " + msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Annotate sets the operation and token name of the Error wrapped by err.
+// If err wraps no Error, it is wrapped in a new Error of the given backend and kind.
+func Annotate(err error, backend, op, name string, kind ErrorKind) error {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if !errors.As(err, &e) {
+		return &Error{Op: op, Backend: backend, Kind: kind, Token: name, Err: err}
+	}
+
+	if e.Op == "" {
+		e.Op = op
+	}
+	if e.Token == "" {
+		e.Token = name
+	}
+	return err
+}
+
+// KindOf returns the kind of the Error wrapped by err, ErrorKindUnknown if there is none.
+func KindOf(err error) ErrorKind {
+	var e *Error
+	if errors.As(err, &e) && e.Kind != "" {
+		return e.Kind
+	}
+	return ErrorKindUnknown
+}
+
+// IsRetriable returns true if err wraps a retriable Error.
+func IsRetriable(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Retriable
+}
@@ -0,0 +1,53 @@
+package token
+
+import (
+	"slices"
+	"time"
+)
+
+// Lifetime returns the validity of new tokens, ValidityDays takes precedence over Validity.
+func (r Rotation) Lifetime() time.Duration {
+	if r.ValidityDays > 0 {
+		return time.Duration(r.ValidityDays) * 24 * time.Hour
+	}
+	return r.Validity
+}
+
+// ExpiryDate returns the expiry date of a token created at now, as midnight in loc.
+// Tokens are valid until the end of their expiry date, so a token is valid for at least its validity,
+// unless BusinessDays moves the date back to the previous business day, which shortens it but never before today.
+func (r Rotation) ExpiryDate(now time.Time, loc *time.Location) time.Time {
+	now = now.In(loc)
+	today := date(now, loc)
+
+	expiry := date(now.Add(r.Validity), loc)
+	if r.ValidityDays > 0 {
+		expiry = today.AddDate(0, 0, r.ValidityDays)
+	}
+
+	if r.BusinessDays {
+		for expiry.After(today) && !r.isBusinessDay(expiry) {
+			expiry = expiry.AddDate(0, 0, -1)
+		}
+	}
+
+	return expiry
+}
+
+// isBusinessDay returns true if a date is neither on a weekend nor one of the holidays.
+func (r Rotation) isBusinessDay(d time.Time) bool {
+	if d.Weekday() == time.Saturday || d.Weekday() == time.Sunday {
+		return false
+	}
+	return !slices.Contains(r.Holidays, d.Format(time.DateOnly))
+}
+
+// EndOfDay returns the end of the calendar date of d in loc, which is the start of the next day.
+// The date is taken as is, d is not converted to loc.
+func EndOfDay(d time.Time, loc *time.Location) time.Time {
+	return time.Date(d.Year(), d.Month(), d.Day()+1, 0, 0, 0, 0, loc)
+}
+
+func date(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
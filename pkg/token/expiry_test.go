@@ -0,0 +1,83 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotation_ExpiryDate(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("timezone data unavailable")
+	}
+	// Wednesday, 23:30 in UTC is already Thursday in Berlin
+	now := time.Date(2025, 6, 4, 23, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		rotation Rotation
+		loc      *time.Location
+		want     string
+	}{
+		{
+			name:     "validity",
+			rotation: Rotation{Validity: 48 * time.Hour},
+			loc:      time.UTC,
+			want:     "2025-06-06",
+		},
+		{
+			name:     "validity in timezone",
+			rotation: Rotation{Validity: 48 * time.Hour},
+			loc:      berlin,
+			want:     "2025-06-07",
+		},
+		{
+			name:     "validity days",
+			rotation: Rotation{Validity: time.Hour, ValidityDays: 7},
+			loc:      time.UTC,
+			want:     "2025-06-11",
+		},
+		{
+			name:     "business days skip weekend",
+			rotation: Rotation{ValidityDays: 4, BusinessDays: true},
+			loc:      time.UTC,
+			want:     "2025-06-06",
+		},
+		{
+			name:     "business days skip holidays",
+			rotation: Rotation{ValidityDays: 6, BusinessDays: true, Holidays: []string{"2025-06-10", "2025-06-09"}},
+			loc:      time.UTC,
+			want:     "2025-06-06",
+		},
+		{
+			name:     "business days not before today",
+			rotation: Rotation{ValidityDays: 1, BusinessDays: true, Holidays: []string{"2025-06-05"}},
+			loc:      time.UTC,
+			want:     "2025-06-04",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.rotation.ExpiryDate(now, tt.loc)
+
+			assert.Equal(t, tt.want, got.Format(time.DateOnly))
+			assert.Equal(t, tt.loc, got.Location())
+		})
+	}
+}
+
+func TestEndOfDay(t *testing.T) {
+	date := time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	got := EndOfDay(date, time.UTC)
+
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), got)
+}
+
+func TestRotation_Lifetime(t *testing.T) {
+	assert.Equal(t, 72*time.Hour, Rotation{Validity: 72 * time.Hour}.Lifetime())
+	assert.Equal(t, 30*24*time.Hour, Rotation{Validity: 72 * time.Hour, ValidityDays: 30}.Lifetime())
+}
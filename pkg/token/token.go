@@ -3,11 +3,32 @@ package token
 import "time"
 
 type Token struct {
+	// ID identifies the token in its source.
+	ID          string
 	Name        string
 	Description string
 	Scopes      []string
 	Type        string
 	Owner       string
-	Value       string
-	Expiration  time.Time
+	// Username is stored next to the value, for credentials consisting of both like deploy tokens.
+	Username   string
+	Value      string
+	Expiration time.Time
+	// Created derives the expiration of tokens that never expire, like pipeline trigger tokens.
+	Created time.Time
+	// LastUsed is zero if the token was never used or its source doesn't track usage.
+	LastUsed time.Time
+	// LastUsedIPs are the IP addresses the token was last used from, if reported by the source.
+	LastUsedIPs []string
+	// Replaces is the previous token of an emulated rotation, it is revoked once this token is stored in the vault.
+	Replaces *Token
+}
+
+// LastActivity returns when the token was last used, or created if it was never used.
+// It is zero if the source tracks neither.
+func (t *Token) LastActivity() time.Time {
+	if !t.LastUsed.IsZero() {
+		return t.LastUsed
+	}
+	return t.Created
 }
@@ -2,10 +2,14 @@ package toop
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/hamba/pkg/v2/errors"
+	"gitlab.com/sickit/token-operator/pkg/backend"
 	"gitlab.com/sickit/token-operator/pkg/source"
 	"gitlab.com/sickit/token-operator/pkg/token"
+	"gitlab.com/sickit/token-operator/pkg/vault"
 )
 
 const (
@@ -13,20 +17,91 @@ const (
 	ErrMissingTokenDefinition = errors.Error("missing token definition")
 	ErrMissingTokenOwner      = errors.Error("missing token owner for source")
 	ErrMissingTokenRole       = errors.Error("missing token role for source")
+	ErrMissingTokenScopes     = errors.Error("missing token scopes for source")
+	ErrInvalidStrategy        = errors.Error("invalid rotation strategy, expected rotate or overlap")
+	ErrInvalidGracePeriod     = errors.Error("overlap rotation requires a grace_period shorter than rotate_before")
+	ErrUnsupportedOverlap     = errors.Error("overlap rotation is not supported for source type")
+	ErrInvalidRecovery        = errors.Error("invalid recovery policy, expected recreate or fail")
+	ErrInvalidTimezone        = errors.Error("invalid source timezone")
+	ErrInvalidExpiryWarning   = errors.Error("invalid source expiry_warning, expected a positive duration")
+	ErrInvalidConnection      = errors.Error("source connection requires url and token_env")
+	ErrUnknownConnection      = errors.Error("unknown source connection")
+	ErrInvalidSourceType      = errors.Error("invalid source type, expected gitlab or gitea")
+	ErrMissingUsername        = errors.Error("gitea source requires a username")
+	ErrUnsupportedTokenType   = errors.Error("token type is not supported by the source type")
+	ErrUnsupportedDeletion    = errors.Error("state deleted is not supported for source type")
+	ErrUnsupportedRevocation  = errors.Error("revoke_inactive is not supported for source type")
+	ErrUnknownTemplate        = errors.Error("unknown token template")
+	ErrEmptyMatrix            = errors.Error("generator matrix has no owners or names")
+	ErrMissingResolver        = errors.Error("generator matrix requires a source to resolve projects or groups")
+	ErrMissingVaultPath       = errors.Error("missing vault path")
+	ErrMissingVaultField      = errors.Error("missing vault field")
 )
 
 type Config struct {
-	Tokens          []token.Config  `yaml:"tokens" validate:"required"`
+	APIVersion      string          `yaml:"apiVersion" validate:"required"`
+	Tokens          []token.Config  `yaml:"tokens"`
 	DefaultRotation *token.Rotation `yaml:"default_rotation,omitempty"`
 	DryRun          bool            `yaml:"dry_run,omitempty"`
 	ForceRotate     bool            `yaml:"force_rotate,omitempty"`
 	License         string          `yaml:"license,omitempty"`
+	StatusFile      string          `yaml:"status_file,omitempty"`
 	Source          Source          `yaml:"source,omitempty"`
 	Vault           Vault           `yaml:"vault,omitempty"`
+
+	// Connections are additional named sources, tokens choose one with source.connection.
+	Connections map[string]Source `yaml:"connections,omitempty"`
+
+	// Templates are partial token definitions, merged into tokens referencing them by name.
+	Templates map[string]map[string]any `yaml:"templates,omitempty"`
+	// Generators expand one token definition into multiple tokens.
+	Generators []Generator `yaml:"generators,omitempty"`
 }
 
 type Source struct {
-	Url string `yaml:"url"`
+	// Type is the kind of instance, gitlab (default) or gitea for Gitea and Forgejo.
+	Type      string            `yaml:"type,omitempty"`
+	Url       string            `yaml:"url"`
+	RateLimit backend.RateLimit `yaml:"rate_limit,omitempty"`
+	// Timezone of the GitLab instance as IANA name, expiry dates are calendar dates in it. The default is UTC.
+	Timezone string `yaml:"timezone,omitempty"`
+	// ExpiryWarning is how long before its expiry a warning is logged for the source token. The default is 30 days.
+	ExpiryWarning time.Duration `yaml:"expiry_warning,omitempty"`
+	// TokenEnv is the environment variable holding the token of a connection, the default source uses --source.token.
+	TokenEnv string `yaml:"token_env,omitempty"`
+	// Username authenticates a gitea source, its token is used as password.
+	Username string `yaml:"username,omitempty"`
+}
+
+// IsGitea returns true if the source is a Gitea or Forgejo instance.
+func (s Source) IsGitea() bool {
+	return s.Type == source.BackendGitea
+}
+
+func (s Source) validate() error {
+	switch s.Type {
+	case "", source.BackendGitLab:
+	case source.BackendGitea:
+		if s.Username == "" {
+			return ErrMissingUsername
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidSourceType, s.Type)
+	}
+	if s.ExpiryWarning < 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidExpiryWarning, s.ExpiryWarning)
+	}
+	_, err := s.Location()
+	return err
+}
+
+// Location returns the timezone of the source.
+func (s Source) Location() (*time.Location, error) {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTimezone, err)
+	}
+	return loc, nil
 }
 
 type Vault struct {
@@ -34,15 +109,112 @@ type Vault struct {
 	Type string `yaml:"type"`
 }
 
+// validate checks the vault of a token has the attributes required by the vault type, 1password is the default.
+func (v Vault) validate(vlt token.Vault) error {
+	switch v.Type {
+	case vault.TypeGitLab:
+		// the variable key is the item, instance variables have no project or group
+		if vlt.Path == "" && vlt.Variable.OwnerType != token.VariableOwnerInstance {
+			return ErrMissingVaultPath
+		}
+	default:
+		if vlt.Path == "" {
+			return ErrMissingVaultPath
+		}
+		if vlt.Field == "" {
+			return ErrMissingVaultField
+		}
+	}
+	return nil
+}
+
+// tokenRef describes a token for error messages, with the config entry, template or generator it originates from.
+func tokenRef(t token.Config) string {
+	origin := []string{}
+	if t.Origin != "" {
+		origin = append(origin, t.Origin)
+	}
+	if t.Template != "" {
+		origin = append(origin, fmt.Sprintf("template '%s'", t.Template))
+	}
+
+	ref := fmt.Sprintf("source '%s'", t.Source.Name)
+	if len(origin) > 0 {
+		ref += fmt.Sprintf(" (%s)", strings.Join(origin, ", "))
+	}
+	return ref
+}
+
 // Validate checks logical/structural requirements that can't be validated with go-yaml.
 func (c *Config) Validate() error {
-	if len(c.Tokens) == 0 {
+	if len(c.Tokens) == 0 && len(c.Generators) == 0 {
 		return ErrMissingTokenDefinition
 	}
+	if err := c.Source.validate(); err != nil {
+		return err
+	}
+	for name, conn := range c.Connections {
+		if conn.Url == "" || conn.TokenEnv == "" {
+			return fmt.Errorf("invalid config for connection '%s': %w", name, ErrInvalidConnection)
+		}
+		if err := conn.validate(); err != nil {
+			return fmt.Errorf("invalid config for connection '%s': %w", name, err)
+		}
+	}
 
 	for _, t := range c.Tokens {
 		if t.Rotation == nil && c.DefaultRotation == nil {
-			return fmt.Errorf("invalid config for token source '%s': %w", t.Source.Name, ErrMissingRotation)
+			return fmt.Errorf("invalid config for token %s: %w", tokenRef(t), ErrMissingRotation)
+		}
+
+		if err := c.Vault.validate(t.Vault); err != nil {
+			return fmt.Errorf("invalid config for token %s: %w", tokenRef(t), err)
+		}
+
+		conn, ok := c.Connections[t.Source.Connection]
+		if t.Source.Connection != "" && !ok {
+			return fmt.Errorf("invalid config for token %s: %w: %s", tokenRef(t), ErrUnknownConnection, t.Source.Connection)
+		}
+		if t.Source.Connection == "" {
+			conn = c.Source
+		}
+		// Gitea and Forgejo only have personal access tokens
+		if conn.IsGitea() && t.Source.Type != source.TypePersonal {
+			return fmt.Errorf("invalid config for token %s: %w: %s", tokenRef(t), ErrUnsupportedTokenType, t.Source.Type)
+		}
+
+		// runners and oauth applications can't be deleted without removing the whole runner or application
+		if t.State == token.TokenStateDeleted && (t.Source.Type == source.TypeRunner || t.Source.Type == source.TypeOAuthApplication) {
+			return fmt.Errorf("invalid config for token %s: %w: %s", tokenRef(t), ErrUnsupportedDeletion, t.Source.Type)
+		}
+
+		switch t.Recovery {
+		case "", token.RecoveryPolicyRecreate, token.RecoveryPolicyFail:
+		default:
+			return fmt.Errorf("invalid config for token %s: %w: %s", tokenRef(t), ErrInvalidRecovery, t.Recovery)
+		}
+
+		rotation := t.Rotation
+		if rotation == nil {
+			rotation = c.DefaultRotation
+		}
+		// revoking a runner or oauth application secret would remove the whole runner or application
+		if rotation.RevokeInactive && (t.Source.Type == source.TypeRunner || t.Source.Type == source.TypeOAuthApplication) {
+			return fmt.Errorf("invalid config for token %s: %w: %s", tokenRef(t), ErrUnsupportedRevocation, t.Source.Type)
+		}
+		switch rotation.Strategy {
+		case "", token.RotationStrategyRotate:
+		case token.RotationStrategyOverlap:
+			// the previous token has to stay valid until the grace period has passed
+			if rotation.GracePeriod <= 0 || rotation.GracePeriod > rotation.RotateBefore {
+				return fmt.Errorf("invalid config for token %s: %w", tokenRef(t), ErrInvalidGracePeriod)
+			}
+			// runner authentication tokens and oauth application secrets can only be reset
+			if t.Source.Type == source.TypeRunner || t.Source.Type == source.TypeOAuthApplication {
+				return fmt.Errorf("invalid config for token %s: %w", tokenRef(t), ErrUnsupportedOverlap)
+			}
+		default:
+			return fmt.Errorf("invalid config for token %s: %w: %s", tokenRef(t), ErrInvalidStrategy, rotation.Strategy)
 		}
 
 		// Group and Project tokens require "owner" and "role"
@@ -51,10 +223,24 @@ func (c *Config) Validate() error {
 			fallthrough
 		case source.TypeProject:
 			if t.Source.Owner == "" {
-				return fmt.Errorf("invalid config for token source '%s': %w", t.Source.Name, ErrMissingTokenOwner)
+				return fmt.Errorf("invalid config for token %s: %w", tokenRef(t), ErrMissingTokenOwner)
 			}
 			if t.Source.Role == "" {
-				return fmt.Errorf("invalid config for token source '%s': %w", t.Source.Name, ErrMissingTokenRole)
+				return fmt.Errorf("invalid config for token %s: %w", tokenRef(t), ErrMissingTokenRole)
+			}
+		// Impersonation, deploy, trigger and service account tokens and deploy keys require "owner"
+		case source.TypeImpersonation, source.TypeDeploy, source.TypeTrigger, source.TypeServiceAccount, source.TypeDeployKey:
+			if t.Source.Owner == "" {
+				return fmt.Errorf("invalid config for token %s: %w", tokenRef(t), ErrMissingTokenOwner)
+			}
+		}
+
+		// Trigger and runner tokens, oauth application secrets and deploy keys have no scopes
+		switch t.Source.Type {
+		case source.TypeTrigger, source.TypeRunner, source.TypeOAuthApplication, source.TypeDeployKey:
+		default:
+			if len(t.Source.Scopes) == 0 {
+				return fmt.Errorf("invalid config for token %s: %w", tokenRef(t), ErrMissingTokenScopes)
 			}
 		}
 	}
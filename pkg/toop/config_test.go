@@ -9,6 +9,7 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/goccy/go-yaml"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gitlab.com/sickit/token-operator/pkg/source"
 	"gitlab.com/sickit/token-operator/pkg/token"
 )
@@ -25,6 +26,7 @@ func TestParseConfig(t *testing.T) {
 	)
 	err := dec.Decode(&config)
 	assert.Nil(t, err)
+	assert.Equal(t, 720*time.Hour, config.Source.ExpiryWarning)
 }
 
 func TestConfig_Validate(t *testing.T) {
@@ -91,6 +93,112 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "trigger token without scopes",
+			fields: fields{
+				DefaultRotation: &token.Rotation{
+					RotateBefore: 48 * time.Hour,
+					Validity:     76 * time.Hour,
+				},
+				Tokens: []token.Config{
+					{
+						Name:  "trigger-token",
+						State: token.TokenStateActive,
+						Source: token.Source{
+							Name:  "trigger-token",
+							Type:  source.TypeTrigger,
+							Owner: "group/project",
+						},
+						Vault: token.Vault{
+							Path:  "myVault",
+							Item:  "some-token",
+							Field: "password",
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "personal token without scopes",
+			fields: fields{
+				DefaultRotation: &token.Rotation{
+					RotateBefore: 48 * time.Hour,
+					Validity:     76 * time.Hour,
+				},
+				Tokens: []token.Config{
+					{
+						Name:  "personal-token",
+						State: token.TokenStateActive,
+						Source: token.Source{
+							Name: "personal-token",
+							Type: source.TypePersonal,
+						},
+						Vault: token.Vault{
+							Path:  "myVault",
+							Item:  "some-token",
+							Field: "password",
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "overlap rotation without grace period",
+			fields: fields{
+				DefaultRotation: &token.Rotation{
+					RotateBefore: 48 * time.Hour,
+					Validity:     76 * time.Hour,
+					Strategy:     token.RotationStrategyOverlap,
+				},
+				Tokens: []token.Config{
+					{
+						Name:  "personal-token",
+						State: token.TokenStateActive,
+						Source: token.Source{
+							Name:   "personal-token",
+							Scopes: []string{"test-scope"},
+							Type:   source.TypePersonal,
+						},
+						Vault: token.Vault{
+							Path:  "myVault",
+							Item:  "some-token",
+							Field: "password",
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "overlap rotation with grace period",
+			fields: fields{
+				DefaultRotation: &token.Rotation{
+					RotateBefore: 48 * time.Hour,
+					Validity:     76 * time.Hour,
+					Strategy:     token.RotationStrategyOverlap,
+					GracePeriod:  24 * time.Hour,
+				},
+				Tokens: []token.Config{
+					{
+						Name:  "personal-token",
+						State: token.TokenStateActive,
+						Source: token.Source{
+							Name:   "personal-token",
+							Scopes: []string{"test-scope"},
+							Type:   source.TypePersonal,
+						},
+						Vault: token.Vault{
+							Path:  "myVault",
+							Item:  "some-token",
+							Field: "password",
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
 		{
 			name:    "no config",
 			fields:  fields{},
@@ -163,6 +271,71 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "impersonation token without owner",
+			fields: fields{
+				DefaultRotation: &token.Rotation{
+					RotateBefore: 48 * time.Hour,
+					Validity:     76 * time.Hour,
+				},
+				Tokens: []token.Config{
+					{
+						Name:  "impersonation-token",
+						State: token.TokenStateActive,
+						Source: token.Source{
+							Name: "impersonation-token",
+							Type: source.TypeImpersonation,
+						},
+						Vault: token.Vault{},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "deploy token without owner",
+			fields: fields{
+				DefaultRotation: &token.Rotation{
+					RotateBefore: 48 * time.Hour,
+					Validity:     76 * time.Hour,
+				},
+				Tokens: []token.Config{
+					{
+						Name:  "deploy-token",
+						State: token.TokenStateActive,
+						Source: token.Source{
+							Name: "deploy-token",
+							Type: source.TypeDeploy,
+						},
+						Vault: token.Vault{},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "service account token without owner",
+			fields: fields{
+				DefaultRotation: &token.Rotation{
+					RotateBefore: 48 * time.Hour,
+					Validity:     76 * time.Hour,
+				},
+				Tokens: []token.Config{
+					{
+						Name:  "ci-token",
+						State: token.TokenStateActive,
+						Source: token.Source{
+							Name:   "ci-token",
+							Type:   source.TypeServiceAccount,
+							Group:  "group",
+							Scopes: []string{"api"},
+						},
+						Vault: token.Vault{},
+					},
+				},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -177,3 +350,255 @@ func TestConfig_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_ValidateDeletion(t *testing.T) {
+	tests := []struct {
+		name    string
+		typ     string
+		wantErr error
+	}{
+		{name: "personal token", typ: source.TypePersonal},
+		{name: "runner", typ: source.TypeRunner, wantErr: ErrUnsupportedDeletion},
+		{name: "oauth application", typ: source.TypeOAuthApplication, wantErr: ErrUnsupportedDeletion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{
+				DefaultRotation: &token.Rotation{RotateBefore: 48 * time.Hour, Validity: 76 * time.Hour},
+				Tokens: []token.Config{
+					{
+						Name:   "deleted-token",
+						State:  token.TokenStateDeleted,
+						Source: token.Source{Name: "deleted-token", Type: tt.typ, Scopes: []string{"api"}},
+						Vault:  token.Vault{Path: "myVault", Item: "deleted-token", Field: "password"},
+					},
+				},
+			}
+
+			err := c.Validate()
+
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestConfig_ValidateRevokeInactive(t *testing.T) {
+	tests := []struct {
+		name    string
+		typ     string
+		wantErr error
+	}{
+		{name: "personal token", typ: source.TypePersonal},
+		{name: "deploy key", typ: source.TypeDeployKey},
+		{name: "runner", typ: source.TypeRunner, wantErr: ErrUnsupportedRevocation},
+		{name: "oauth application", typ: source.TypeOAuthApplication, wantErr: ErrUnsupportedRevocation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{
+				DefaultRotation: &token.Rotation{RotateBefore: 48 * time.Hour, Validity: 76 * time.Hour, InactiveAfter: 30 * 24 * time.Hour, RevokeInactive: true},
+				Tokens: []token.Config{
+					{
+						Name:   "idle-token",
+						State:  token.TokenStateActive,
+						Source: token.Source{Name: "idle-token", Type: tt.typ, Owner: "group/project", Scopes: []string{"api"}},
+						Vault:  token.Vault{Path: "myVault", Item: "idle-token", Field: "password"},
+					},
+				},
+			}
+
+			err := c.Validate()
+
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestConfig_ValidateVault(t *testing.T) {
+	tests := []struct {
+		name      string
+		vaultType string
+		vault     token.Vault
+		wantErr   error
+	}{
+		{name: "1password", vault: token.Vault{Path: "myVault", Item: "some-token", Field: "password"}},
+		{name: "1password without path", vault: token.Vault{Item: "some-token", Field: "password"}, wantErr: ErrMissingVaultPath},
+		{name: "1password without field", vault: token.Vault{Path: "myVault", Item: "some-token"}, wantErr: ErrMissingVaultField},
+		{name: "gitlab without field", vaultType: "gitlab", vault: token.Vault{Path: "group/project", Item: "SOME_TOKEN"}},
+		{name: "gitlab without path", vaultType: "gitlab", vault: token.Vault{Item: "SOME_TOKEN"}, wantErr: ErrMissingVaultPath},
+		{
+			name:      "gitlab instance variable without path",
+			vaultType: "gitlab",
+			vault:     token.Vault{Item: "SOME_TOKEN", Variable: token.Variable{OwnerType: token.VariableOwnerInstance}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{
+				Vault: Vault{Type: tt.vaultType},
+				Tokens: []token.Config{
+					{
+						Name:     "some-token",
+						State:    token.TokenStateActive,
+						Rotation: &token.Rotation{RotateBefore: 48 * time.Hour, Validity: 76 * time.Hour},
+						Source:   token.Source{Name: "some-token", Type: source.TypePersonal, Scopes: []string{"api"}},
+						Vault:    tt.vault,
+					},
+				},
+			}
+
+			err := c.Validate()
+
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestConfig_ValidateConnections(t *testing.T) {
+	tokens := []token.Config{
+		{
+			Name:     "personal-token",
+			State:    token.TokenStateActive,
+			Rotation: &token.Rotation{RotateBefore: 48 * time.Hour, Validity: 76 * time.Hour},
+			Source: token.Source{
+				Name:       "personal-token",
+				Scopes:     []string{"api"},
+				Type:       source.TypePersonal,
+				Connection: "self-managed",
+			},
+			Vault: token.Vault{Path: "myVault", Item: "personal-token", Field: "password"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		connections map[string]Source
+		wantErr     error
+	}{
+		{
+			name:        "known connection",
+			connections: map[string]Source{"self-managed": {Url: "https://gitlab.example.com/api/v4", TokenEnv: "EXAMPLE_TOKEN"}},
+		},
+		{
+			name:    "unknown connection",
+			wantErr: ErrUnknownConnection,
+		},
+		{
+			name:        "connection without token",
+			connections: map[string]Source{"self-managed": {Url: "https://gitlab.example.com/api/v4"}},
+			wantErr:     ErrInvalidConnection,
+		},
+		{
+			name:        "connection with invalid timezone",
+			connections: map[string]Source{"self-managed": {Url: "https://gitlab.example.com/api/v4", TokenEnv: "EXAMPLE_TOKEN", Timezone: "Mars/Olympus"}},
+			wantErr:     ErrInvalidTimezone,
+		},
+		{
+			name:        "connection with expiry warning",
+			connections: map[string]Source{"self-managed": {Url: "https://gitlab.example.com/api/v4", TokenEnv: "EXAMPLE_TOKEN", ExpiryWarning: 14 * 24 * time.Hour}},
+		},
+		{
+			name:        "connection with negative expiry warning",
+			connections: map[string]Source{"self-managed": {Url: "https://gitlab.example.com/api/v4", TokenEnv: "EXAMPLE_TOKEN", ExpiryWarning: -time.Hour}},
+			wantErr:     ErrInvalidExpiryWarning,
+		},
+		{
+			name:        "gitea connection",
+			connections: map[string]Source{"self-managed": {Type: "gitea", Url: "https://gitea.example.com/api/v1", TokenEnv: "EXAMPLE_PASSWORD", Username: "admin"}},
+		},
+		{
+			name:        "gitea connection without username",
+			connections: map[string]Source{"self-managed": {Type: "gitea", Url: "https://gitea.example.com/api/v1", TokenEnv: "EXAMPLE_PASSWORD"}},
+			wantErr:     ErrMissingUsername,
+		},
+		{
+			name:        "connection with invalid type",
+			connections: map[string]Source{"self-managed": {Type: "github", Url: "https://api.github.com", TokenEnv: "EXAMPLE_TOKEN"}},
+			wantErr:     ErrInvalidSourceType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{Tokens: tokens, Connections: tt.connections}
+
+			err := c.Validate()
+
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestConfig_ValidateGiteaTokenType(t *testing.T) {
+	c := &Config{
+		Source: Source{Type: "gitea", Username: "admin"},
+		Tokens: []token.Config{
+			{
+				Name:     "deploy-token",
+				State:    token.TokenStateActive,
+				Rotation: &token.Rotation{RotateBefore: 48 * time.Hour, Validity: 76 * time.Hour},
+				Source:   token.Source{Name: "deploy-token", Scopes: []string{"read_repository"}, Type: source.TypeDeploy, Owner: "group/project"},
+				Vault:    token.Vault{Path: "myVault", Item: "deploy-token", Field: "password"},
+			},
+		},
+	}
+
+	err := c.Validate()
+
+	assert.ErrorIs(t, err, ErrUnsupportedTokenType)
+}
+
+func TestConfig_ValidateNamesEntry(t *testing.T) {
+	config, err := Decode([]byte(`apiVersion: v1
+default_rotation:
+  rotate_before: 24h
+  validity: 48h
+tokens:
+  - name: renovate
+    state: active
+    source:
+      name: renovate
+      type: personal
+      scopes: ["api"]
+    vault:
+      path: "renovate"
+      item: "renovate"
+      field: "password"
+  - name: bot
+    state: active
+    source:
+      name: bot
+      type: project
+      owner: group/project
+      scopes: ["api"]
+    vault:
+      path: "renovate"
+      item: "bot"
+      field: "password"
+`))
+	require.NoError(t, err)
+
+	err = config.Validate()
+
+	assert.ErrorIs(t, err, ErrMissingTokenRole)
+	assert.ErrorContains(t, err, "source 'bot' (tokens[1])")
+}
@@ -0,0 +1,185 @@
+package toop
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Generator expands one token definition across a matrix of owners and names.
+type Generator struct {
+	Name   string         `yaml:"name" validate:"required"`
+	Matrix Matrix         `yaml:"matrix"`
+	Token  map[string]any `yaml:"token" validate:"required"` // token definition, string values may use {{ .Owner }} and {{ .Name }}
+}
+
+// Matrix defines the values a generator is expanded across.
+// Owners and names are combined, projects and groups are glob patterns resolved at runtime and added to the owners.
+type Matrix struct {
+	Owners   []string `yaml:"owners,omitempty"`
+	Names    []string `yaml:"names,omitempty"`
+	Projects []string `yaml:"projects,omitempty"` // e.g. "my-group/*", matched against the full project path
+	Groups   []string `yaml:"groups,omitempty"`   // e.g. "my-group/*", matched against the full group path
+}
+
+// MatrixValue is a single combination of a matrix, available in token definitions of a generator.
+type MatrixValue struct {
+	Owner string
+	Name  string
+}
+
+// Resolver resolves the glob patterns of a generator matrix at runtime.
+type Resolver interface {
+	ResolveProjects(pattern string) ([]string, error)
+	ResolveGroups(pattern string) ([]string, error)
+}
+
+// Resolvers returns the Resolver of generators using a source connection, the empty name is the default source.
+// A nil Resolver skips the generators of the connection, e.g. if its source is not available.
+type Resolvers func(connection string) (Resolver, error)
+
+// connection returns the source connection of the generated tokens, set in the token definition or its template.
+func (g *Generator) connection(templates map[string]any) string {
+	src, _ := mergeTemplate(g.Token, templates)["source"].(map[string]any)
+	conn, _ := src["connection"].(string)
+	return conn
+}
+
+// runtime returns true if the matrix needs a Resolver.
+func (m *Matrix) runtime() bool {
+	return len(m.Projects) > 0 || len(m.Groups) > 0
+}
+
+// values returns all combinations of the matrix.
+func (m *Matrix) values(resolver Resolver) ([]MatrixValue, error) {
+	owners := append([]string{}, m.Owners...)
+	if m.runtime() && resolver == nil {
+		return nil, ErrMissingResolver
+	}
+	for _, pattern := range m.Projects {
+		projects, err := resolver.ResolveProjects(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve projects '%s': %w", pattern, err)
+		}
+		owners = append(owners, projects...)
+	}
+	for _, pattern := range m.Groups {
+		groups, err := resolver.ResolveGroups(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve groups '%s': %w", pattern, err)
+		}
+		owners = append(owners, groups...)
+	}
+
+	if len(owners) == 0 && len(m.Names) == 0 {
+		return nil, ErrEmptyMatrix
+	}
+
+	names := m.Names
+	if len(owners) == 0 {
+		owners = []string{""}
+	}
+	if len(names) == 0 {
+		names = []string{""}
+	}
+
+	values := make([]MatrixValue, 0, len(owners)*len(names))
+	for _, owner := range owners {
+		for _, name := range names {
+			values = append(values, MatrixValue{Owner: owner, Name: name})
+		}
+	}
+
+	return values, nil
+}
+
+// Generate expands all generators into tokens. Generators with projects or groups in their matrix are resolved
+// with the resolver of their source connection, they are skipped if resolvers is nil or returns a nil resolver.
+func (c *Config) Generate(resolvers Resolvers) error {
+	templates := make(map[string]any, len(c.Templates))
+	for name, tmpl := range c.Templates {
+		templates[name] = tmpl
+	}
+
+	validate := validator.New()
+	for i, gen := range c.Generators {
+		var resolver Resolver
+		if gen.Matrix.runtime() {
+			if resolvers == nil {
+				continue
+			}
+			var err error
+			if resolver, err = resolvers(gen.connection(templates)); err != nil {
+				return fmt.Errorf("invalid generator generators[%d] '%s': %w", i, gen.Name, err)
+			}
+			if resolver == nil {
+				continue
+			}
+		}
+
+		values, err := gen.Matrix.values(resolver)
+		if err != nil {
+			return fmt.Errorf("invalid generator generators[%d] '%s': %w", i, gen.Name, err)
+		}
+
+		for _, value := range values {
+			rendered, err := render(gen.Token, value)
+			if err != nil {
+				return fmt.Errorf("invalid generator generators[%d] '%s' for %+v: %w", i, gen.Name, value, err)
+			}
+
+			entry, _ := rendered.(map[string]any)
+			_, cfg, err := expandEntry(entry, templates, validate)
+			if err != nil {
+				return fmt.Errorf("invalid generator generators[%d] '%s' for %+v: %w", i, gen.Name, value, err)
+			}
+			cfg.Origin = fmt.Sprintf("generators[%d] '%s' for %+v", i, gen.Name, value)
+			c.Tokens = append(c.Tokens, cfg)
+		}
+	}
+
+	return nil
+}
+
+// render executes all string values of a token definition as template with the matrix value.
+func render(v any, value MatrixValue) (any, error) {
+	switch val := v.(type) {
+	case map[string]any:
+		rendered := make(map[string]any, len(val))
+		for k, item := range val {
+			r, err := render(item, value)
+			if err != nil {
+				return nil, err
+			}
+			rendered[k] = r
+		}
+		return rendered, nil
+	case []any:
+		rendered := make([]any, 0, len(val))
+		for _, item := range val {
+			r, err := render(item, value)
+			if err != nil {
+				return nil, err
+			}
+			rendered = append(rendered, r)
+		}
+		return rendered, nil
+	case string:
+		if !strings.Contains(val, "{{") {
+			return val, nil
+		}
+		tmpl, err := template.New("").Option("missingkey=error").Parse(val)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse '%s': %w", val, err)
+		}
+		sb := strings.Builder{}
+		if err = tmpl.Execute(&sb, value); err != nil {
+			return nil, fmt.Errorf("failed to render '%s': %w", val, err)
+		}
+		return sb.String(), nil
+	}
+
+	return v, nil
+}
@@ -0,0 +1,210 @@
+package toop
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const generatorConfig = `apiVersion: v1
+default_rotation:
+  rotate_before: 24h
+  validity: 48h
+templates:
+  renovate:
+    state: active
+    source:
+      type: project
+      role: reporter
+      scopes: ["read_api"]
+    vault:
+      path: "renovate"
+      field: "password"
+generators:
+  - name: renovate
+    matrix:
+      owners: ["group/a", "group/b"]
+    token:
+      name: "renovate-{{ .Owner }}"
+      template: renovate
+      source:
+        name: "renovate"
+        owner: "{{ .Owner }}"
+      vault:
+        item: "renovate {{ .Owner }}"
+  - name: bots
+    matrix:
+      projects: ["group/*"]
+      names: ["bot-1", "bot-2"]
+    token:
+      name: "{{ .Name }}@{{ .Owner }}"
+      template: renovate
+      source:
+        name: "{{ .Name }}"
+        owner: "{{ .Owner }}"
+      vault:
+        item: "{{ .Name }} {{ .Owner }}"
+`
+
+type mockResolver struct{}
+
+func (r mockResolver) ResolveProjects(_ string) ([]string, error) {
+	return []string{"group/c"}, nil
+}
+
+func (r mockResolver) ResolveGroups(_ string) ([]string, error) {
+	return nil, nil
+}
+
+// resolvers returns the mock resolver for every connection.
+func resolvers(string) (Resolver, error) {
+	return mockResolver{}, nil
+}
+
+// connectionResolver resolves projects to a project of its connection.
+type connectionResolver struct {
+	mockResolver
+	connection string
+}
+
+func (r connectionResolver) ResolveProjects(_ string) ([]string, error) {
+	return []string{r.connection + "/c"}, nil
+}
+
+func TestConfig_Generate(t *testing.T) {
+	config, err := Decode([]byte(generatorConfig))
+	require.NoError(t, err)
+	require.NoError(t, config.Validate())
+
+	// without resolver, only static generators are expanded
+	require.NoError(t, config.Generate(nil))
+	require.Len(t, config.Tokens, 2)
+	assert.Equal(t, "renovate-group/a", config.Tokens[0].Name)
+	assert.Equal(t, "group/a", config.Tokens[0].Source.Owner)
+	assert.Equal(t, "renovate group/a", config.Tokens[0].Vault.Item)
+	assert.Equal(t, "reporter", config.Tokens[0].Source.Role)
+	assert.Equal(t, "renovate-group/b", config.Tokens[1].Name)
+
+	config, err = Decode([]byte(generatorConfig))
+	require.NoError(t, err)
+	require.NoError(t, config.Generate(resolvers))
+	require.Len(t, config.Tokens, 4)
+	assert.Equal(t, "bot-1@group/c", config.Tokens[2].Name)
+	assert.Equal(t, "bot-2", config.Tokens[3].Source.Name)
+	assert.Equal(t, "bot-2 group/c", config.Tokens[3].Vault.Item)
+	require.NoError(t, config.Validate())
+}
+
+// generatedToken is a minimal token definition of a generator, with additional source attributes.
+func generatedToken(source map[string]any) map[string]any {
+	src := map[string]any{"name": "bot", "type": "project"}
+	for k, v := range source {
+		src[k] = v
+	}
+	return map[string]any{"name": "{{ .Owner }}", "state": "active", "source": src, "vault": map[string]any{"item": "bot"}}
+}
+
+func TestConfig_GenerateConnection(t *testing.T) {
+	tests := []struct {
+		name     string
+		template map[string]any
+		token    map[string]any
+		want     string
+	}{
+		{
+			name:  "default source",
+			token: generatedToken(nil),
+			want:  "/c",
+		},
+		{
+			name:  "connection of the token",
+			token: generatedToken(map[string]any{"connection": "other"}),
+			want:  "other/c",
+		},
+		{
+			name:     "connection of the template",
+			template: map[string]any{"source": map[string]any{"connection": "other"}},
+			token:    deepMerge(generatedToken(nil), map[string]any{"template": "tmpl"}),
+			want:     "other/c",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{
+				Templates:  map[string]map[string]any{"tmpl": tt.template},
+				Generators: []Generator{{Name: "gen", Matrix: Matrix{Projects: []string{"*"}}, Token: tt.token}},
+			}
+
+			err := c.Generate(func(connection string) (Resolver, error) {
+				return connectionResolver{connection: connection}, nil
+			})
+
+			require.NoError(t, err)
+			require.Len(t, c.Tokens, 1)
+			assert.Equal(t, tt.want, c.Tokens[0].Name)
+		})
+	}
+}
+
+func TestConfig_GenerateResolverErrors(t *testing.T) {
+	static := generatedToken(nil)
+	static["name"] = "{{ .Name }}"
+	c := &Config{Generators: []Generator{
+		{Name: "static", Matrix: Matrix{Names: []string{"a"}}, Token: static},
+		{Name: "runtime", Matrix: Matrix{Groups: []string{"*"}}, Token: generatedToken(nil)},
+	}}
+
+	// a nil resolver skips runtime generators
+	require.NoError(t, c.Generate(func(string) (Resolver, error) { return nil, nil }))
+	assert.Len(t, c.Tokens, 1)
+
+	c.Tokens = nil
+	err := c.Generate(func(string) (Resolver, error) { return nil, ErrMissingResolver })
+	assert.ErrorIs(t, err, ErrMissingResolver)
+	assert.ErrorContains(t, err, "generators[1] 'runtime'")
+}
+
+func TestConfig_ValidateNamesOrigin(t *testing.T) {
+	config, err := Decode([]byte(generatorConfig))
+	require.NoError(t, err)
+	config.Templates["renovate"]["source"] = map[string]any{"type": "project", "scopes": []any{"read_api"}}
+	config.Generators = config.Generators[:1]
+	require.NoError(t, config.Generate(nil))
+
+	err = config.Validate()
+
+	assert.ErrorIs(t, err, ErrMissingTokenRole)
+	assert.ErrorContains(t, err, "source 'renovate' (generators[0] 'renovate' for {Owner:group/a Name:}, template 'renovate')")
+}
+
+func TestConfig_GenerateErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		generator Generator
+		wantErr   error
+	}{
+		{
+			name:      "empty matrix",
+			generator: Generator{Name: "empty", Token: map[string]any{}},
+			wantErr:   ErrEmptyMatrix,
+		},
+		{
+			name: "unknown template",
+			generator: Generator{
+				Name:   "unknown",
+				Matrix: Matrix{Names: []string{"a"}},
+				Token:  map[string]any{"name": "{{ .Name }}", "template": "nope"},
+			},
+			wantErr: ErrUnknownTemplate,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{Generators: []Generator{tt.generator}}
+			assert.ErrorIs(t, c.Generate(nil), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package toop
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/hamba/pkg/v2/errors"
+)
+
+// RepositoryScheme prefixes a configuration stored in a GitLab repository.
+const RepositoryScheme = "gitlab://"
+
+const (
+	// minSHALength is the shortest abbreviated commit SHA accepted as pin.
+	minSHALength = 7
+	// shaLength is the length of a full commit SHA.
+	shaLength = 40
+)
+
+const (
+	ErrInvalidRepositoryRef   = errors.Error("invalid repository reference, expected gitlab://<project>/-/<path>[?ref=<ref>][&sha=<commit>]")
+	ErrConfigRevisionMismatch = errors.Error("config revision does not match pinned commit")
+	ErrInvalidRepositorySHA   = errors.Error("invalid repository reference, sha must be a commit SHA of 7 to 40 hexadecimal characters")
+)
+
+// RepositoryRef references a configuration file in a GitLab repository,
+// in the form gitlab://group/project/-/path/to/tocli.yaml?ref=main&sha=0123abc.
+type RepositoryRef struct {
+	Project string
+	Path    string
+	// Ref is a branch, tag or commit, the default branch if empty.
+	Ref string
+	// SHA pins the configuration to a commit. If Ref is set as well, Ref must resolve to this commit.
+	SHA string
+}
+
+// IsRepositoryRef returns true if the config location references a GitLab repository.
+func IsRepositoryRef(location string) bool {
+	return strings.HasPrefix(location, RepositoryScheme)
+}
+
+// ParseRepositoryRef parses a repository reference of a config location.
+func ParseRepositoryRef(location string) (*RepositoryRef, error) {
+	if !IsRepositoryRef(location) {
+		return nil, ErrInvalidRepositoryRef
+	}
+
+	rest, query, _ := strings.Cut(strings.TrimPrefix(location, RepositoryScheme), "?")
+	project, filePath, ok := strings.Cut(rest, "/-/")
+	if !ok || project == "" || filePath == "" {
+		return nil, ErrInvalidRepositoryRef
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRepositoryRef, err)
+	}
+	sha := values.Get("sha")
+	if sha != "" && !isCommitSHA(sha) {
+		return nil, fmt.Errorf("%w: %w: %s", ErrInvalidRepositoryRef, ErrInvalidRepositorySHA, sha)
+	}
+
+	return &RepositoryRef{
+		Project: project,
+		Path:    filePath,
+		Ref:     values.Get("ref"),
+		SHA:     sha,
+	}, nil
+}
+
+// isCommitSHA returns true if sha is a full or an unambiguously abbreviated commit SHA.
+func isCommitSHA(sha string) bool {
+	if len(sha) < minSHALength || len(sha) > shaLength {
+		return false
+	}
+	for _, c := range sha {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Revision returns the revision to fetch, the pinned commit takes precedence if no ref is given.
+func (r *RepositoryRef) Revision() string {
+	switch {
+	case r.Ref != "":
+		return r.Ref
+	case r.SHA != "":
+		return r.SHA
+	}
+	return "HEAD"
+}
+
+// Verify checks that the resolved commit matches the pinned commit, if any.
+func (r *RepositoryRef) Verify(commitID string) error {
+	if r.SHA == "" || strings.HasPrefix(commitID, r.SHA) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s resolved to %s, pinned to %s", ErrConfigRevisionMismatch, r.Revision(), commitID, r.SHA)
+}
+
+func (r *RepositoryRef) String() string {
+	return fmt.Sprintf("%s%s/-/%s@%s", RepositoryScheme, r.Project, r.Path, r.Revision())
+}
@@ -0,0 +1,100 @@
+package toop
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRepositoryRef(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		want     *RepositoryRef
+		revision string
+		wantErr  bool
+	}{
+		{
+			name:     "default branch",
+			location: "gitlab://group/project/-/tocli.yaml",
+			want:     &RepositoryRef{Project: "group/project", Path: "tocli.yaml"},
+			revision: "HEAD",
+		},
+		{
+			name:     "ref and sha",
+			location: "gitlab://group/sub/project/-/config/tocli.yaml?ref=release/1.0&sha=0123abcd",
+			want:     &RepositoryRef{Project: "group/sub/project", Path: "config/tocli.yaml", Ref: "release/1.0", SHA: "0123abcd"},
+			revision: "release/1.0",
+		},
+		{
+			name:     "sha only",
+			location: "gitlab://group/project/-/tocli.yaml?sha=0123abcd",
+			want:     &RepositoryRef{Project: "group/project", Path: "tocli.yaml", SHA: "0123abcd"},
+			revision: "0123abcd",
+		},
+		{
+			name:     "full sha",
+			location: "gitlab://group/project/-/tocli.yaml?sha=0123456789abcdef0123456789abcdef01234567",
+			want:     &RepositoryRef{Project: "group/project", Path: "tocli.yaml", SHA: "0123456789abcdef0123456789abcdef01234567"},
+			revision: "0123456789abcdef0123456789abcdef01234567",
+		},
+		{
+			name:     "too short sha",
+			location: "gitlab://group/project/-/tocli.yaml?ref=main&sha=0",
+			wantErr:  true,
+		},
+		{
+			name:     "six character sha",
+			location: "gitlab://group/project/-/tocli.yaml?sha=0123ab",
+			wantErr:  true,
+		},
+		{
+			name:     "too long sha",
+			location: "gitlab://group/project/-/tocli.yaml?sha=0123456789abcdef0123456789abcdef012345678",
+			wantErr:  true,
+		},
+		{
+			name:     "not a sha",
+			location: "gitlab://group/project/-/tocli.yaml?sha=release",
+			wantErr:  true,
+		},
+		{
+			name:     "missing path",
+			location: "gitlab://group/project",
+			wantErr:  true,
+		},
+		{
+			name:     "local file",
+			location: "./tocli.yaml",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRepositoryRef(tt.location)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidRepositoryRef)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.revision, got.Revision())
+		})
+	}
+}
+
+func TestRepositoryRef_Verify(t *testing.T) {
+	ref := &RepositoryRef{Project: "group/project", Path: "tocli.yaml", Ref: "main", SHA: "0123abcd"}
+	assert.NoError(t, ref.Verify("0123abcdef4567"))
+	assert.ErrorIs(t, ref.Verify("fedcba98"), ErrConfigRevisionMismatch)
+	assert.ErrorIs(t, ref.Verify("0123abc0ef4567"), ErrConfigRevisionMismatch, "a commit sharing a shorter prefix does not match")
+
+	_, err := ParseRepositoryRef("gitlab://group/project/-/tocli.yaml?ref=main&sha=f")
+	assert.ErrorIs(t, err, ErrInvalidRepositorySHA, "a single character does not pin a commit")
+
+	unpinned := &RepositoryRef{Project: "group/project", Path: "tocli.yaml"}
+	assert.NoError(t, unpinned.Verify("fedcba98"))
+}
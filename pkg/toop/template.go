@@ -0,0 +1,122 @@
+package toop
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/goccy/go-yaml"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+const (
+	templatesKey = "templates"
+	templateKey  = "template"
+	tokensKey    = "tokens"
+)
+
+// expand returns the configuration with the referenced templates merged into its token entries.
+func expand(data []byte) ([]byte, error) {
+	raw := map[string]any{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	// entries may reference templates that don't exist, also without any templates
+	if err := expandTemplates(raw); err != nil {
+		return nil, err
+	}
+
+	expanded, err := yaml.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand templates: %w", err)
+	}
+
+	return expanded, nil
+}
+
+// expandTemplates merges the referenced template into every token entry of a raw configuration.
+// Each entry is validated after expansion, so that errors point to the originating entry.
+func expandTemplates(raw map[string]any) error {
+	templates, _ := raw[templatesKey].(map[string]any)
+	entries, _ := raw[tokensKey].([]any)
+
+	validate := validator.New()
+	for i, e := range entries {
+		entry, ok := e.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		merged, _, err := expandEntry(entry, templates, validate)
+		if err != nil {
+			return fmt.Errorf("invalid token %s: %w", entryRef(i, entry), err)
+		}
+		entries[i] = merged
+	}
+
+	return nil
+}
+
+// expandEntry merges the referenced template into a token entry and decodes the merged entry with validation.
+func expandEntry(entry map[string]any, templates map[string]any, validate *validator.Validate) (map[string]any, token.Config, error) {
+	name, _ := entry[templateKey].(string)
+	if name != "" {
+		if _, ok := templates[name].(map[string]any); !ok {
+			return nil, token.Config{}, fmt.Errorf("%w: %s", ErrUnknownTemplate, name)
+		}
+	}
+
+	merged := mergeTemplate(entry, templates)
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, token.Config{}, err
+	}
+
+	cfg := token.Config{}
+	dec := yaml.NewDecoder(bytes.NewReader(data), yaml.Validator(validate))
+	if err = dec.Decode(&cfg); err != nil {
+		return nil, token.Config{}, err
+	}
+
+	return merged, cfg, nil
+}
+
+// mergeTemplate returns the entry merged into its referenced template, if the template exists.
+func mergeTemplate(entry map[string]any, templates map[string]any) map[string]any {
+	name, _ := entry[templateKey].(string)
+	tmpl, ok := templates[name].(map[string]any)
+	if !ok {
+		return entry
+	}
+	return deepMerge(tmpl, entry)
+}
+
+// deepMerge returns base overridden by override. Nested objects are merged, any other value is replaced.
+func deepMerge(base, override map[string]any) map[string]any {
+	merged := make(map[string]any, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+
+	for k, v := range override {
+		baseMap, baseOk := merged[k].(map[string]any)
+		overrideMap, overrideOk := v.(map[string]any)
+		if baseOk && overrideOk {
+			merged[k] = deepMerge(baseMap, overrideMap)
+			continue
+		}
+		merged[k] = v
+	}
+
+	return merged
+}
+
+// entryRef describes a token entry for error messages.
+func entryRef(i int, entry map[string]any) string {
+	ref := fmt.Sprintf("tokens[%d] '%v'", i, entry["name"])
+	if name, ok := entry[templateKey].(string); ok && name != "" {
+		ref += fmt.Sprintf(" (template '%s')", name)
+	}
+	return ref
+}
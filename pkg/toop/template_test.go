@@ -0,0 +1,111 @@
+package toop
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const templateConfig = `apiVersion: v1
+default_rotation:
+  rotate_before: 24h
+  validity: 48h
+templates:
+  renovate:
+    state: active
+    rotation:
+      rotate_before: 168h
+      validity: 840h
+    source:
+      type: personal
+      scopes: ["read_api"]
+    vault:
+      path: "renovate"
+      field: "password"
+tokens:
+  - name: "renovate-a"
+    template: renovate
+    source:
+      name: "renovate-a"
+    vault:
+      item: "renovate-a"
+  - name: "renovate-b"
+    template: renovate
+    rotation:
+      validity: 480h
+    source:
+      name: "renovate-b"
+      scopes: ["read_api", "read_repository"]
+    vault:
+      item: "renovate-b"
+`
+
+func TestDecode_Templates(t *testing.T) {
+	config, err := Decode([]byte(templateConfig))
+	require.NoError(t, err)
+	require.Len(t, config.Tokens, 2)
+
+	a := config.Tokens[0]
+	assert.Equal(t, "renovate", a.Template)
+	assert.Equal(t, "renovate-a", a.Source.Name)
+	assert.Equal(t, "personal", a.Source.Type)
+	assert.Equal(t, []string{"read_api"}, a.Source.Scopes)
+	assert.Equal(t, "renovate", a.Vault.Path)
+	assert.Equal(t, "renovate-a", a.Vault.Item)
+	assert.Equal(t, 840*time.Hour, a.Rotation.Validity)
+
+	// nested objects are merged, lists and scalars replaced
+	b := config.Tokens[1]
+	assert.Equal(t, []string{"read_api", "read_repository"}, b.Source.Scopes)
+	assert.Equal(t, 168*time.Hour, b.Rotation.RotateBefore)
+	assert.Equal(t, 480*time.Hour, b.Rotation.Validity)
+	assert.Equal(t, "password", b.Vault.Field)
+}
+
+func TestDecode_TemplateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name: "unknown template",
+			config: `templates: {}
+tokens:
+  - name: "missing"
+    template: nope
+`,
+			wantErr: "invalid token tokens[0] 'missing' (template 'nope'): unknown token template",
+		},
+		{
+			name: "unknown template without templates",
+			config: `apiVersion: v1
+tokens:
+  - name: "missing"
+    template: nope
+`,
+			wantErr: "invalid token tokens[0] 'missing' (template 'nope'): unknown token template: nope",
+		},
+		{
+			name: "invalid expanded entry",
+			config: `templates:
+  partial:
+    state: active
+tokens:
+  - name: "first"
+    template: partial
+`,
+			wantErr: "invalid token tokens[0] 'first' (template 'partial')",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode([]byte(tt.config))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
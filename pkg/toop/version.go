@@ -0,0 +1,149 @@
+package toop
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/hamba/pkg/v2/errors"
+)
+
+const (
+	// APIVersionV0 is assumed for configurations without an apiVersion.
+	APIVersionV0 = "v0"
+	APIVersionV1 = "v1"
+	// APIVersionLatest is the version a configuration is migrated to.
+	APIVersionLatest = APIVersionV1
+
+	apiVersionKey = "apiVersion"
+)
+
+const (
+	ErrUnsupportedAPIVersion = errors.Error("unsupported config apiVersion")
+	ErrInvalidDocument       = errors.Error("config must be a yaml/json object")
+)
+
+// migration upgrades a configuration document in place to the next apiVersion.
+type migration struct {
+	next  string
+	apply func(doc *ast.MappingNode) error
+}
+
+// migrations maps an apiVersion to the migration upgrading it to the next version.
+var migrations = map[string]migration{
+	APIVersionV0: {next: APIVersionV1, apply: migrateV0},
+}
+
+// migrateV0 has nothing to change, v1 only introduced the apiVersion.
+func migrateV0(_ *ast.MappingNode) error {
+	return nil
+}
+
+// Version returns the apiVersion of a configuration.
+func Version(data []byte) (string, error) {
+	probe := struct {
+		APIVersion string `yaml:"apiVersion"`
+	}{}
+	if err := yaml.Unmarshal(data, &probe); err != nil {
+		return "", fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	if probe.APIVersion == "" {
+		return APIVersionV0, nil
+	}
+	return probe.APIVersion, nil
+}
+
+// Migrate rewrites a configuration to the latest apiVersion, preserving its comments.
+func Migrate(data []byte) ([]byte, error) {
+	version, err := Version(data)
+	if err != nil {
+		return nil, err
+	}
+	if version == APIVersionLatest {
+		return data, nil
+	}
+
+	file, err := parser.ParseBytes(data, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if len(file.Docs) == 0 {
+		return nil, ErrInvalidDocument
+	}
+	doc, ok := file.Docs[0].Body.(*ast.MappingNode)
+	if !ok {
+		return nil, ErrInvalidDocument
+	}
+
+	for version != APIVersionLatest {
+		m, ok := migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedAPIVersion, version)
+		}
+
+		if err = m.apply(doc); err != nil {
+			return nil, fmt.Errorf("failed to migrate config from %s to %s: %w", version, m.next, err)
+		}
+		version = m.next
+	}
+
+	if err = setAPIVersion(doc, version); err != nil {
+		return nil, err
+	}
+
+	return []byte(file.String()), nil
+}
+
+// Decode parses a configuration of any supported apiVersion into the latest Config, with templates expanded.
+func Decode(data []byte) (*Config, error) {
+	data, err := Migrate(data)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = expand(data)
+	if err != nil {
+		return nil, err
+	}
+
+	config := Config{}
+	dec := yaml.NewDecoder(
+		bytes.NewReader(data),
+		yaml.Validator(validator.New()),
+	)
+	if err = dec.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	for i := range config.Tokens {
+		config.Tokens[i].Origin = fmt.Sprintf("tokens[%d]", i)
+	}
+
+	return &config, nil
+}
+
+// setAPIVersion sets the apiVersion of a document, adding it as first key if it is missing.
+func setAPIVersion(doc *ast.MappingNode, version string) error {
+	node, err := yaml.ValueToNode(map[string]string{apiVersionKey: version})
+	if err != nil {
+		return fmt.Errorf("failed to create apiVersion: %w", err)
+	}
+	value := node.(*ast.MappingNode).Values[0]
+
+	for _, v := range doc.Values {
+		if v.Key.GetToken().Value != apiVersionKey {
+			continue
+		}
+		if err = value.Value.SetComment(v.Value.GetComment()); err != nil {
+			return fmt.Errorf("failed to keep apiVersion comment: %w", err)
+		}
+		v.Value = value.Value
+		return nil
+	}
+
+	doc.Values = append([]*ast.MappingValueNode{value}, doc.Values...)
+	return nil
+}
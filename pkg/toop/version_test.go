@@ -0,0 +1,89 @@
+package toop
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const v0Config = `# personal tokens
+dry_run: true # only check
+tokens:
+  - name: "some name" # shown in logs
+    state: active
+    source:
+      name: "token name"
+      type: "personal"
+      scopes: ["api"]
+    vault:
+      path: "vault-path"
+      item: "vault item name"
+      field: "password"
+`
+
+func TestVersion(t *testing.T) {
+	version, err := Version([]byte(v0Config))
+	require.NoError(t, err)
+	assert.Equal(t, APIVersionV0, version)
+
+	version, err = Version([]byte(fullConfig))
+	require.NoError(t, err)
+	assert.Equal(t, APIVersionLatest, version)
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    string
+		wantErr error
+	}{
+		{
+			name:   "v0 keeps comments",
+			config: v0Config,
+			want:   "apiVersion: v1\n" + v0Config,
+		},
+		{
+			name:   "latest is unchanged",
+			config: "apiVersion: v1 # latest\ntokens: []\n",
+			want:   "apiVersion: v1 # latest\ntokens: []\n",
+		},
+		{
+			name:   "explicit v0 is replaced",
+			config: "apiVersion: v0 # old\ntokens: []\n",
+			want:   "apiVersion: v1 # old\ntokens: []\n",
+		},
+		{
+			name:    "unknown version",
+			config:  "apiVersion: v42\ntokens: []\n",
+			wantErr: ErrUnsupportedAPIVersion,
+		},
+		{
+			name:    "empty document",
+			config:  "# nothing here\n",
+			wantErr: ErrInvalidDocument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Migrate([]byte(tt.config))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	config, err := Decode([]byte(v0Config))
+	require.NoError(t, err)
+	assert.Equal(t, APIVersionLatest, config.APIVersion)
+	assert.True(t, config.DryRun)
+	assert.Len(t, config.Tokens, 1)
+}
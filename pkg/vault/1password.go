@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"strings"
 	"time"
 
@@ -54,7 +55,9 @@ func (o *OnePassword) WithDryRun(dryRun bool) {
 	o.dryRun = dryRun
 }
 
-func (o *OnePassword) GetItem(vault *token.Vault) (*Item, error) {
+func (o *OnePassword) GetItem(vault *token.Vault) (_ *Item, err error) {
+	defer func() { err = annotate(Type1Password, "get", vault.Item, err) }()
+
 	opvault, err := o.findVault(vault)
 	if err != nil {
 		if !errors.Is(err, ErrVaultNotFound) {
@@ -86,10 +89,13 @@ func (o *OnePassword) GetItem(vault *token.Vault) (*Item, error) {
 	}
 
 	value := ""
+	username := ""
 	for _, field := range secret.Fields {
-		if field.Title == vault.Field {
+		switch field.Title {
+		case vault.Field:
 			value = field.Value
-			break
+		case vault.UsernameFieldOrDefault():
+			username = field.Value
 		}
 	}
 
@@ -98,14 +104,17 @@ func (o *OnePassword) GetItem(vault *token.Vault) (*Item, error) {
 	}
 
 	return &Item{
-		Name:  vault.Item,
-		Path:  vault.Path,
-		Field: vault.Field,
-		Value: value,
+		Name:     vault.Item,
+		Path:     vault.Path,
+		Field:    vault.Field,
+		Value:    value,
+		Username: username,
 	}, nil
 }
 
-func (o *OnePassword) CreateItem(vault *token.Vault, value string) (*Item, error) {
+func (o *OnePassword) CreateItem(vault *token.Vault, tok *token.Token) (_ *Item, err error) {
+	defer func() { err = annotate(Type1Password, "create", vault.Item, err) }()
+
 	opvault, err := o.findVault(vault)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find 1password vault: %w", err)
@@ -115,10 +124,11 @@ func (o *OnePassword) CreateItem(vault *token.Vault, value string) (*Item, error
 	if o.dryRun {
 		o.log.Info("dry-run flag set, not creating 1password vault item", lctx.Str("vault", opvault.ID), lctx.Str("item", vault.Item))
 		return &Item{
-			Name:  vault.Item,
-			Path:  opvault.ID,
-			Field: vault.Field,
-			Value: value,
+			Name:     vault.Item,
+			Path:     opvault.ID,
+			Field:    vault.Field,
+			Value:    tok.Value,
+			Username: tok.Username,
 		}, nil
 	}
 
@@ -131,11 +141,14 @@ func (o *OnePassword) CreateItem(vault *token.Vault, value string) (*Item, error
 			{
 				ID:        vault.Field,
 				Title:     vault.Field,
-				Value:     value,
+				Value:     tok.Value,
 				FieldType: onepassword.ItemFieldTypeConcealed,
 			},
 		},
 	}
+	if tok.Username != "" {
+		create.Fields = append(create.Fields, usernameField(vault, tok.Username))
+	}
 
 	b := o.backoff
 	opitem := onepassword.Item{}
@@ -158,14 +171,17 @@ func (o *OnePassword) CreateItem(vault *token.Vault, value string) (*Item, error
 	)
 
 	return &Item{
-		Name:  opitem.Title,
-		Path:  opitem.VaultID,
-		Field: opitem.Fields[0].Title,
-		Value: opitem.Fields[0].Value,
+		Name:     opitem.Title,
+		Path:     opitem.VaultID,
+		Field:    opitem.Fields[0].Title,
+		Value:    opitem.Fields[0].Value,
+		Username: tok.Username,
 	}, nil
 }
 
-func (o *OnePassword) UpdateItem(vault *token.Vault, value string) error {
+func (o *OnePassword) UpdateItem(vault *token.Vault, tok *token.Token) (err error) {
+	defer func() { err = annotate(Type1Password, "update", vault.Item, err) }()
+
 	opvault, err := o.findVault(vault)
 	if err != nil {
 		return fmt.Errorf("failed to find 1password vault: %w", err)
@@ -185,13 +201,22 @@ func (o *OnePassword) UpdateItem(vault *token.Vault, value string) error {
 			return retryErr
 		}
 
-		// update value of matching field
+		// update value of matching fields, the username field is added if missing
+		hasUsername := false
 		for i, field := range update.Fields {
-			if field.Title == vault.Field {
-				update.Fields[i].Value = value
-				break
+			switch field.Title {
+			case vault.Field:
+				update.Fields[i].Value = tok.Value
+			case vault.UsernameFieldOrDefault():
+				if tok.Username != "" {
+					update.Fields[i].Value = tok.Username
+					hasUsername = true
+				}
 			}
 		}
+		if tok.Username != "" && !hasUsername {
+			update.Fields = append(update.Fields, usernameField(vault, tok.Username))
+		}
 
 		o.log.Debug("updating item in 1password vault", lctx.Str("vault", opvault.ID), lctx.Str("item", vault.Item))
 		if o.dryRun {
@@ -219,7 +244,9 @@ func (o *OnePassword) UpdateItem(vault *token.Vault, value string) error {
 	return nil
 }
 
-func (o *OnePassword) DeleteItem(vault *token.Vault) error {
+func (o *OnePassword) DeleteItem(vault *token.Vault) (err error) {
+	defer func() { err = annotate(Type1Password, "delete", vault.Item, err) }()
+
 	opvault, err := o.findVault(vault)
 	if err != nil {
 		return fmt.Errorf("failed to find 1password vault: %w", err)
@@ -257,6 +284,51 @@ func (o *OnePassword) DeleteItem(vault *token.Vault) error {
 	return nil
 }
 
+// CheckItem checks the vault and item resolve, an existing item is written back unchanged to check write access.
+func (o *OnePassword) CheckItem(vault *token.Vault) (_ *ItemCheck, err error) {
+	defer func() { err = annotate(Type1Password, "check", vault.Item, err) }()
+
+	opvault, err := o.findVault(vault)
+	if err != nil {
+		return nil, err
+	}
+	check := &ItemCheck{VaultID: opvault.ID}
+
+	opitem, err := o.findItem(opvault.ID, vault)
+	if err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			return check, nil
+		}
+		return nil, err
+	}
+	check.ItemID = opitem.ID
+
+	if o.dryRun {
+		o.log.Info("dry-run flag set, not checking write access to 1password vault item", lctx.Str("vault", opvault.ID), lctx.Str("item", vault.Item))
+		return check, nil
+	}
+
+	b := o.backoff
+	err = retry.Do(o.ctx, b, func(ctx context.Context) error {
+		item, err := o.client.Items().Get(o.ctx, opvault.ID, opitem.ID)
+		if retryErr := o.isRetriable(err); retryErr != nil {
+			return retryErr
+		}
+
+		_, err = o.client.Items().Put(o.ctx, item)
+		if retryErr := o.isRetriable(err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write 1password vault item: %w", err)
+	}
+	check.Writable = true
+
+	return check, nil
+}
+
 func (o *OnePassword) findVault(vault *token.Vault) (*onepassword.VaultOverview, error) {
 	b := o.backoff
 	opvaults := []onepassword.VaultOverview{}
@@ -337,15 +409,69 @@ func (o *OnePassword) findItem(vaultID string, vault *token.Vault) (*onepassword
 	return &opitem, nil
 }
 
+func usernameField(vault *token.Vault, username string) onepassword.ItemField {
+	return onepassword.ItemField{
+		ID:        vault.UsernameFieldOrDefault(),
+		Title:     vault.UsernameFieldOrDefault(),
+		Value:     username,
+		FieldType: onepassword.ItemFieldTypeText,
+	}
+}
+
+// onePasswordErrors classifies errors of the 1Password SDK, which only types rate limit errors,
+// by fragments of their message. The first matching fragment wins.
+var onePasswordErrors = []struct {
+	fragment  string
+	kind      token.ErrorKind
+	retriable bool
+}{
+	{"error sending request", token.ErrorKindUnavailable, true},
+	{"connection refused", token.ErrorKindUnavailable, true},
+	{"connection reset", token.ErrorKindUnavailable, true},
+	{"timed out", token.ErrorKindUnavailable, true},
+	{"service unavailable", token.ErrorKindUnavailable, true},
+	{"error resolving secret reference", token.ErrorKindNotFound, false},
+	{"not found", token.ErrorKindNotFound, false},
+	{"invalid service account token", token.ErrorKindUnauthorized, false},
+	{"unauthorized", token.ErrorKindUnauthorized, false},
+	{"authentication", token.ErrorKindUnauthorized, false},
+	{"forbidden", token.ErrorKindForbidden, false},
+	{"permission", token.ErrorKindForbidden, false},
+	{"invalid", token.ErrorKindInvalid, false},
+	{"validation", token.ErrorKindInvalid, false},
+}
+
+// isRetriable retries rate limited requests and transport errors, other errors are permanent.
+// A cancelled context is not retried.
 func (o *OnePassword) isRetriable(err error) error {
-	switch {
-	case err == nil:
+	if err == nil {
 		return nil
-	// TODO: can't we match errors better than through string?
-	case strings.HasPrefix(err.Error(), "error resolving secret reference"):
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 		return err
 	}
 
+	operr := &token.Error{Backend: Type1Password, Kind: token.ErrorKindUnknown, Err: err}
+	var rlerr *onepassword.RateLimitExceededError
+	var neterr net.Error
+	switch {
+	case errors.As(err, &rlerr):
+		operr.Kind, operr.Retriable = token.ErrorKindRateLimited, true
+	case errors.As(err, &neterr):
+		operr.Kind, operr.Retriable = token.ErrorKindUnavailable, true
+	default:
+		msg := strings.ToLower(err.Error())
+		for _, e := range onePasswordErrors {
+			if strings.Contains(msg, e.fragment) {
+				operr.Kind, operr.Retriable = e.kind, e.retriable
+				break
+			}
+		}
+	}
+
+	if !operr.Retriable {
+		return operr
+	}
 	o.log.Debug("retry on err", lctx.Err(err))
-	return retry.RetryableError(err)
+	return retry.RetryableError(operr)
 }
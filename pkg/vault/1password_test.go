@@ -0,0 +1,58 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/1password/onepassword-sdk-go"
+	"github.com/hamba/logger/v2"
+	"github.com/sethvargo/go-retry"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+func TestOnePassword_isRetriable(t *testing.T) {
+	o := &OnePassword{log: logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)}
+
+	tests := []struct {
+		name      string
+		err       error
+		retriable bool
+		kind      token.ErrorKind
+	}{
+		{name: "rate limited", err: fmt.Errorf("failed: %w", &onepassword.RateLimitExceededError{}), retriable: true, kind: token.ErrorKindRateLimited},
+		{name: "transport error", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, retriable: true, kind: token.ErrorKindUnavailable},
+		{name: "request failed", err: errors.New("error sending request for url"), retriable: true, kind: token.ErrorKindUnavailable},
+		{name: "secret reference", err: errors.New("error resolving secret reference: no item matched"), kind: token.ErrorKindNotFound},
+		{name: "unauthorized", err: errors.New("invalid service account token, please make sure you provide a valid token"), kind: token.ErrorKindUnauthorized},
+		{name: "validation", err: errors.New("invalid user input: item title is empty"), kind: token.ErrorKindInvalid},
+		{name: "unknown", err: errors.New("something went wrong"), kind: token.ErrorKindUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			got := retry.Do(context.Background(), retry.WithMaxRetries(1, retry.NewConstant(time.Nanosecond)), func(ctx context.Context) error {
+				attempts++
+				return o.isRetriable(tt.err)
+			})
+
+			assert.Equal(t, tt.retriable, attempts == 2)
+			assert.ErrorIs(t, got, tt.err)
+			var operr *token.Error
+			if assert.ErrorAs(t, got, &operr) {
+				assert.Equal(t, Type1Password, operr.Backend)
+				assert.Equal(t, tt.kind, operr.Kind)
+				assert.Equal(t, tt.retriable, operr.Retriable)
+			}
+		})
+	}
+
+	assert.ErrorIs(t, o.isRetriable(context.Canceled), context.Canceled)
+	assert.NoError(t, o.isRetriable(nil))
+}
@@ -1,8 +1,22 @@
 package vault
 
-import "github.com/hamba/pkg/v2/errors"
+import (
+	"errors"
+
+	errors2 "github.com/hamba/pkg/v2/errors"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
 
 const (
-	ErrItemNotFound  = errors.Error("item not found")
-	ErrVaultNotFound = errors.Error("vault not found")
+	ErrItemNotFound  = errors2.Error("item not found")
+	ErrVaultNotFound = errors2.Error("vault not found")
 )
+
+// annotate wraps err into a token.Error of the operation on the named vault item.
+func annotate(backend, op, name string, err error) error {
+	kind := token.ErrorKindUnknown
+	if errors.Is(err, ErrItemNotFound) || errors.Is(err, ErrVaultNotFound) {
+		kind = token.ErrorKindNotFound
+	}
+	return token.Annotate(err, backend, op, name, kind)
+}
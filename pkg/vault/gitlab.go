@@ -0,0 +1,430 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hamba/cmd/v3/observe"
+	"github.com/hamba/logger/v2"
+	lctx "github.com/hamba/logger/v2/ctx"
+	"github.com/sethvargo/go-retry"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"gitlab.com/sickit/token-operator/pkg/backend"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+const TypeGitLab = "gitlab"
+
+// HiddenValue is the value of hidden variables, GitLab never returns their value.
+const HiddenValue = "[hidden]"
+
+// UsernameKeySuffix is appended to the variable key for the username of a token, if no UsernameField is set.
+const UsernameKeySuffix = "_USERNAME"
+
+func NewGitLabVault(ctx context.Context, url, tok string, obsvr *observe.Observer) (*GitLab, error) {
+	b := retry.NewExponential(50 * time.Millisecond)
+	b = retry.WithMaxRetries(10, b)
+	b = retry.WithMaxDuration(30*time.Second, b)
+
+	// retries are handled by isRetriable, the limiter is shared with sources of the same URL
+	limiter := backend.SharedLimiter(url, backend.RateLimit{})
+	glab, err := gitlab.NewClient(tok,
+		gitlab.WithBaseURL(url),
+		gitlab.WithCustomLimiter(limiter),
+		gitlab.WithCustomRetryMax(0),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gitlab client: %w", err)
+	}
+
+	return &GitLab{
+		client:  glab,
+		limiter: limiter,
+		backoff: b,
+		log:     obsvr.Log,
+		ctx:     ctx,
+	}, nil
+}
+
+// GitLab implements the application TokenVault for GitLab CI/CD variables of projects, groups or the instance.
+// The vault path is the full path of the project or group, the item is the variable key.
+type GitLab struct {
+	client  *gitlab.Client
+	limiter *backend.Limiter
+	dryRun  bool
+	backoff retry.Backoff
+
+	log *logger.Logger
+	ctx context.Context
+}
+
+// variable is a CI/CD variable of a project, group or the instance.
+type variable struct {
+	Key    string
+	Value  string
+	Hidden bool
+}
+
+func (g *GitLab) WithDryRun(dryRun bool) {
+	g.dryRun = dryRun
+}
+
+// GetItem returns the variable of a token, the username variable is not read back.
+func (g *GitLab) GetItem(vault *token.Vault) (_ *Item, err error) {
+	defer func() { err = annotate(TypeGitLab, "get", vault.Item, err) }()
+
+	v, err := g.getVariable(vault, vault.Item)
+	if err != nil {
+		return nil, err
+	}
+
+	value := v.Value
+	if v.Hidden {
+		value = HiddenValue
+	}
+	if value == "" {
+		return nil, ErrItemNotFound
+	}
+
+	return &Item{
+		Name:  vault.Item,
+		Path:  vault.Path,
+		Field: vault.Field,
+		Value: value,
+	}, nil
+}
+
+func (g *GitLab) CreateItem(vault *token.Vault, tok *token.Token) (_ *Item, err error) {
+	defer func() { err = annotate(TypeGitLab, "create", vault.Item, err) }()
+
+	item := &Item{
+		Name:     vault.Item,
+		Path:     vault.Path,
+		Field:    vault.Field,
+		Value:    tok.Value,
+		Username: tok.Username,
+	}
+
+	g.log.Debug("creating gitlab variable", lctx.Str("path", vault.Path), lctx.Str("key", vault.Item))
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not creating gitlab variable", lctx.Str("path", vault.Path), lctx.Str("key", vault.Item))
+		return item, nil
+	}
+
+	if err = g.createVariable(vault, vault.Item, tok.Value, true); err != nil {
+		return nil, err
+	}
+	if tok.Username != "" {
+		if err = g.setVariable(vault, usernameKey(vault), tok.Username); err != nil {
+			return nil, err
+		}
+	}
+
+	g.log.Debug("created gitlab variable", lctx.Str("path", vault.Path), lctx.Str("key", vault.Item))
+
+	return item, nil
+}
+
+func (g *GitLab) UpdateItem(vault *token.Vault, tok *token.Token) (err error) {
+	defer func() { err = annotate(TypeGitLab, "update", vault.Item, err) }()
+
+	g.log.Debug("updating gitlab variable", lctx.Str("path", vault.Path), lctx.Str("key", vault.Item))
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not updating gitlab variable", lctx.Str("path", vault.Path), lctx.Str("key", vault.Item))
+		return nil
+	}
+
+	if err = g.updateVariable(vault, vault.Item, tok.Value, true); err != nil {
+		return err
+	}
+	// the username variable is created if missing
+	if tok.Username != "" {
+		if err = g.setVariable(vault, usernameKey(vault), tok.Username); err != nil {
+			return err
+		}
+	}
+
+	g.log.Debug("updated gitlab variable", lctx.Str("path", vault.Path), lctx.Str("key", vault.Item))
+
+	return nil
+}
+
+func (g *GitLab) DeleteItem(vault *token.Vault) (err error) {
+	defer func() { err = annotate(TypeGitLab, "delete", vault.Item, err) }()
+
+	g.log.Debug("deleting gitlab variable", lctx.Str("path", vault.Path), lctx.Str("key", vault.Item))
+	if g.dryRun {
+		g.log.Info("dry-run flag set, not deleting gitlab variable", lctx.Str("path", vault.Path), lctx.Str("key", vault.Item))
+		return nil
+	}
+
+	if err = g.removeVariable(vault, vault.Item); err != nil {
+		return err
+	}
+	if err = g.removeVariable(vault, usernameKey(vault)); err != nil && !errors.Is(err, ErrItemNotFound) {
+		return err
+	}
+
+	g.log.Debug("deleted gitlab variable", lctx.Str("path", vault.Path), lctx.Str("key", vault.Item))
+
+	return nil
+}
+
+// CheckItem checks the variable resolves without changing it. Reading a variable through the API requires the
+// same role as writing it, so an existing variable is writable.
+func (g *GitLab) CheckItem(vault *token.Vault) (_ *ItemCheck, err error) {
+	defer func() { err = annotate(TypeGitLab, "check", vault.Item, err) }()
+
+	check := &ItemCheck{VaultID: vault.Path}
+	v, err := g.getVariable(vault, vault.Item)
+	if err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			return check, nil
+		}
+		return nil, err
+	}
+	check.ItemID = v.Key
+	check.Writable = true
+
+	return check, nil
+}
+
+func (g *GitLab) getVariable(vault *token.Vault, key string) (*variable, error) {
+	b := g.backoff
+	v := &variable{}
+	resp := &gitlab.Response{}
+	err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		switch ownerType(vault) {
+		case token.VariableOwnerInstance:
+			var glvar *gitlab.InstanceVariable
+			glvar, resp, err = g.client.InstanceVariables.GetVariable(key, gitlab.WithContext(g.ctx))
+			if glvar != nil {
+				v = &variable{Key: glvar.Key, Value: glvar.Value}
+			}
+		case token.VariableOwnerGroup:
+			var glvar *gitlab.GroupVariable
+			opt := &gitlab.GetGroupVariableOptions{Filter: filter(vault)}
+			glvar, resp, err = g.client.GroupVariables.GetVariable(vault.Path, key, opt, gitlab.WithContext(g.ctx))
+			if glvar != nil {
+				v = &variable{Key: glvar.Key, Value: glvar.Value, Hidden: glvar.Hidden}
+			}
+		default:
+			var glvar *gitlab.ProjectVariable
+			opt := &gitlab.GetProjectVariableOptions{Filter: filter(vault)}
+			glvar, resp, err = g.client.ProjectVariables.GetVariable(vault.Path, key, opt, gitlab.WithContext(g.ctx))
+			if glvar != nil {
+				v = &variable{Key: glvar.Key, Value: glvar.Value, Hidden: glvar.Hidden}
+			}
+		}
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gitlab variable: %w", err)
+	}
+
+	return v, nil
+}
+
+// createVariable creates a variable, secret variables are masked and hidden as configured.
+func (g *GitLab) createVariable(vault *token.Vault, key, value string, secret bool) error {
+	opts := vault.Variable
+	masked := secret && (opts.Masked || opts.Hidden)
+	hidden := secret && opts.Hidden
+
+	b := g.backoff
+	resp := &gitlab.Response{}
+	err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		switch ownerType(vault) {
+		case token.VariableOwnerInstance:
+			// instance variables have no environment scope and can't be hidden
+			_, resp, err = g.client.InstanceVariables.CreateVariable(&gitlab.CreateInstanceVariableOptions{
+				Key:       gitlab.Ptr(key),
+				Value:     gitlab.Ptr(value),
+				Masked:    gitlab.Ptr(masked),
+				Protected: gitlab.Ptr(opts.Protected),
+				Raw:       gitlab.Ptr(true),
+			}, gitlab.WithContext(g.ctx))
+		case token.VariableOwnerGroup:
+			_, resp, err = g.client.GroupVariables.CreateVariable(vault.Path, &gitlab.CreateGroupVariableOptions{
+				Key:              gitlab.Ptr(key),
+				Value:            gitlab.Ptr(value),
+				EnvironmentScope: environmentScope(vault),
+				Masked:           gitlab.Ptr(masked),
+				MaskedAndHidden:  gitlab.Ptr(hidden),
+				Protected:        gitlab.Ptr(opts.Protected),
+				Raw:              gitlab.Ptr(true),
+			}, gitlab.WithContext(g.ctx))
+		default:
+			_, resp, err = g.client.ProjectVariables.CreateVariable(vault.Path, &gitlab.CreateProjectVariableOptions{
+				Key:              gitlab.Ptr(key),
+				Value:            gitlab.Ptr(value),
+				EnvironmentScope: environmentScope(vault),
+				Masked:           gitlab.Ptr(masked),
+				MaskedAndHidden:  gitlab.Ptr(hidden),
+				Protected:        gitlab.Ptr(opts.Protected),
+				Raw:              gitlab.Ptr(true),
+			}, gitlab.WithContext(g.ctx))
+		}
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		// the project or group doesn't exist
+		if errors.Is(err, ErrItemNotFound) {
+			return fmt.Errorf("failed to create gitlab variable: %w: %s", ErrVaultNotFound, vault.Path)
+		}
+		return fmt.Errorf("failed to create gitlab variable: %w", err)
+	}
+
+	return nil
+}
+
+// updateVariable updates the value and options of a variable, hidden variables stay hidden.
+func (g *GitLab) updateVariable(vault *token.Vault, key, value string, secret bool) error {
+	opts := vault.Variable
+	masked := secret && (opts.Masked || opts.Hidden)
+
+	b := g.backoff
+	resp := &gitlab.Response{}
+	err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		switch ownerType(vault) {
+		case token.VariableOwnerInstance:
+			_, resp, err = g.client.InstanceVariables.UpdateVariable(key, &gitlab.UpdateInstanceVariableOptions{
+				Value:     gitlab.Ptr(value),
+				Masked:    gitlab.Ptr(masked),
+				Protected: gitlab.Ptr(opts.Protected),
+				Raw:       gitlab.Ptr(true),
+			}, gitlab.WithContext(g.ctx))
+		case token.VariableOwnerGroup:
+			_, resp, err = g.client.GroupVariables.UpdateVariable(vault.Path, key, &gitlab.UpdateGroupVariableOptions{
+				Value:            gitlab.Ptr(value),
+				EnvironmentScope: environmentScope(vault),
+				Filter:           filter(vault),
+				Masked:           gitlab.Ptr(masked),
+				Protected:        gitlab.Ptr(opts.Protected),
+				Raw:              gitlab.Ptr(true),
+			}, gitlab.WithContext(g.ctx))
+		default:
+			_, resp, err = g.client.ProjectVariables.UpdateVariable(vault.Path, key, &gitlab.UpdateProjectVariableOptions{
+				Value:            gitlab.Ptr(value),
+				EnvironmentScope: environmentScope(vault),
+				Filter:           filter(vault),
+				Masked:           gitlab.Ptr(masked),
+				Protected:        gitlab.Ptr(opts.Protected),
+				Raw:              gitlab.Ptr(true),
+			}, gitlab.WithContext(g.ctx))
+		}
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update gitlab variable: %w", err)
+	}
+
+	return nil
+}
+
+// setVariable updates a plain variable, or creates it if missing.
+func (g *GitLab) setVariable(vault *token.Vault, key, value string) error {
+	err := g.updateVariable(vault, key, value, false)
+	if errors.Is(err, ErrItemNotFound) {
+		return g.createVariable(vault, key, value, false)
+	}
+	return err
+}
+
+func (g *GitLab) removeVariable(vault *token.Vault, key string) error {
+	b := g.backoff
+	resp := &gitlab.Response{}
+	err := retry.Do(g.ctx, b, func(ctx context.Context) error {
+		var err error
+		switch ownerType(vault) {
+		case token.VariableOwnerInstance:
+			resp, err = g.client.InstanceVariables.RemoveVariable(key, gitlab.WithContext(g.ctx))
+		case token.VariableOwnerGroup:
+			opt := &gitlab.RemoveGroupVariableOptions{Filter: filter(vault)}
+			resp, err = g.client.GroupVariables.RemoveVariable(vault.Path, key, opt, gitlab.WithContext(g.ctx))
+		default:
+			opt := &gitlab.RemoveProjectVariableOptions{Filter: filter(vault)}
+			resp, err = g.client.ProjectVariables.RemoveVariable(vault.Path, key, opt, gitlab.WithContext(g.ctx))
+		}
+		if retryErr := g.isRetriable(resp, err); retryErr != nil {
+			return retryErr
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete gitlab variable: %w", err)
+	}
+
+	return nil
+}
+
+// isRetriable classifies the result of a request, see backend.Classify. A missing variable is ErrItemNotFound.
+func (g *GitLab) isRetriable(resp *gitlab.Response, err error) error {
+	if resp == nil || resp.Response == nil {
+		if err != nil {
+			g.log.Debug("retry on err", lctx.Err(err))
+		}
+		return backend.Classify(TypeGitLab, nil, err)
+	}
+
+	if g.limiter != nil {
+		if wait := g.limiter.Observe(resp.Response); wait > 0 {
+			g.log.Info("rate limit reached, pausing requests", lctx.Duration("wait", wait), lctx.Str("status", resp.Status))
+		}
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		g.log.Debug("retry on server error", lctx.Str("status", resp.Status))
+	}
+
+	err = backend.Classify(TypeGitLab, resp.Response, err)
+	var glerr *token.Error
+	if errors.Is(err, backend.ErrNotFound) && errors.As(err, &glerr) {
+		glerr.Err = ErrItemNotFound
+	}
+	return err
+}
+
+func ownerType(vault *token.Vault) string {
+	if vault.Variable.OwnerType == "" {
+		return token.VariableOwnerProject
+	}
+	return vault.Variable.OwnerType
+}
+
+// usernameKey returns the variable key for the username of a token.
+func usernameKey(vault *token.Vault) string {
+	if vault.UsernameField != "" {
+		return vault.UsernameField
+	}
+	return vault.Item + UsernameKeySuffix
+}
+
+func environmentScope(vault *token.Vault) *string {
+	if vault.Variable.EnvironmentScope == "" {
+		return nil
+	}
+	return gitlab.Ptr(vault.Variable.EnvironmentScope)
+}
+
+// filter selects the variable of the configured environment scope, keys are only unique per scope.
+func filter(vault *token.Vault) *gitlab.VariableFilter {
+	if vault.Variable.EnvironmentScope == "" {
+		return nil
+	}
+	return &gitlab.VariableFilter{EnvironmentScope: vault.Variable.EnvironmentScope}
+}
@@ -0,0 +1,221 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hamba/cmd/v3/observe"
+	"github.com/hamba/logger/v2"
+	"github.com/sethvargo/go-retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/sickit/token-operator/pkg/token"
+)
+
+func newTestGitLabVault(t *testing.T, mux *http.ServeMux) *GitLab {
+	t.Helper()
+
+	srv := httptest.NewServer(http.StripPrefix("/api/v4", mux))
+	t.Cleanup(srv.Close)
+
+	obsvr := &observe.Observer{Log: logger.New(io.Discard, logger.LogfmtFormat(), logger.Error)}
+	g, err := NewGitLabVault(context.Background(), srv.URL+"/api/v4", "secret", obsvr)
+	require.NoError(t, err)
+	g.backoff = retry.WithMaxRetries(3, retry.NewConstant(time.Millisecond))
+
+	return g
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func decodeJSON(t *testing.T, r *http.Request) map[string]any {
+	t.Helper()
+
+	body := map[string]any{}
+	require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+	return body
+}
+
+// variableOwners are the owner types of variables with the path of their API.
+var variableOwners = []struct {
+	name   string
+	vault  token.Vault
+	prefix string
+}{
+	{
+		name:   "project",
+		vault:  token.Vault{Path: "group/project", Item: "SOME_TOKEN"},
+		prefix: "/projects/{id}/variables",
+	},
+	{
+		name:   "group",
+		vault:  token.Vault{Path: "group", Item: "SOME_TOKEN", Variable: token.Variable{OwnerType: token.VariableOwnerGroup}},
+		prefix: "/groups/{id}/variables",
+	},
+	{
+		name:   "instance",
+		vault:  token.Vault{Item: "SOME_TOKEN", Variable: token.Variable{OwnerType: token.VariableOwnerInstance}},
+		prefix: "/admin/ci/variables",
+	},
+}
+
+func TestGitLab_GetItem(t *testing.T) {
+	for _, owner := range variableOwners {
+		t.Run(owner.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET "+owner.prefix+"/{key}", func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, owner.vault.Path, r.PathValue("id"))
+				if r.PathValue("key") != "SOME_TOKEN" {
+					writeJSON(w, http.StatusNotFound, map[string]any{"message": "404 Variable Not Found"})
+					return
+				}
+				writeJSON(w, http.StatusOK, map[string]any{"key": "SOME_TOKEN", "value": "glpat-secret"})
+			})
+			g := newTestGitLabVault(t, mux)
+
+			item, err := g.GetItem(&owner.vault)
+			require.NoError(t, err)
+			assert.Equal(t, "glpat-secret", item.Value)
+
+			missing := owner.vault
+			missing.Item = "OTHER_TOKEN"
+			_, err = g.GetItem(&missing)
+			assert.ErrorIs(t, err, ErrItemNotFound)
+		})
+	}
+}
+
+func TestGitLab_GetItemHidden(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /projects/{id}/variables/{key}", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"key": "SOME_TOKEN", "hidden": true})
+	})
+	g := newTestGitLabVault(t, mux)
+
+	item, err := g.GetItem(&token.Vault{Path: "group/project", Item: "SOME_TOKEN"})
+
+	require.NoError(t, err)
+	assert.Equal(t, HiddenValue, item.Value)
+}
+
+func TestGitLab_CreateItem(t *testing.T) {
+	for _, owner := range variableOwners {
+		t.Run(owner.name, func(t *testing.T) {
+			created := map[string]map[string]any{}
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST "+owner.prefix, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, owner.vault.Path, r.PathValue("id"))
+				body := decodeJSON(t, r)
+				created[body["key"].(string)] = body
+				writeJSON(w, http.StatusCreated, body)
+			})
+			// the username variable doesn't exist yet
+			mux.HandleFunc("PUT "+owner.prefix+"/{key}", func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(w, http.StatusNotFound, map[string]any{"message": "404 Variable Not Found"})
+			})
+			g := newTestGitLabVault(t, mux)
+			vault := owner.vault
+			vault.Variable.Masked = true
+			vault.Variable.Protected = true
+
+			_, err := g.CreateItem(&vault, &token.Token{Value: "gldt-secret", Username: "gitlab+deploy-token-1"})
+			require.NoError(t, err)
+
+			require.Contains(t, created, "SOME_TOKEN")
+			assert.Equal(t, "gldt-secret", created["SOME_TOKEN"]["value"])
+			assert.Equal(t, true, created["SOME_TOKEN"]["masked"])
+			assert.Equal(t, true, created["SOME_TOKEN"]["protected"])
+			assert.Equal(t, true, created["SOME_TOKEN"]["raw"])
+			require.Contains(t, created, "SOME_TOKEN_USERNAME")
+			assert.Equal(t, "gitlab+deploy-token-1", created["SOME_TOKEN_USERNAME"]["value"])
+			assert.Equal(t, false, created["SOME_TOKEN_USERNAME"]["masked"])
+		})
+	}
+}
+
+func TestGitLab_CreateItemVaultNotFound(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /projects/{id}/variables", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"message": "404 Project Not Found"})
+	})
+	g := newTestGitLabVault(t, mux)
+
+	_, err := g.CreateItem(&token.Vault{Path: "group/missing", Item: "SOME_TOKEN"}, &token.Token{Value: "glpat-secret"})
+
+	assert.ErrorIs(t, err, ErrVaultNotFound)
+}
+
+func TestGitLab_UpdateItem(t *testing.T) {
+	for _, owner := range variableOwners {
+		t.Run(owner.name, func(t *testing.T) {
+			updated := map[string]map[string]any{}
+			mux := http.NewServeMux()
+			mux.HandleFunc("PUT "+owner.prefix+"/{key}", func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, owner.vault.Path, r.PathValue("id"))
+				body := decodeJSON(t, r)
+				updated[r.PathValue("key")] = body
+				writeJSON(w, http.StatusOK, body)
+			})
+			mux.HandleFunc("POST "+owner.prefix, func(w http.ResponseWriter, _ *http.Request) {
+				t.Error("existing variables must not be created")
+				w.WriteHeader(http.StatusBadRequest)
+			})
+			g := newTestGitLabVault(t, mux)
+			vault := owner.vault
+			vault.Variable.Masked = true
+
+			err := g.UpdateItem(&vault, &token.Token{Value: "gldt-rotated", Username: "gitlab+deploy-token-2"})
+			require.NoError(t, err)
+
+			require.Contains(t, updated, "SOME_TOKEN")
+			assert.Equal(t, "gldt-rotated", updated["SOME_TOKEN"]["value"])
+			assert.Equal(t, true, updated["SOME_TOKEN"]["masked"])
+			require.Contains(t, updated, "SOME_TOKEN_USERNAME")
+			assert.Equal(t, "gitlab+deploy-token-2", updated["SOME_TOKEN_USERNAME"]["value"])
+		})
+	}
+}
+
+func TestGitLab_UpdateItemEnvironmentScope(t *testing.T) {
+	var body map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /projects/{id}/variables/{key}", func(w http.ResponseWriter, r *http.Request) {
+		body = decodeJSON(t, r)
+		writeJSON(w, http.StatusOK, body)
+	})
+	g := newTestGitLabVault(t, mux)
+	vault := &token.Vault{Path: "group/project", Item: "SOME_TOKEN", Variable: token.Variable{EnvironmentScope: "production"}}
+
+	err := g.UpdateItem(vault, &token.Token{Value: "glpat-rotated"})
+
+	require.NoError(t, err)
+	assert.Equal(t, "production", body["environment_scope"])
+	assert.Equal(t, map[string]any{"environment_scope": "production"}, body["filter"])
+}
+
+func TestGitLab_CheckItem(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /projects/{id}/variables/{key}", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"key": "SOME_TOKEN", "value": "glpat-secret"})
+	})
+	mux.HandleFunc("PUT /projects/{id}/variables/{key}", func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("check must not write the variable")
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	g := newTestGitLabVault(t, mux)
+
+	check, err := g.CheckItem(&token.Vault{Path: "group/project", Item: "SOME_TOKEN"})
+
+	require.NoError(t, err)
+	assert.Equal(t, "SOME_TOKEN", check.ItemID)
+	assert.True(t, check.Writable)
+}
@@ -6,5 +6,16 @@ type Item struct {
 	Path  string
 	Field string
 	Value string
+	// Username is the value of the username field, if any
+	Username string
 	// TODO: Tags []string
 }
+
+// ItemCheck is the result of checking a vault item
+type ItemCheck struct {
+	VaultID string
+	// ItemID is empty if the item doesn't exist yet
+	ItemID string
+	// Writable is true if write access to the item was verified, it is never checked for missing items
+	Writable bool
+}